@@ -4,15 +4,25 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/http"
 
 	"os"
+	"strconv"
 	"time"
 	"strings"
 	"backgo/internal/handler"
 	"backgo/internal/infoDB"
+	"backgo/internal/jwtkeys"
+	"backgo/internal/mailer"
 	"backgo/internal/middleware"
+	"backgo/internal/moderation"
+	"backgo/internal/oidc"
+	"backgo/internal/passwordpolicy"
+	"backgo/internal/ratelimit"
+	"backgo/internal/storage"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	_ "github.com/lib/pq"
 
 	_ "backgo/docs"
@@ -57,36 +67,278 @@ func initDB() {
 	log.Println("Connected to the database successfully!")
 }
 
-var allowedOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000",
-							"http://127.0.0.1:8080","http://localhost:8080"}
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func initPasswordPolicy() {
+	policy := passwordpolicy.Default()
+	policy.MinLength = getEnvInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	policy.MinClasses = getEnvInt("PASSWORD_MIN_CLASSES", policy.MinClasses)
+	policy.MinScore = getEnvInt("PASSWORD_MIN_SCORE", policy.MinScore)
+	infoDB.SetPasswordPolicy(policy)
+}
 
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
+func initLoginLockout() {
+	username, ip := infoDB.LoginLockoutPolicies()
+	username.MaxFailures = getEnvInt("LOGIN_MAX_FAILURES_PER_USER", username.MaxFailures)
+	ip.MaxFailures = getEnvInt("LOGIN_MAX_FAILURES_PER_IP", ip.MaxFailures)
+	lockout := time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", int(username.LockoutDuration/time.Minute))) * time.Minute
+	window := time.Duration(getEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", int(username.Window/time.Minute))) * time.Minute
+	username.LockoutDuration, ip.LockoutDuration = lockout, lockout
+	username.Window, ip.Window = window, window
+	infoDB.SetLoginLockoutPolicies(username, ip)
+}
 
-		allowed := false
-		for _, allowedOrigin := range allowedOrigins {
-			if origin == allowedOrigin || strings.HasPrefix(origin, allowedOrigin) {
-				allowed = true
-				break
-			}
+// initTrustedProxies sets whose X-Forwarded-For and X-Real-IP headers are
+// believed. By default nobody's are and the client IP, which keys login
+// lockouts and rate limits and is recorded on sessions and audit entries, is
+// the connection's peer address. Behind a reverse proxy, set TRUSTED_PROXIES
+// to its addresses or CIDR ranges.
+func initTrustedProxies(r *gin.Engine) {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
 		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+}
+
+// moderationAction reads a content filter action: off, mask, hold or reject.
+func moderationAction(key string, fallback moderation.Action) moderation.Action {
+	action, err := moderation.ParseAction(getEnv(key, fallback.String()))
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return action
+}
+
+func initModeration() {
+	policy := moderation.Default()
+	policy.MaxLinks = getEnvInt("MODERATION_MAX_LINKS", policy.MaxLinks)
+	policy.LinkAction = moderationAction("MODERATION_LINK_ACTION", policy.LinkAction)
+	policy.RepeatAction = moderationAction("MODERATION_REPEAT_ACTION", policy.RepeatAction)
+	policy.DuplicateAction = moderationAction("MODERATION_DUPLICATE_ACTION", policy.DuplicateAction)
+	policy.DuplicateWindow = time.Duration(getEnvInt("MODERATION_DUPLICATE_WINDOW_HOURS", int(policy.DuplicateWindow/time.Hour))) * time.Hour
+	policy.SimilarityWindow = time.Duration(getEnvInt("MODERATION_SIMILARITY_WINDOW_HOURS", int(policy.SimilarityWindow/time.Hour))) * time.Hour
+	policy.SameUserSimilarity = float64(getEnvInt("MODERATION_SAME_USER_SIMILARITY_PERCENT", int(policy.SameUserSimilarity*100))) / 100
+	policy.SameUserAction = moderationAction("MODERATION_SAME_USER_SIMILAR_ACTION", policy.SameUserAction)
+	policy.CrossUserSimilarity = float64(getEnvInt("MODERATION_CROSS_USER_SIMILARITY_PERCENT", int(policy.CrossUserSimilarity*100))) / 100
+	policy.CrossUserAction = moderationAction("MODERATION_CROSS_USER_SIMILAR_ACTION", policy.CrossUserAction)
+	infoDB.SetModerationPolicy(policy)
+}
+
+func initKeyring() {
+	infoDB.SetTokenIssuer(getEnv("JWT_ISSUER", "cat-breeds-api"), getEnv("JWT_AUDIENCE", "cat-breeds"))
+
+	dir := getEnv("JWT_KEYS_DIR", "")
+	if dir == "" {
+		ring, err := jwtkeys.NewEphemeral()
+		if err != nil {
+			log.Fatal("Failed to generate signing key:", err)
+		}
+		infoDB.SetKeyring(ring)
+		log.Printf("JWT_KEYS_DIR not set, signing tokens with ephemeral key %s", ring.Active().ID)
+		return
+	}
+
+	ring, err := jwtkeys.LoadDir(dir, getEnv("JWT_ACTIVE_KID", ""))
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	infoDB.SetKeyring(ring)
+	log.Printf("Signing tokens with key %s from %s", ring.Active().ID, dir)
+}
+
+// initOIDCProviders reads OIDC_PROVIDERS, a comma separated list of names,
+// and for each name the OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optional _SCOPES variables.
+func initOIDCProviders() {
+	apiBaseURL := strings.TrimRight(getEnv("API_BASE_URL", "http://localhost:8080"), "/")
+
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := getEnv(prefix+"ISSUER", "")
+		clientID := getEnv(prefix+"CLIENT_ID", "")
+		if issuer == "" || clientID == "" {
+			log.Fatalf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		var scopes []string
+		if raw := getEnv(prefix+"SCOPES", ""); raw != "" {
+			scopes = strings.Fields(strings.ReplaceAll(raw, ",", " "))
+		}
+
+		redirectURL := apiBaseURL + "/api/auth/oidc/" + name + "/callback"
+		providers[name] = oidc.NewProvider(name, issuer, clientID, getEnv(prefix+"CLIENT_SECRET", ""), redirectURL, scopes)
+		log.Printf("Social login enabled for %s (%s)", name, issuer)
+	}
+	handler.SetOIDCProviders(providers)
+}
+
+func initMailer() {
+	from := getEnv("MAIL_FROM", "Cat Breeds <no-reply@catbreeds.local>")
+
+	if host := getEnv("SMTP_HOST", ""); host != "" {
+		handler.SetMailer(mailer.NewSMTPMailer(host, getEnv("SMTP_PORT", "587"),
+			getEnv("SMTP_USERNAME", ""), getEnv("SMTP_PASSWORD", ""), from))
+		log.Printf("Sending mail through SMTP server %s", host)
+		return
+	}
 
-		if allowed {
-			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+	// Message bodies hold live reset and verification links, so they are
+	// only written to disk when MAIL_OUTBOX_DIR asks for it.
+	outbox, err := mailer.NewOutboxMailer(getEnv("MAIL_OUTBOX_DIR", ""), from)
+	if err != nil {
+		log.Fatal("Failed to initialise mail outbox:", err)
+	}
+	handler.SetMailer(outbox)
+	if outbox.Dir == "" {
+		log.Printf("SMTP_HOST not set, mail is not delivered; set MAIL_OUTBOX_DIR to keep it")
+	} else {
+		log.Printf("SMTP_HOST not set, writing mail to outbox %s", outbox.Dir)
+	}
+}
+
+// initCookies reads COOKIE_SECURE, COOKIE_SAMESITE (lax, strict or none) and
+// COOKIE_DOMAIN.
+func initCookies() {
+	var sameSite http.SameSite
+	switch strings.ToLower(getEnv("COOKIE_SAMESITE", "lax")) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	default:
+		log.Fatal("COOKIE_SAMESITE must be lax, strict or none")
+	}
+
+	secure := getEnv("COOKIE_SECURE", "false") == "true"
+	if sameSite == http.SameSiteNoneMode && !secure {
+		log.Fatal("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+	}
+	handler.SetCookieOptions(secure, sameSite, getEnv("COOKIE_DOMAIN", ""))
+}
+
+// rateLimitPolicy reads RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_LOGIN=10/1m or
+// RATE_LIMIT_DISCUSSIONS=20/1h,burst=5.
+func rateLimitPolicy(name, fallback string) ratelimit.Policy {
+	policy, err := ratelimit.ParsePolicy(name, getEnv("RATE_LIMIT_"+strings.ToUpper(name), fallback))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return policy
+}
+
+// startTokenSweeper periodically removes expired and long-revoked refresh
+// tokens so the table does not grow without bound.
+func startTokenSweeper(interval, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := infoDB.SweepRefreshTokens(retention)
+			if err != nil {
+				log.Printf("Refresh token sweep failed: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Refresh token sweep removed %d rows", removed)
+			}
 		}
+	}()
+}
 
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+// startSanctionSweeper settles sanctions that have run out, restoring
+// reaction weights and ratings that depend on them.
+func startSanctionSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			expired, err := infoDB.ExpireSanctions()
+			if err != nil {
+				log.Printf("Sanction sweep failed: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("Sanction sweep ended %d expired sanctions", expired)
+			}
+		}
+	}()
+}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+// corsOrigins reads a comma-separated origin list such as
+// "https://app.example.com,https://*.example.com".
+func corsOrigins(key, fallback string) []string {
+	var origins []string
+	for _, origin := range strings.Split(getEnv(key, fallback), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
 		}
+	}
+	return origins
+}
 
-		c.Next()
+// initCORS builds the CORS rules. CORS_ALLOWED_ORIGINS applies to the API,
+// CORS_ADMIN_ORIGINS (defaulting to the same list) to /api/admin, and media
+// files may be embedded from anywhere without credentials. CORS_MAX_AGE is
+// how long, in seconds, browsers may cache a preflight.
+func initCORS() gin.HandlerFunc {
+	apiOrigins := corsOrigins("CORS_ALLOWED_ORIGINS",
+		"http://localhost:3000,http://127.0.0.1:3000,http://localhost:8080,http://127.0.0.1:8080")
+	adminOrigins := corsOrigins("CORS_ADMIN_ORIGINS", strings.Join(apiOrigins, ","))
+	maxAge := time.Duration(getEnvInt("CORS_MAX_AGE", 600)) * time.Second
+
+	api := middleware.CORSPolicy{
+		AllowedOrigins:   apiOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
+		AllowCredentials: true,
+		MaxAge:           maxAge,
 	}
+	admin := api
+	admin.AllowedOrigins = adminOrigins
+
+	return middleware.CORS(
+		middleware.CORSRule{PathPrefix: "/api/", Policy: api},
+		middleware.CORSRule{PathPrefix: "/api/admin/", Policy: admin},
+		middleware.CORSRule{PathPrefix: "/media/", Policy: middleware.CORSPolicy{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "HEAD"},
+			MaxAge:         maxAge,
+		}},
+	)
+}
+
+// swaggerCSP lets the Swagger UI load its own scripts and styles, which the
+// API-wide policy forbids.
+const swaggerCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+// initSecurityHeaders reads HSTS_MAX_AGE (seconds, 0 disables HSTS),
+// HSTS_INCLUDE_SUBDOMAINS, HSTS_PRELOAD and CONTENT_SECURITY_POLICY.
+func initSecurityHeaders() gin.HandlerFunc {
+	cfg := middleware.DefaultSecurityHeaders
+	cfg.ContentSecurityPolicy = getEnv("CONTENT_SECURITY_POLICY", cfg.ContentSecurityPolicy)
+	cfg.HSTSMaxAge = time.Duration(getEnvInt("HSTS_MAX_AGE", 0)) * time.Second
+	cfg.HSTSIncludeSubdomains = getEnv("HSTS_INCLUDE_SUBDOMAINS", "false") == "true"
+	cfg.HSTSPreload = getEnv("HSTS_PRELOAD", "false") == "true"
+	return middleware.SecurityHeaders(cfg)
 }
 
 // @title           Cat Breeds API
@@ -97,17 +349,71 @@ func corsMiddleware() gin.HandlerFunc {
 // @BasePath        /api
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAuditCommand(os.Args[2:]))
+	}
+
 	initDB()
 	infoDB.SetDB(db)
 	defer db.Close()
 
+	mediaDir := getEnv("MEDIA_DIR", "./media")
+	store, err := storage.NewLocalStore(mediaDir, getEnv("MEDIA_BASE_URL", "/media"))
+	if err != nil {
+		log.Fatal("Failed to initialise media storage:", err)
+	}
+	handler.SetBlobStore(store)
+
+	initKeyring()
+	initMailer()
+	initOIDCProviders()
+	startTokenSweeper(1*time.Hour, infoDB.RefreshTokenTTL)
+	startSanctionSweeper(1 * time.Minute)
+	infoDB.StartAuditWriter(getEnvInt("AUDIT_BUFFER", infoDB.DefaultAuditBuffer))
+	if path := getEnv("AUDIT_CHECKPOINT_FILE", ""); path != "" {
+		startAuditCheckpointer(path, time.Duration(getEnvInt("AUDIT_CHECKPOINT_MINUTES", 60))*time.Minute)
+	}
+	initPasswordPolicy()
+	initLoginLockout()
+	initModeration()
+	initCookies()
+	handler.SetAppBaseURL(getEnv("APP_BASE_URL", "http://localhost:3000"))
+	// Databases from before email verification need
+	// upgrade/028_email_verification.sql, or their users cannot log in.
+	handler.SetRequireEmailVerification(getEnv("REQUIRE_EMAIL_VERIFICATION", "true") == "true")
+	handler.SetMFAIssuer(getEnv("MFA_ISSUER", "Cat Breeds"))
+	middleware.SetAPIKeyRateLimit(getEnvInt("API_KEY_RATE_LIMIT", 120))
+	// e.g. MFA_REQUIRED_ROLES=admin,moderator
+	infoDB.SetMFARequiredRoles(strings.Split(getEnv("MFA_REQUIRED_ROLES", ""), ","))
+
+	loginLimit := middleware.RateLimit(rateLimitPolicy("login", "10/1m"))
+	registerLimit := middleware.RateLimit(rateLimitPolicy("register", "5/1h,burst=3"))
+	recoveryLimit := middleware.RateLimit(rateLimitPolicy("recovery", "5/15m"))
+	discussionLimit := middleware.RateLimit(rateLimitPolicy("discussions", "10/1m,burst=5"))
+	reactionLimit := middleware.RateLimit(rateLimitPolicy("reactions", "60/1m"))
+
+	// Request structs are strict: a misspelt or unexpected field is a 400
+	// rather than silently ignored.
+	binding.EnableDecoderDisallowUnknownFields = true
+	// A discussion is at most 2000 characters, so its body never needs more
+	// than a few KB even fully escaped.
+	discussionBody := middleware.MaxBodySize(int64(getEnvInt("MAX_DISCUSSION_BODY_BYTES", 16<<10)))
+	jsonBody := middleware.MaxBodySize(int64(getEnvInt("MAX_BODY_BYTES", 256<<10)))
+
 	r := gin.Default()
+	r.MaxMultipartMemory = 8 << 20
+	initTrustedProxies(r)
 
-	r.Use(corsMiddleware())
+	r.Use(initCORS(), initSecurityHeaders())
 
-    r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+    r.GET("/swagger/*any", middleware.ContentSecurityPolicy(swaggerCSP), ginSwagger.WrapHandler(swaggerFiles.Handler))
+	// Media files are named by content hash, so a URL never changes what it
+	// serves and browsers can keep it as long as they like.
+	media := r.Group("/media", middleware.CacheControl(fmt.Sprintf("public, max-age=%d, immutable", getEnvInt("MEDIA_CACHE_MAX_AGE", 365*24*60*60))))
+	media.Static("/", mediaDir)
+	r.GET("/.well-known/jwks.json", handler.JWKSHandler)
 
-	public := r.Group("/api")
+	public := r.Group("/api", jsonBody, middleware.RequireJSON())
 	{
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{
@@ -116,40 +422,129 @@ func main() {
 			})
 		})
 
-		public.POST("/users", handler.RegisterHandler)
+		public.POST("/users", registerLimit, handler.RegisterHandler)
+		public.GET("/users/:username", middleware.OptionalAuth(), handler.GetUserProfileHandler)
+		public.GET("/users/:username/discussions", middleware.OptionalAuth(), handler.GetUserDiscussionsHandler)
 
 		auth := public.Group("/auth")
 		{
-			auth.POST("/login", handler.LoginHandler)
-			auth.POST("/refresh", handler.RefreshTokenHandler)
-			auth.POST("/logout", handler.LogoutHandler)
+			auth.POST("/login", loginLimit, handler.LoginHandler)
+			auth.POST("/refresh", middleware.CSRFForCookie("refresh_token"), handler.RefreshTokenHandler)
+			auth.POST("/logout", middleware.CSRFForCookie("refresh_token"), handler.LogoutHandler)
+			auth.POST("/password/forgot", recoveryLimit, handler.ForgotPasswordHandler)
+			auth.POST("/password/reset", recoveryLimit, handler.ResetPasswordHandler)
+			auth.POST("/verify-email", recoveryLimit, handler.VerifyEmailHandler)
+			auth.POST("/verify-email/resend", recoveryLimit, handler.ResendVerificationHandler)
+			auth.POST("/mfa/verify", loginLimit, handler.VerifyMFALoginHandler)
+
+			auth.GET("/oidc/providers", handler.GetOIDCProvidersHandler)
+			auth.GET("/oidc/:provider/login", handler.OIDCLoginHandler)
+			auth.GET("/oidc/:provider/callback", handler.OIDCCallbackHandler)
 		}
 
 		public.GET("/cats", handler.GetAllCatsHandler)
-		public.GET("/cats/:id", handler.GetCatHandler)
-		public.GET("/cats/:id/reactions", handler.GetCatReactionStatsHandler)
-		public.GET("/cats/:id/discussions", handler.GetCatDiscussionsHandler)
+		public.GET("/cats/:id", middleware.OptionalAuth(), handler.GetCatHandler)
+		public.GET("/cats/:id/reactions", middleware.OptionalAuth(), handler.GetCatReactionStatsHandler)
+		public.GET("/cats/:id/discussions", middleware.OptionalAuth(), handler.GetCatDiscussionsHandler)
 	}
 
+	// Routes that only touch the caller's own account need no permission,
+	// so that a user stripped of every role can still log out.
 	user := r.Group("/api")
-	user.Use(middleware.AuthMiddleware())
+	user.Use(middleware.AuthMiddleware(), jsonBody, middleware.RequireJSON())
 	{
 		user.GET("/auth/me", handler.GetMeHandler)
+		user.PATCH("/auth/me", middleware.RequirePermission(infoDB.PermProfileWrite), handler.UpdateMeHandler)
+
+		// Credentials are managed from a real session only, never with an API key.
+		account := user.Group("/auth", middleware.RequireSession())
+		account.POST("/password", loginLimit, handler.ChangePasswordHandler)
+		account.GET("/sessions", handler.GetSessionsHandler)
+		account.DELETE("/sessions", handler.RevokeAllSessionsHandler)
+		account.DELETE("/sessions/:id", handler.RevokeSessionHandler)
+		account.GET("/mfa", handler.GetMFAStatusHandler)
+		account.DELETE("/mfa", loginLimit, handler.DisableMFAHandler)
+		account.POST("/mfa/enroll", handler.EnrollMFAHandler)
+		account.POST("/mfa/confirm", handler.ConfirmMFAHandler)
+		account.POST("/mfa/recovery-codes", handler.RegenerateRecoveryCodesHandler)
+		account.GET("/api-keys", handler.GetAPIKeysHandler)
+		account.POST("/api-keys", handler.CreateAPIKeyHandler)
+		account.DELETE("/api-keys/:id", handler.RevokeAPIKeyHandler)
+
 		user.GET("/discussions/me", handler.GetMyDiscussionsHandler)
-		user.POST("/cats/:id/react", handler.ToggleCatReactionHandler)
+		user.POST("/cats/:id/react", reactionLimit, middleware.RequirePermission(infoDB.PermReactionsWrite), handler.ToggleCatReactionHandler)
+
+		user.POST("/discussions", discussionBody, discussionLimit, middleware.RequirePermission(infoDB.PermDiscussionsWrite), handler.CreateDiscussionHandler)
+		user.PUT("/discussions/:id", discussionBody, discussionLimit, middleware.RequirePermission(infoDB.PermDiscussionsWrite), handler.UpdateDiscussionHandler)
+		// Moderators delete other people's discussions through this route,
+		// so it needs the same second factor as the admin API.
+		user.DELETE("/discussions/:id", middleware.RequireMFA(), middleware.RequirePermission(infoDB.PermDiscussionsWrite), handler.DeleteDiscussionHandler)
+		user.POST("/discussions/:id/react", reactionLimit, middleware.RequirePermission(infoDB.PermReactionsWrite), handler.ToggleDiscussionReactionHandler)
+	}
 
-		user.POST("/discussions", handler.CreateDiscussionHandler)
-		user.PUT("/discussions/:id", handler.UpdateDiscussionHandler)
-		user.DELETE("/discussions/:id", handler.DeleteDiscussionHandler)
-		user.POST("/discussions/:id/react", handler.ToggleDiscussionReactionHandler)
+	// Multipart uploads live outside the JSON-only groups, with room for a
+	// 2 MB file plus form overhead.
+	uploads := r.Group("/api")
+	uploads.Use(middleware.AuthMiddleware(), middleware.MaxBodySize(3<<20))
+	{
+		uploads.POST("/auth/me/avatar", middleware.RequirePermission(infoDB.PermProfileWrite), handler.UploadAvatarHandler)
+	}
+
+	// Breed images may be up to 10 MB.
+	adminUploads := r.Group("/api/admin")
+	adminUploads.Use(middleware.AuthMiddleware(), middleware.RequireMFA(), middleware.MaxBodySize(11<<20))
+	{
+		adminUploads.POST("/cats/:id/images", middleware.RequirePermission(infoDB.PermBreedsWrite), handler.UploadCatImageHandler)
 	}
 
 	admin := r.Group("/api/admin")
-	admin.Use(middleware.AuthMiddleware(), middleware.RequireRole("admin"))
+	admin.Use(middleware.AuthMiddleware(), middleware.RequireMFA(), jsonBody, middleware.RequireJSON())
 	{
-		admin.POST("/cats", handler.CreateCatHandler)
-		admin.PUT("/cats/:id", handler.UpdateCatHandler)
-		admin.DELETE("/cats/:id", handler.DeleteCatHandler)
+		breeds := middleware.RequirePermission(infoDB.PermBreedsWrite)
+		admin.POST("/cats", breeds, handler.CreateCatHandler)
+		admin.PUT("/cats/:id", breeds, handler.UpdateCatHandler)
+		admin.DELETE("/cats/:id", breeds, handler.DeleteCatHandler)
+		admin.PUT("/cats/:id/images/order", breeds, handler.ReorderCatImagesHandler)
+		admin.PATCH("/cats/:id/images/:imageId", breeds, handler.UpdateCatImageHandler)
+		admin.DELETE("/cats/:id/images/:imageId", breeds, handler.DeleteCatImageHandler)
+		admin.POST("/cats/:id/images/:imageId/primary", breeds, handler.SetPrimaryCatImageHandler)
+
+		users := middleware.RequirePermission(infoDB.PermUsersManage)
+		admin.GET("/users", users, handler.AdminListUsersHandler)
+		admin.GET("/users/:id", users, handler.AdminGetUserHandler)
+		admin.POST("/users/:id/activate", users, handler.ActivateUserHandler)
+		admin.POST("/users/:id/deactivate", users, handler.DeactivateUserHandler)
+		admin.POST("/users/:id/password-reset", users, handler.ForcePasswordResetHandler)
+		admin.POST("/users/:id/unlock", users, handler.UnlockUserHandler)
+		admin.POST("/users/:id/logout", users, handler.ForceLogoutUserHandler)
+		admin.GET("/api-keys", users, handler.AdminGetAPIKeysHandler)
+		admin.DELETE("/api-keys/:id", users, handler.AdminRevokeAPIKeyHandler)
+
+		sanctions := middleware.RequirePermission(infoDB.PermUsersSanction)
+		admin.GET("/users/:id/sanctions", sanctions, handler.GetUserSanctionsHandler)
+		admin.POST("/users/:id/sanctions", sanctions, handler.CreateSanctionHandler)
+		admin.DELETE("/sanctions/:id", sanctions, handler.LiftSanctionHandler)
+
+		moderate := middleware.RequirePermission(infoDB.PermDiscussionsModerate)
+		admin.GET("/moderation/words", moderate, handler.ListModerationWordsHandler)
+		admin.POST("/moderation/words", moderate, handler.AddModerationWordHandler)
+		admin.DELETE("/moderation/words/:id", moderate, handler.DeleteModerationWordHandler)
+		admin.GET("/moderation/queue", moderate, handler.GetModerationQueueHandler)
+		admin.GET("/moderation/discussions/:id", moderate, handler.GetModeratedDiscussionHandler)
+		admin.POST("/moderation/discussions/:id/approve", moderate, handler.ApproveDiscussionHandler)
+		admin.POST("/moderation/discussions/:id/reject", moderate, handler.RejectDiscussionHandler)
+
+		roles := middleware.RequirePermission(infoDB.PermRolesManage)
+		admin.GET("/roles", roles, handler.ListRolesHandler)
+		admin.POST("/roles", roles, handler.CreateRoleHandler)
+		admin.POST("/roles/:id/permissions", roles, handler.GrantRolePermissionsHandler)
+		admin.DELETE("/roles/:id/permissions/:permission", roles, handler.RevokeRolePermissionHandler)
+		admin.GET("/permissions", roles, handler.ListPermissionsHandler)
+		admin.POST("/users/:id/roles", roles, handler.AssignUserRoleHandler)
+		admin.PUT("/users/:id/roles", roles, handler.SetUserRolesHandler)
+		admin.DELETE("/users/:id/roles/:role", roles, handler.RemoveUserRoleHandler)
+
+		admin.GET("/audit", middleware.RequirePermission(infoDB.PermAuditRead), handler.GetAuditLogsHandler)
 	}
 
 	r.Run(":8080")
@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/mailer"
+	"backgo/internal/passwordpolicy"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	passwordResetTTL     = 1 * time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+var (
+	mail                     mailer.Mailer
+	appBaseURL               = "http://localhost:3000"
+	requireEmailVerification = true
+)
+
+func SetMailer(m mailer.Mailer) {
+	mail = m
+}
+
+// SetAppBaseURL sets the frontend URL used to build links in outgoing mail.
+func SetAppBaseURL(baseURL string) {
+	appBaseURL = strings.TrimRight(baseURL, "/")
+}
+
+func SetRequireEmailVerification(required bool) {
+	requireEmailVerification = required
+}
+
+func sendMail(msg mailer.Message) {
+	if mail == nil {
+		log.Printf("No mailer configured, dropping mail to %s: %s", msg.To, msg.Subject)
+		return
+	}
+	if err := mail.Send(msg); err != nil {
+		log.Printf("Failed to send mail to %s: %v", msg.To, err)
+	}
+}
+
+func sendVerificationEmail(userID int, username, email string) error {
+	token, err := infoDB.CreateUserToken(userID, infoDB.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", appBaseURL, url.QueryEscape(token))
+	sendMail(mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in 48 hours.\n", username, link),
+	})
+	return nil
+}
+
+func sendPasswordResetEmail(userID int, username, email string) error {
+	token, err := infoDB.CreateUserToken(userID, infoDB.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", appBaseURL, url.QueryEscape(token))
+	sendMail(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"If it was you, open the link below to choose a new password:\n\n%s\n\n"+
+			"The link expires in 1 hour and can only be used once. "+
+			"If you did not ask for this, you can ignore this email.\n", username, link),
+	})
+	return nil
+}
+
+// respondPasswordPolicyError writes the per-rule feedback when err is a policy
+// violation and reports whether it did.
+func respondPasswordPolicyError(c *gin.Context, err error) bool {
+	var policyErr *passwordpolicy.Error
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "password does not meet the password policy",
+		"strength":   policyErr.Score,
+		"violations": policyErr.Violations,
+	})
+	return true
+}
+
+// checkCurrentPassword confirms a signed-in user's password before a
+// credential change and responds when it does not match. Wrong guesses count
+// against the login guard like failed logins, so a stolen session cannot be
+// used to brute-force the password.
+func checkCurrentPassword(c *gin.Context, userID int, password string) bool {
+	user, err := infoDB.GetUserBaseInfoByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return false
+	}
+
+	block, err := infoDB.CheckLoginBlocked(user.Username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return false
+	}
+	if block != nil {
+		respondLoginBlocked(c, block)
+		return false
+	}
+
+	hash, err := infoDB.GetPasswordHash(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return false
+	}
+	if err := infoDB.VerifyPassword(hash, password); err != nil {
+		recordLoginFailure(c, userID, user.Username, "wrong_current_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return false
+	}
+
+	if err := infoDB.ResetLoginFailures(user.Username); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", user.Username, err)
+	}
+	return true
+}
+
+// ChangePasswordHandler handles POST /api/auth/password
+
+// ChangePasswordHandler godoc
+// @Summary      Change password
+// @Description  Change the password of the logged-in user and log out all sessions
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.ChangePasswordRequest  true  "Current and new password"
+// @Success      200   {object}  map[string]interface{}  "Password changed"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body or password policy violations"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized or wrong current password"
+// @Failure      429   {object}  map[string]interface{}  "Too many wrong passwords or account locked"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/password [post]
+func ChangePasswordHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	if !checkCurrentPassword(c, userID, req.CurrentPassword) {
+		return
+	}
+
+	if err := infoDB.UpdatePassword(userID, req.NewPassword); err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	_ = infoDB.RevokeAllRefreshTokens(userID)
+
+	infoDB.LogAudit(userID, "password_change", "auth", nil, nil, c)
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, please log in again"})
+}
+
+// ForgotPasswordHandler handles POST /api/auth/password/forgot
+
+// ForgotPasswordHandler godoc
+// @Summary      Request password reset
+// @Description  Email a single-use password reset link. Always succeeds so that accounts cannot be enumerated.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      infoDB.ForgotPasswordRequest  true  "Account email"
+// @Success      200   {object}  map[string]interface{}  "Reset link sent if the account exists"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body"
+// @Router       /auth/password/forgot [post]
+func ForgotPasswordHandler(c *gin.Context) {
+	var req infoDB.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	user, err := infoDB.GetUserByEmail(req.Email)
+	if err == nil && user.IsActive {
+		if err := sendPasswordResetEmail(user.ID, user.Username, user.Email); err != nil {
+			log.Printf("Failed to issue password reset token for user %d: %v", user.ID, err)
+		} else {
+			infoDB.LogAudit(user.ID, "password_reset_request", "auth", nil, nil, c)
+		}
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to look up user for password reset: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the account exists, a reset link has been sent"})
+}
+
+// ResetPasswordHandler handles POST /api/auth/password/reset
+
+// ResetPasswordHandler godoc
+// @Summary      Reset password
+// @Description  Set a new password with a reset token and log out all sessions
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      infoDB.ResetPasswordRequest  true  "Reset token and new password"
+// @Success      200   {object}  map[string]interface{}  "Password reset"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body, token or password policy violations"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/password/reset [post]
+func ResetPasswordHandler(c *gin.Context) {
+	var req infoDB.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	userID, err := infoDB.LookupUserToken(req.Token, infoDB.TokenPurposePasswordReset)
+	if err == infoDB.ErrInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// Check the policy first so a rejected password does not burn the token.
+	info, err := infoDB.GetUserBaseInfoByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if err := infoDB.ValidatePassword(req.NewPassword, info.Username, info.Email); err != nil {
+		respondPasswordPolicyError(c, err)
+		return
+	}
+
+	if _, err := infoDB.ConsumeUserToken(req.Token, infoDB.TokenPurposePasswordReset); err == infoDB.ErrInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if err := infoDB.UpdatePassword(userID, req.NewPassword); err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	_ = infoDB.RevokeAllRefreshTokens(userID)
+
+	// The reset link proves control of the mailbox.
+	_ = infoDB.MarkEmailVerified(userID)
+
+	infoDB.LogAudit(userID, "password_reset", "auth", nil, nil, c)
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "password has been reset, please log in"})
+}
+
+// VerifyEmailHandler handles POST /api/auth/verify-email
+
+// VerifyEmailHandler godoc
+// @Summary      Verify email
+// @Description  Confirm the account email address with a verification token
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      infoDB.VerifyEmailRequest  true  "Verification token"
+// @Success      200   {object}  map[string]interface{}  "Email verified"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body or token"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/verify-email [post]
+func VerifyEmailHandler(c *gin.Context) {
+	var req infoDB.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	userID, err := infoDB.ConsumeUserToken(req.Token, infoDB.TokenPurposeEmailVerification)
+	if err == infoDB.ErrInvalidUserToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if err := infoDB.MarkEmailVerified(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	infoDB.LogAudit(userID, "email_verify", "auth", nil, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified successfully"})
+}
+
+// ResendVerificationHandler handles POST /api/auth/verify-email/resend
+
+// ResendVerificationHandler godoc
+// @Summary      Resend verification email
+// @Description  Send a new verification link. Always succeeds so that accounts cannot be enumerated.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      infoDB.ResendVerificationRequest  true  "Account email"
+// @Success      200   {object}  map[string]interface{}  "Verification link sent if needed"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body"
+// @Router       /auth/verify-email/resend [post]
+func ResendVerificationHandler(c *gin.Context) {
+	var req infoDB.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	user, err := infoDB.GetUserByEmail(req.Email)
+	if err == nil && user.IsActive && !user.EmailVerified {
+		if err := sendVerificationEmail(user.ID, user.Username, user.Email); err != nil {
+			log.Printf("Failed to issue verification token for user %d: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "if the account needs verification, a new link has been sent"})
+}
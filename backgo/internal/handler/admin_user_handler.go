@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UnlockUserHandler handles POST /api/admin/users/:id/unlock (Admin only)
+
+// UnlockUserHandler godoc
+// @Summary      Unlock account (admin)
+// @Description  Clear the failed login counter and lockout of a user (admin only)
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "Account unlocked"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      404  {object}  map[string]interface{}  "User not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/unlock [post]
+func UnlockUserHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	username, err := infoDB.UnlockAccount(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "account_unlock", "user", userID, gin.H{"username": username}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
+
+// ForceLogoutUserHandler handles POST /api/admin/users/:id/logout (Admin only)
+
+// ForceLogoutUserHandler godoc
+// @Summary      Force logout (admin)
+// @Description  Revoke every session of a user (admin only)
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "Sessions revoked"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      404  {object}  map[string]interface{}  "User not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/logout [post]
+func ForceLogoutUserHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if _, err := infoDB.GetUserBaseInfoByID(userID); err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := infoDB.RevokeAllRefreshTokens(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := infoDB.BumpTokenVersion(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "force_logout", "user", userID, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "all sessions of the user have been revoked"})
+}
+
+// AdminListUsersHandler handles GET /api/admin/users (Admin only)
+
+// AdminListUsersHandler godoc
+// @Summary      List users (admin)
+// @Description  Search users by username, email or display name, filter by role and status, and sort by created_at, last_login or username
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        q       query     string  false  "Search text"
+// @Param        role    query     string  false  "Role name"
+// @Param        status  query     string  false  "active or inactive"
+// @Param        sort    query     string  false  "created_at, last_login or username"  default(created_at)
+// @Param        order   query     string  false  "asc or desc"                         default(desc)
+// @Param        limit   query     int     false  "Limit (max 100)"                     default(20)
+// @Param        offset  query     int     false  "Offset"                              default(0)
+// @Success      200     {object}  map[string]interface{}  "data: []infoDB.AdminUserSummary, count: int, total: int"
+// @Failure      400     {object}  map[string]interface{}  "Invalid filter"
+// @Failure      401     {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403     {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500     {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users [get]
+func AdminListUsersHandler(c *gin.Context) {
+	query := infoDB.AdminUserQuery{
+		Query: c.Query("q"),
+		Role:  c.Query("role"),
+		Sort:  c.DefaultQuery("sort", "created_at"),
+	}
+
+	if !infoDB.IsValidAdminUserSort(query.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be created_at, last_login or username"})
+		return
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+		query.Desc = true
+	case "asc":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	switch c.Query("status") {
+	case "":
+	case "active", "inactive":
+		active := c.Query("status") == "active"
+		query.Active = &active
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or inactive"})
+		return
+	}
+
+	query.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if query.Limit <= 0 || query.Limit > 100 {
+		query.Limit = 20
+	}
+	query.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	users, total, err := infoDB.ListUsers(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  users,
+		"count": len(users),
+		"total": total,
+	})
+}
+
+// AdminGetUserHandler handles GET /api/admin/users/:id (Admin only)
+
+// AdminGetUserHandler godoc
+// @Summary      Get user (admin)
+// @Description  Account details with roles, review, reaction, session and API key counts
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  infoDB.AdminUserDetail
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "User not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id} [get]
+func AdminGetUserHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	user, err := infoDB.GetAdminUserDetail(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ActivateUserHandler handles POST /api/admin/users/:id/activate (Admin only)
+
+// ActivateUserHandler godoc
+// @Summary      Activate account (admin)
+// @Description  Allow a deactivated user to log in again
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "Account activated"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "User not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/activate [post]
+func ActivateUserHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	err = infoDB.SetUserActive(userID, true)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "account_activate", "user", userID, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account activated"})
+}
+
+// DeactivateUserHandler handles POST /api/admin/users/:id/deactivate (Admin only)
+
+// DeactivateUserHandler godoc
+// @Summary      Deactivate account (admin)
+// @Description  Block logins and revoke every session of a user. The last active admin cannot be deactivated.
+// @Tags         admin, users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                           true   "User ID"
+// @Param        body  body      infoDB.DeactivateUserRequest  false  "Reason, kept in the audit log"
+// @Success      200   {object}  map[string]interface{}  "Account deactivated"
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID or request body, or own account"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "User not found"
+// @Failure      409   {object}  map[string]interface{}  "Last active admin"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/deactivate [post]
+func DeactivateUserHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if userID == adminID.(int) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot deactivate your own account"})
+		return
+	}
+
+	var req infoDB.DeactivateUserRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+			return
+		}
+	}
+
+	err = infoDB.SetUserActive(userID, false)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	case err == infoDB.ErrLastAdmin:
+		c.JSON(http.StatusConflict, gin.H{"error": "cannot deactivate the last active admin"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "account_deactivate", "user", userID, gin.H{"reason": req.Reason}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deactivated and all sessions revoked"})
+}
+
+// ForcePasswordResetHandler handles POST /api/admin/users/:id/password-reset (Admin only)
+
+// ForcePasswordResetHandler godoc
+// @Summary      Force password reset (admin)
+// @Description  Revoke every session and refuse password logins until the user sets a new password through the reset link emailed to them
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "Reset required"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "User not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/password-reset [post]
+func ForcePasswordResetHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	info, err := infoDB.GetUserBaseInfoByID(userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := infoDB.RequirePasswordReset(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	emailSent := true
+	if err := sendPasswordResetEmail(info.ID, info.Username, info.Email); err != nil {
+		log.Printf("Failed to issue password reset token for user %d: %v", info.ID, err)
+		emailSent = false
+	}
+
+	infoDB.LogAudit(adminID.(int), "password_reset_force", "user", userID, gin.H{"email_sent": emailSent}, c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "password reset required and all sessions revoked",
+		"email_sent": emailSent,
+	})
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAPIKeyHandler handles POST /api/auth/api-keys
+
+// CreateAPIKeyHandler godoc
+// @Summary      Create API key
+// @Description  Issue a long-lived key for scripts and integrations. Send it in the X-API-Key header. The key is only shown once.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.CreateAPIKeyRequest  true  "Key name, scopes and expiry"
+// @Success      201   {object}  map[string]interface{}  "key: string, api_key: infoDB.APIKey"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request or scope not permitted"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Two-factor authentication required"
+// @Failure      409   {object}  map[string]interface{}  "Too many keys"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/api-keys [post]
+func CreateAPIKeyHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	// Keys carry the MFA status of the session that creates them, so an
+	// account that needs MFA must have passed it to mint one.
+	roles, _ := c.Get("roles")
+	userRoles, _ := roles.([]string)
+	if infoDB.MFARequiredForRoles(userRoles) && !c.GetBool("mfa") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is required for this account", "mfa_required": true})
+		return
+	}
+
+	key, secret, err := infoDB.CreateAPIKey(userID, req, c.GetBool("mfa"))
+	switch {
+	case err == infoDB.ErrScopeNotPermitted:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrTooManyAPIKeys:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID, "api_key_create", "api_key", key.ID, gin.H{"name": key.Name, "prefix": key.Prefix, "scopes": key.Scopes}, c)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     secret,
+		"api_key": key,
+	})
+}
+
+// GetAPIKeysHandler handles GET /api/auth/api-keys
+
+// GetAPIKeysHandler godoc
+// @Summary      List API keys
+// @Description  Keys of the logged-in user, including revoked and expired ones
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "data: []infoDB.APIKey"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/api-keys [get]
+func GetAPIKeysHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	keys, err := infoDB.GetAPIKeys(&userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RevokeAPIKeyHandler handles DELETE /api/auth/api-keys/:id
+
+// RevokeAPIKeyHandler godoc
+// @Summary      Revoke API key
+// @Description  Revoke one of the logged-in user's keys
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "API key ID"
+// @Success      200  {object}  map[string]interface{}  "Key revoked"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      404  {object}  map[string]interface{}  "Key not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/api-keys/{id} [delete]
+func RevokeAPIKeyHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	_, err = infoDB.RevokeAPIKey(keyID, &userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID, "api_key_revoke", "api_key", keyID, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// AdminGetAPIKeysHandler handles GET /api/admin/api-keys (Admin only)
+
+// AdminGetAPIKeysHandler godoc
+// @Summary      List all API keys (admin)
+// @Description  Keys of every user, optionally filtered by owner
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id  query     int  false  "Owner user ID"
+// @Success      200      {object}  map[string]interface{}  "data: []infoDB.APIKey"
+// @Failure      400      {object}  map[string]interface{}  "Invalid user_id"
+// @Failure      401      {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403      {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500      {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/api-keys [get]
+func AdminGetAPIKeysHandler(c *gin.Context) {
+	var ownerID *int
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		ownerID = &id
+	}
+
+	keys, err := infoDB.GetAPIKeys(ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// AdminRevokeAPIKeyHandler handles DELETE /api/admin/api-keys/:id (Admin only)
+
+// AdminRevokeAPIKeyHandler godoc
+// @Summary      Revoke API key (admin)
+// @Description  Revoke any user's key, e.g. after it leaked
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "API key ID"
+// @Success      200  {object}  map[string]interface{}  "Key revoked"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Key not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/api-keys/{id} [delete]
+func AdminRevokeAPIKeyHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	ownerID, err := infoDB.RevokeAPIKey(keyID, nil)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "api_key_revoke", "api_key", keyID, gin.H{"owner_id": ownerID}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A key only reaches RequireMFA routes when the session that created it had
+// passed MFA, so keys minted before the owner needed MFA stay out.
+func TestAPIKeyCarriesMFA(t *testing.T) {
+	d := useTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	userID, _ := createTestUser(t, d)
+	if err := infoDB.AssignRole(userID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	infoDB.SetMFARequiredRoles([]string{"admin"})
+	t.Cleanup(func() { infoDB.SetMFARequiredRoles(nil) })
+
+	r := gin.New()
+	r.GET("/api/admin/ping", middleware.AuthMiddleware(), middleware.RequireMFA(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		mfaVerified bool
+		wantStatus  int
+	}{
+		{"key from a session without MFA", false, http.StatusForbidden},
+		{"key from an MFA-verified session", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := infoDB.CreateAPIKeyRequest{Name: tt.name, Scopes: []string{infoDB.PermProfileWrite}}
+			_, secret, err := infoDB.CreateAPIKey(userID, req, tt.mfaVerified)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			httpReq := httptest.NewRequest(http.MethodGet, "/api/admin/ping", nil)
+			httpReq.Header.Set("X-API-Key", secret)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httpReq)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
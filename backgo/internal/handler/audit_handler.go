@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+	maxAuditExportRows   = 100000
+)
+
+var auditCSVHeader = []string{"id", "created_at", "user_id", "username", "action", "resource", "resource_id", "ip_address", "user_agent", "details"}
+
+// parseAuditFilter reads the filter query parameters shared by the JSON and
+// export forms of the audit endpoint.
+func parseAuditFilter(c *gin.Context) (infoDB.AuditFilter, error) {
+	filter := infoDB.AuditFilter{
+		Action:     c.Query("action"),
+		Resource:   c.Query("resource"),
+		ResourceID: c.Query("resource_id"),
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid user_id")
+		}
+		filter.UserID = &id
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := c.Query(bound.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s, expected RFC 3339 time", bound.name)
+		}
+		*bound.dst = &t
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		before, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || before <= 0 {
+			return filter, fmt.Errorf("invalid cursor")
+		}
+		filter.Before = before
+	}
+	return filter, nil
+}
+
+// csvSafe stops spreadsheet applications from treating a value as a
+// formula when an export is opened.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func auditCSVRecord(entry infoDB.AuditLog) []string {
+	userID := ""
+	if entry.UserID != nil {
+		userID = strconv.Itoa(*entry.UserID)
+	}
+	return []string{
+		strconv.FormatInt(entry.ID, 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		userID,
+		csvSafe(entry.Username),
+		csvSafe(entry.Action),
+		csvSafe(entry.Resource),
+		csvSafe(entry.ResourceID),
+		csvSafe(entry.IPAddress),
+		csvSafe(entry.UserAgent),
+		csvSafe(string(entry.Details)),
+	}
+}
+
+// GetAuditLogsHandler handles GET /api/admin/audit
+
+// GetAuditLogsHandler godoc
+// @Summary      Search audit log (admin)
+// @Description  Audit entries newest first. Pass next_cursor back as cursor for the next page. With format=csv or format=ndjson every matching entry is streamed as a download instead.
+// @Tags         admin
+// @Produce      json
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Security     BearerAuth
+// @Param        user_id      query     int     false  "Acting user ID"
+// @Param        action       query     string  false  "Action, e.g. login_failed"
+// @Param        resource     query     string  false  "Resource, e.g. cat"
+// @Param        resource_id  query     string  false  "Resource ID"
+// @Param        from         query     string  false  "Start time (RFC 3339, inclusive)"
+// @Param        to           query     string  false  "End time (RFC 3339, exclusive)"
+// @Param        cursor       query     int     false  "Cursor from a previous page"
+// @Param        limit        query     int     false  "Page size (max 200)"  default(50)
+// @Param        format       query     string  false  "json, csv or ndjson"  default(json)
+// @Success      200          {object}  map[string]interface{}  "data: []infoDB.AuditLog, next_cursor: int"
+// @Failure      400          {object}  map[string]interface{}  "Invalid filter"
+// @Failure      401          {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403          {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500          {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/audit [get]
+func GetAuditLogsHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch format := c.DefaultQuery("format", "json"); format {
+	case "json":
+	case "csv", "ndjson":
+		filter.Limit = maxAuditExportRows
+		infoDB.LogAudit(adminID.(int), "audit_export", "audit", nil, gin.H{"format": format, "query": c.Request.URL.RawQuery}, c)
+		exportAuditLogs(c, filter, format)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv or ndjson"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditPageSize)))
+	if limit <= 0 || limit > maxAuditPageSize {
+		limit = defaultAuditPageSize
+	}
+	filter.Limit = limit
+
+	logs, next, err := infoDB.QueryAuditLogs(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"data": logs, "count": len(logs)}
+	if next > 0 {
+		response["next_cursor"] = next
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// exportAuditLogs streams entries as they are read. Once the first row is
+// written the status is committed, so a later failure can only be logged.
+func exportAuditLogs(c *gin.Context, filter infoDB.AuditFilter, format string) {
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+
+	var write func(infoDB.AuditLog) error
+	var flush func() error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(auditCSVHeader); err != nil {
+			return
+		}
+		write = func(entry infoDB.AuditLog) error { return w.Write(auditCSVRecord(entry)) }
+		flush = func() error { w.Flush(); return w.Error() }
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(entry infoDB.AuditLog) error { return enc.Encode(entry) }
+		flush = func() error { return nil }
+	}
+
+	c.Status(http.StatusOK)
+	err := infoDB.EachAuditLog(filter, write)
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		log.Printf("Audit export failed: %v", err)
+	}
+}
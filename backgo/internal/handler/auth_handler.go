@@ -2,8 +2,11 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"time"
+	"strconv"
 	"backgo/internal/infoDB"
 
 	"github.com/gin-gonic/gin"
@@ -20,7 +23,7 @@ import (
 // @Produce      json
 // @Param        body  body      infoDB.RegisterRequest  true  "Register info"
 // @Success      201   {object}  map[string]interface{}  "User created successfully"
-// @Failure      400   {object}  map[string]interface{}  "Invalid request body"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body or password policy violations"
 // @Failure      409   {object}  map[string]interface{}  "Username or Email already exists"
 // @Failure      500   {object}  map[string]interface{}  "Internal server error"
 // @Router       /register [post]
@@ -34,6 +37,9 @@ func RegisterHandler(c *gin.Context) {
 	user, err := infoDB.CreateUser(req)
 
 	if err != nil {
+		if respondPasswordPolicyError(c, err) {
+			return
+		}
 		if err.Error() == "username or email already exists" {
 			c.JSON(http.StatusConflict, gin.H{"error": "Username or Email is already taken"})
 			return
@@ -44,8 +50,12 @@ func RegisterHandler(c *gin.Context) {
 
 	infoDB.LogAudit(user.ID, "register", "auth", nil, gin.H{"username": user.Username}, c)
 
+	if err := sendVerificationEmail(user.ID, user.Username, user.Email); err != nil {
+		log.Printf("Failed to issue verification token for user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User created successfully",
+		"message": "User created successfully, please check your email to verify your account",
 		"user_id": user.ID,
 	})
 }
@@ -59,9 +69,11 @@ func RegisterHandler(c *gin.Context) {
 // @Accept       json
 // @Produce      json
 // @Param        body  body      infoDB.LoginRequest  true  "Login credentials"
-// @Success      200   {object}  map[string]interface{}  "User info with roles"
+// @Success      200   {object}  map[string]interface{}  "User info with roles, or mfa_required with an mfa_token"
 // @Failure      400   {object}  map[string]interface{}  "Invalid request"
 // @Failure      401   {object}  map[string]interface{}  "Invalid credentials or account disabled"
+// @Failure      403   {object}  map[string]interface{}  "Email address not verified"
+// @Failure      429   {object}  map[string]interface{}  "Too many failed attempts or account locked"
 // @Failure      500   {object}  map[string]interface{}  "Internal server error"
 // @Router       /auth/login [post]
 func LoginHandler(c *gin.Context) {
@@ -71,52 +83,169 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	ip := c.ClientIP()
+	block, err := infoDB.CheckLoginBlocked(req.Username, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
 	user, err := infoDB.GetUserByUsername(req.Username)
 	if err == sql.ErrNoRows {
+		recordLoginFailure(c, 0, req.Username, "unknown_user")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
-	if !user.IsActive {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "account is disabled"})
+	// A disabled account is only reported once the password is right, by
+	// checkLoginAllowed, so that guesses against it are counted like any
+	// other and do not reveal that the username exists.
+	if err := infoDB.VerifyPassword(user.PasswordHash, req.Password); err != nil {
+		recordLoginFailure(c, user.ID, req.Username, "wrong_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
-	if err := infoDB.VerifyPassword(user.PasswordHash, req.Password); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	if err := infoDB.ResetLoginFailures(req.Username); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", req.Username, err)
+	}
+
+	if refusal := checkLoginAllowed(user); refusal != nil {
+		c.JSON(refusal.status, refusal.body)
 		return
 	}
 
+	challenge, err := mfaChallenge(user, "password")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if challenge != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(infoDB.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	userInfo, csrfToken, err := startSession(c, user, "password", false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	response := gin.H{"user": userInfo, "csrf_token": csrfToken}
+	if infoDB.MFARequiredForRoles(userInfo.Roles) {
+		// Admin routes stay closed until the user enrolls.
+		response["mfa_enrollment_required"] = true
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// loginRefusal is why a user who proved who they are may still not log in.
+// code is used where the refusal is reported by redirect, as with OIDC.
+type loginRefusal struct {
+	status int
+	code   string
+	body   gin.H
+}
+
+// checkLoginAllowed applies the checks every login method makes once the
+// user is authenticated and before any MFA challenge or session, so no
+// method can skip a forced password reset or email verification.
+func checkLoginAllowed(user infoDB.User) *loginRefusal {
+	switch {
+	case !user.IsActive:
+		return &loginRefusal{http.StatusUnauthorized, "account_disabled", gin.H{"error": "account is disabled"}}
+	case user.PasswordResetRequired:
+		return &loginRefusal{http.StatusForbidden, "password_reset_required", gin.H{
+			"error":                   "a password reset is required, check your email for the reset link",
+			"password_reset_required": true,
+		}}
+	case requireEmailVerification && !user.EmailVerified:
+		return &loginRefusal{http.StatusForbidden, "email_not_verified", gin.H{"error": "email address is not verified"}}
+	}
+	return nil
+}
+
+// startSession issues access and refresh tokens for an authenticated user,
+// sets them as cookies along with a CSRF token and records the login. method names how the user
+// proved their identity, e.g. "password" or "oidc:google"; mfa tells whether
+// a second factor was checked as well.
+func startSession(c *gin.Context, user infoDB.User, method string, mfa bool) (infoDB.UserInfo, string, error) {
 	roles, _ := infoDB.GetUserRoles(user.ID)
 
-	accessToken, _ := infoDB.GenerateAccessToken(user.ID, user.Username, roles)
-	refreshToken, _ := infoDB.GenerateRefreshToken(user.ID, user.Username)
+	authState, err := infoDB.GetAuthState(user.ID)
+	if err != nil {
+		return infoDB.UserInfo{}, "", err
+	}
 
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	_ = infoDB.StoreRefreshToken(user.ID, refreshToken, expiresAt)
+	accessToken, err := infoDB.GenerateAccessToken(user.ID, user.Username, roles, authState.TokenVersion, mfa)
+	if err != nil {
+		return infoDB.UserInfo{}, "", err
+	}
+	refreshToken, err := infoDB.IssueRefreshToken(user.ID, user.Username, deviceInfo(c), mfa)
+	if err != nil {
+		return infoDB.UserInfo{}, "", err
+	}
 
 	_ = infoDB.UpdateLastLogin(user.ID)
 
-	infoDB.LogAudit(user.ID, "login", "auth", nil, gin.H{"username": user.Username}, c)
+	infoDB.LogAudit(user.ID, "login", "auth", nil, gin.H{"username": user.Username, "method": method, "mfa": mfa}, c)
 
 
-	c.SetCookie("access_token", accessToken, 900, "/", "", false, true)
-	c.SetCookie("refresh_token", refreshToken, 604800, "/", "", false, true)
+	csrfToken, err := setAuthCookies(c, accessToken, refreshToken)
+	if err != nil {
+		return infoDB.UserInfo{}, "", err
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"user": infoDB.UserInfo{
-			ID:       user.ID,
-			Username: user.Username,
-			Email:    user.Email,
-			Roles:    roles,
-		},
+	return infoDB.UserInfo{
+		ID:       user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Roles:    roles,
+	}, csrfToken, nil
+}
+
+func respondLoginBlocked(c *gin.Context, block *infoDB.LoginBlock) {
+	retryAfter := int(math.Ceil(block.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+	message := "too many failed login attempts, please wait before retrying"
+	if block.Locked && block.Scope == infoDB.LoginScopeUsername {
+		message = "account is temporarily locked after too many failed login attempts"
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": retryAfter,
 	})
 }
 
+func recordLoginFailure(c *gin.Context, userID int, username, reason string) {
+	infoDB.LogAudit(userID, "login_failed", "auth", nil, gin.H{"username": username, "reason": reason}, c)
+
+	locked, err := infoDB.RecordLoginFailure(username, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", username, err)
+	}
+	for _, scope := range locked {
+		infoDB.LogAudit(userID, "login_lockout", "auth", nil, gin.H{"username": username, "scope": scope}, c)
+	}
+}
+
 func RefreshTokenHandler(c *gin.Context) {
 
+	fromBody := false
 	refreshToken, err := c.Cookie("refresh_token")
 	if err != nil {
 
@@ -126,12 +255,24 @@ func RefreshTokenHandler(c *gin.Context) {
 			return
 		}
 		refreshToken = req.RefreshToken
+		fromBody = true
 	}
 
 
-	userID, valid := infoDB.IsRefreshTokenValid(refreshToken)
-	if !valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+	newRefreshToken, userID, mfaVerified, err := infoDB.RotateRefreshToken(refreshToken, deviceInfo(c))
+	if err != nil {
+		var reuse *infoDB.RefreshTokenReuse
+		if errors.As(err, &reuse) {
+			infoDB.LogAudit(reuse.UserID, "refresh_token_reuse", "auth", nil, gin.H{"family_id": reuse.FamilyID}, c)
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used, please log in again"})
+			return
+		}
+		if err == infoDB.ErrInvalidRefreshToken {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 		return
 	}
 
@@ -141,17 +282,36 @@ func RefreshTokenHandler(c *gin.Context) {
 		return
 	}
 
+	authState, err := infoDB.GetAuthState(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user details"})
+		return
+	}
+	if !authState.IsActive {
+		_, _ = infoDB.RevokeRefreshToken(newRefreshToken)
+		clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "account is disabled"})
+		return
+	}
+
 	roles, _ := infoDB.GetUserRoles(userID)
 
 
-	accessToken, _ := infoDB.GenerateAccessToken(userID, userBaseInfo.Username, roles)
+	accessToken, _ := infoDB.GenerateAccessToken(userID, userBaseInfo.Username, roles, authState.TokenVersion, mfaVerified)
 
 
-	c.SetCookie("access_token", accessToken, 900, "/", "", false, true)
+	csrfToken, err := setAuthCookies(c, accessToken, newRefreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "token refreshed successfully",
-	})
+	response := gin.H{"message": "token refreshed successfully", "csrf_token": csrfToken}
+	if fromBody {
+		// Clients that do not use cookies must store the rotated token themselves.
+		response["refresh_token"] = newRefreshToken
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // LogoutHandler handles POST /api/auth/logout
@@ -168,12 +328,13 @@ func LogoutHandler(c *gin.Context) {
 	refreshToken, err := c.Cookie("refresh_token")
 	if err == nil {
 		// Revoke refresh token if exists
-		_ = infoDB.RevokeRefreshToken(refreshToken)
+		if userID, err := infoDB.RevokeRefreshToken(refreshToken); err == nil && userID > 0 {
+			infoDB.LogAudit(userID, "logout", "auth", nil, nil, c)
+		}
 	}
 
 
-	c.SetCookie("access_token", "", -1, "/", "", false, true)
-	c.SetCookie("refresh_token", "", -1, "/", "", false, true)
+	clearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "logged out successfully",
@@ -202,12 +363,33 @@ func GetMeHandler(c *gin.Context) {
 		roles = []string{}
 	}
 
+	profile, err := infoDB.GetProfile(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user profile"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"user": infoDB.UserInfo{
 			ID:       userInfo.ID,
 			Username: userInfo.Username,
 			Email:    userInfo.Email,
 			Roles:    roles,
+			Profile:  &profile,
 		},
 	})
-}
\ No newline at end of file
+}
+
+// JWKSHandler handles GET /.well-known/jwks.json
+
+// JWKSHandler godoc
+// @Summary      JSON Web Key Set
+// @Description  Public keys that verify access tokens issued by this API
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}  "keys: []JWK"
+// @Router       /.well-known/jwks.json [get]
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, infoDB.GetJWKS())
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 
 	"backgo/internal/infoDB"
+	"backgo/internal/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -135,6 +136,8 @@ func CreateCatHandler(c *gin.Context) {
 		return
 	}
 
+	infoDB.LogAudit(userID, "cat_create", "cat", cat.ID, gin.H{"name": cat.Name}, c)
+
 	c.JSON(http.StatusCreated, cat)
 }
 
@@ -156,7 +159,7 @@ func CreateCatHandler(c *gin.Context) {
 // @Failure      500   {object}  map[string]interface{}  "Internal server error"
 // @Router       /admin/cats/{id} [put]
 func UpdateCatHandler(c *gin.Context) {
-	_, exists := c.Get("user_id")
+	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
@@ -183,6 +186,8 @@ func UpdateCatHandler(c *gin.Context) {
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "cat_update", "cat", catID, gin.H{"name": cat.Name}, c)
+
 	c.JSON(http.StatusOK, cat)
 }
 
@@ -202,7 +207,7 @@ func UpdateCatHandler(c *gin.Context) {
 // @Failure      500  {object}  map[string]interface{}  "Internal server error"
 // @Router       /admin/cats/{id} [delete]
 func DeleteCatHandler(c *gin.Context) {
-	_, exists := c.Get("user_id")
+	userID, exists := c.Get("user_id")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
@@ -214,7 +219,7 @@ func DeleteCatHandler(c *gin.Context) {
 		return
 	}
 
-	err = infoDB.DeleteCat(catID)
+	imageKeys, err := infoDB.DeleteCat(catID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "cat not found"})
 		return
@@ -222,6 +227,9 @@ func DeleteCatHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	deleteBlobs(imageKeys)
+
+	infoDB.LogAudit(userID.(int), "cat_delete", "cat", catID, nil, c)
 
 	c.JSON(http.StatusOK, gin.H{"message": "cat deleted successfully"})
 }
@@ -248,11 +256,15 @@ func ToggleCatReactionHandler(c *gin.Context) {
 	}
 
 	response, err := infoDB.ToggleCatReaction(catID, userID.(int), req.ReactionType)
-	if err != nil {
+	if respondSanctioned(c, err) {
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "reaction_toggle", "cat", catID, gin.H{"reaction_type": req.ReactionType, "result": response.UserReaction}, c)
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -348,11 +360,15 @@ func CreateDiscussionHandler(c *gin.Context) {
 	}
 
 	discussion, err := infoDB.CreateDiscussion(userID.(int), req)
-	if err != nil {
+	if respondSanctioned(c, err) || respondModerationError(c, err) {
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "discussion_create", "discussion", discussion.ID, gin.H{"breed_id": discussion.BreedID, "parent_id": discussion.ParentID, "moderation_status": discussion.ModerationStatus}, c)
+
 	c.JSON(http.StatusCreated, discussion)
 }
 
@@ -376,7 +392,9 @@ func UpdateDiscussionHandler(c *gin.Context) {
 	}
 
 	discussion, err := infoDB.UpdateDiscussion(discussionID, userID.(int), req)
-	if err == sql.ErrNoRows {
+	if respondSanctioned(c, err) || respondModerationError(c, err) {
+		return
+	} else if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "discussion not found or you don't have permission"})
 		return
 	} else if err != nil {
@@ -384,6 +402,8 @@ func UpdateDiscussionHandler(c *gin.Context) {
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "discussion_update", "discussion", discussionID, gin.H{"moderation_status": discussion.ModerationStatus}, c)
+
 	c.JSON(http.StatusOK, discussion)
 }
 
@@ -400,18 +420,9 @@ func DeleteDiscussionHandler(c *gin.Context) {
 		return
 	}
 
-	roles, _ := c.Get("roles")
-	isAdmin := false
-	if roles != nil {
-		for _, role := range roles.([]string) {
-			if role == "admin" || role == "moderator" {
-				isAdmin = true
-				break
-			}
-		}
-	}
+	isModerator := middleware.HasPermission(c, infoDB.PermDiscussionsModerate)
 
-	err = infoDB.DeleteDiscussion(discussionID, userID.(int), isAdmin)
+	err = infoDB.DeleteDiscussion(discussionID, userID.(int), isModerator)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "discussion not found or you don't have permission"})
 		return
@@ -420,6 +431,8 @@ func DeleteDiscussionHandler(c *gin.Context) {
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "discussion_delete", "discussion", discussionID, gin.H{"moderator": isModerator}, c)
+
 	c.JSON(http.StatusOK, gin.H{"message": "discussion deleted successfully"})
 }
 
@@ -444,11 +457,15 @@ func ToggleDiscussionReactionHandler(c *gin.Context) {
 	}
 
 	response, err := infoDB.ToggleDiscussionReaction(discussionID, userID.(int), req.ReactionType)
-	if err != nil {
+	if respondSanctioned(c, err) {
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	infoDB.LogAudit(userID.(int), "reaction_toggle", "discussion", discussionID, gin.H{"reaction_type": req.ReactionType, "result": response.UserReaction}, c)
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -459,15 +476,27 @@ func GetMyDiscussionsHandler(c *gin.Context) {
 		return
 	}
 
-	discussions, err := infoDB.GetDiscussionsByUserID(userID.(int))
+	limit, offset, ok := discussionPage(c)
+	if !ok {
+		return
+	}
+
+	uid := userID.(int)
+	discussions, err := infoDB.GetDiscussionsByUserID(uid, &uid, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total, err := infoDB.CountDiscussionsByUserID(uid, &uid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	
 	c.JSON(http.StatusOK, gin.H{
 		"data":  discussions,
 		"count": len(discussions),
+		"total": total,
 	})
 }
\ No newline at end of file
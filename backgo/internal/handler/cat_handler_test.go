@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A shadow-banned author keeps seeing their own discussions on the breed
+// page, so the ban is not obvious to them; nobody else sees them.
+func TestGetCatDiscussionsShadowBan(t *testing.T) {
+	d := useTestDB(t)
+	gin.SetMode(gin.TestMode)
+
+	authorID, author := createTestUser(t, d)
+	otherID, other := createTestUser(t, d)
+
+	var breedID int
+	if err := d.QueryRow(`INSERT INTO cat_breeds (name) VALUES ('Shadow test') RETURNING id`).Scan(&breedID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Exec(`DELETE FROM cat_breeds WHERE id = $1`, breedID) })
+
+	if _, err := d.Exec(`INSERT INTO discussions (breed_id, user_id, message) VALUES ($1, $2, 'hello')`, breedID, authorID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec(`
+		INSERT INTO user_sanctions (user_id, type, reason) VALUES ($1, $2, 'test')
+	`, authorID, infoDB.SanctionShadowBan); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/api/cats/:id/discussions", middleware.OptionalAuth(), GetCatDiscussionsHandler)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCount     int
+	}{
+		{"author", bearer(t, authorID, author), 1},
+		{"another user", bearer(t, otherID, other), 0},
+		{"anonymous", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/cats/%d/discussions", breedID), nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+			}
+			var body struct {
+				Count int `json:"count"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Count != tt.wantCount {
+				t.Fatalf("count = %d, want %d", body.Count, tt.wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/imaging"
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBreedImageSize = 10 << 20
+
+var breedThumbnailWidths = []int{320, 800}
+
+// deleteBlobs removes keys from the blob store, logging failures: the
+// database no longer refers to them either way.
+func deleteBlobs(keys []string) {
+	if blobStore == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := blobStore.Delete(key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// UploadCatImageHandler handles POST /api/admin/cats/:id/images (Admin only)
+
+// UploadCatImageHandler godoc
+// @Summary      Upload breed image (admin)
+// @Description  Add a JPEG, PNG, GIF or WebP image (max 10 MB) to the end of the breed's gallery. The file type is checked from its contents, EXIF and other metadata are removed, and 320 and 800 pixel wide thumbnails are made. Files are named by content hash and served from /media with long-lived cache headers. The breed's first image, or one uploaded with primary=true, becomes its primary image.
+// @Tags         admin, cats
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id        path      int     true   "Cat ID"
+// @Param        image     formData  file    true   "Breed image"
+// @Param        caption   formData  string  false  "Caption"
+// @Param        alt_text  formData  string  false  "Text alternative for screen readers"
+// @Param        credit    formData  string  false  "Photographer or source"
+// @Param        license   formData  string  false  "Licence, e.g. CC BY-SA 4.0"
+// @Param        primary   formData  bool    false  "Make this the primary image"
+// @Success      201       {object}  infoDB.BreedImage
+// @Failure      400       {object}  map[string]interface{}  "Missing, too large, unsupported or corrupt file, or invalid fields"
+// @Failure      401       {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403       {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404       {object}  map[string]interface{}  "Cat not found"
+// @Failure      409       {object}  map[string]interface{}  "Image already in the gallery"
+// @Failure      500       {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/cats/{id}/images [post]
+func UploadCatImageHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	catID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if blobStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "image storage is not configured"})
+		return
+	}
+
+	found, err := infoDB.CatExists(catID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "cat not found"})
+		return
+	}
+
+	var details infoDB.BreedImageDetails
+	if err := c.ShouldBind(&details); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing image file"})
+		return
+	}
+	if fileHeader.Size > maxBreedImageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image must be 10 MB or smaller"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read image file"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBreedImageSize+1))
+	if err != nil || len(data) > maxBreedImageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image must be 10 MB or smaller"})
+		return
+	}
+
+	processed, err := imaging.Process(data, breedThumbnailWidths)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrInvalidImage) || errors.Is(err, imaging.ErrTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process image"})
+		return
+	}
+
+	// Thumbnails are named after the original, so one hash covers them all
+	// and an identical upload lands on the same keys.
+	sum := sha256.Sum256(processed.Original.Data)
+	base := fmt.Sprintf("breeds/%d/%s", catID, hex.EncodeToString(sum[:]))
+
+	keys := []string{base + processed.Original.Format.Ext()}
+	blobs := [][]byte{processed.Original.Data}
+	thumbnails := make(map[string]string, len(processed.Thumbnails))
+	for _, thumb := range processed.Thumbnails {
+		key := fmt.Sprintf("%s-%d%s", base, thumb.Width, thumb.Format.Ext())
+		keys = append(keys, key)
+		blobs = append(blobs, thumb.Data)
+		thumbnails[strconv.Itoa(thumb.Width)] = blobStore.URL(key)
+	}
+
+	for i, key := range keys {
+		if err := blobStore.Put(key, bytes.NewReader(blobs[i])); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			return
+		}
+	}
+
+	// On failure the blobs are left in place: the same keys belong to the
+	// gallery's copy if this image was uploaded before.
+	image, err := infoDB.AddBreedImage(catID, userID.(int), infoDB.NewBreedImage{
+		BreedImageDetails: details,
+		URL:               blobStore.URL(keys[0]),
+		Keys:              keys,
+		Thumbnails:        thumbnails,
+		Width:             processed.Original.Width,
+		Height:            processed.Original.Height,
+	})
+	switch {
+	case err == sql.ErrNoRows:
+		deleteBlobs(keys)
+		c.JSON(http.StatusNotFound, gin.H{"error": "cat not found"})
+		return
+	case err == infoDB.ErrBreedImageExists:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add cat image"})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "cat_image_upload", "cat", catID, gin.H{"image_id": image.ID, "key": keys[0], "bytes": len(processed.Original.Data)}, c)
+
+	c.JSON(http.StatusCreated, image)
+}
+
+// catImageIDs parses the :id and :imageId path parameters.
+func catImageIDs(c *gin.Context) (int, int, bool) {
+	catID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, 0, false
+	}
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id"})
+		return 0, 0, false
+	}
+	return catID, imageID, true
+}
+
+// UpdateCatImageHandler handles PATCH /api/admin/cats/:id/images/:imageId (Admin only)
+
+// UpdateCatImageHandler godoc
+// @Summary      Update breed image details (admin)
+// @Description  Change the caption, alt text, credit or licence of a gallery image. Omitted fields are left as they are.
+// @Tags         admin, cats
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int                             true  "Cat ID"
+// @Param        imageId  path      int                             true  "Image ID"
+// @Param        body     body      infoDB.UpdateBreedImageRequest  true  "Fields to change"
+// @Success      200      {object}  infoDB.BreedImage
+// @Failure      400      {object}  map[string]interface{}  "Invalid ID or request body"
+// @Failure      401      {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403      {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404      {object}  map[string]interface{}  "Image not found"
+// @Failure      500      {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/cats/{id}/images/{imageId} [patch]
+func UpdateCatImageHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	catID, imageID, ok := catImageIDs(c)
+	if !ok {
+		return
+	}
+
+	var req infoDB.UpdateBreedImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	image, err := infoDB.UpdateBreedImage(catID, imageID, req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "cat_image_update", "cat", catID, gin.H{"image_id": imageID}, c)
+
+	c.JSON(http.StatusOK, image)
+}
+
+// DeleteCatImageHandler handles DELETE /api/admin/cats/:id/images/:imageId (Admin only)
+
+// DeleteCatImageHandler godoc
+// @Summary      Delete breed image (admin)
+// @Description  Remove an image from the gallery, and its files if it was uploaded. If it was the primary image, the first remaining image becomes primary.
+// @Tags         admin, cats
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int  true  "Cat ID"
+// @Param        imageId  path      int  true  "Image ID"
+// @Success      200      {object}  map[string]interface{}  "Image deleted"
+// @Failure      400      {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401      {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403      {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404      {object}  map[string]interface{}  "Image not found"
+// @Failure      500      {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/cats/{id}/images/{imageId} [delete]
+func DeleteCatImageHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	catID, imageID, ok := catImageIDs(c)
+	if !ok {
+		return
+	}
+
+	keys, err := infoDB.DeleteBreedImage(catID, imageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	deleteBlobs(keys)
+
+	infoDB.LogAudit(userID.(int), "cat_image_delete", "cat", catID, gin.H{"image_id": imageID}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "image deleted successfully"})
+}
+
+// SetPrimaryCatImageHandler handles POST /api/admin/cats/:id/images/:imageId/primary (Admin only)
+
+// SetPrimaryCatImageHandler godoc
+// @Summary      Set primary breed image (admin)
+// @Description  Make a gallery image the breed's primary image, which is also its image_url
+// @Tags         admin, cats
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      int  true  "Cat ID"
+// @Param        imageId  path      int  true  "Image ID"
+// @Success      200      {object}  map[string]interface{}  "data: []infoDB.BreedImage"
+// @Failure      400      {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401      {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403      {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404      {object}  map[string]interface{}  "Cat or image not found"
+// @Failure      500      {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/cats/{id}/images/{imageId}/primary [post]
+func SetPrimaryCatImageHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	catID, imageID, ok := catImageIDs(c)
+	if !ok {
+		return
+	}
+
+	images, err := infoDB.SetPrimaryBreedImage(catID, imageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "cat_image_primary", "cat", catID, gin.H{"image_id": imageID}, c)
+
+	c.JSON(http.StatusOK, gin.H{"data": images, "count": len(images)})
+}
+
+// ReorderCatImagesHandler handles PUT /api/admin/cats/:id/images/order (Admin only)
+
+// ReorderCatImagesHandler godoc
+// @Summary      Reorder breed images (admin)
+// @Description  Set the gallery order. image_ids must list every image of the breed exactly once.
+// @Tags         admin, cats
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                               true  "Cat ID"
+// @Param        body  body      infoDB.ReorderBreedImagesRequest  true  "Image IDs in display order"
+// @Success      200   {object}  map[string]interface{}  "data: []infoDB.BreedImage"
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID, request body or image list"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "Cat not found"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/cats/{id}/images/order [put]
+func ReorderCatImagesHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	catID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req infoDB.ReorderBreedImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	images, err := infoDB.ReorderBreedImages(catID, req.ImageIDs)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "cat not found"})
+		return
+	case err == infoDB.ErrImageOrderMismatch:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "cat_image_reorder", "cat", catID, gin.H{"image_ids": req.ImageIDs}, c)
+
+	c.JSON(http.StatusOK, gin.H{"data": images, "count": len(images)})
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+
+	"backgo/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenMaxAge  = 900
+	refreshTokenMaxAge = 604800
+)
+
+// Attributes of every cookie the API sets. Production deployments behind
+// HTTPS should enable Secure; SameSite=None additionally requires it.
+var (
+	cookieSecure   = false
+	cookieSameSite = http.SameSiteLaxMode
+	cookieDomain   = ""
+)
+
+func SetCookieOptions(secure bool, sameSite http.SameSite, domain string) {
+	cookieSecure = secure
+	cookieSameSite = sameSite
+	cookieDomain = domain
+}
+
+func setCookie(c *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	c.SetSameSite(cookieSameSite)
+	c.SetCookie(name, value, maxAge, "/", cookieDomain, cookieSecure, httpOnly)
+}
+
+// setAuthCookies stores a fresh token pair and a new CSRF token, which is
+// returned so it can be included in the response body as well.
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string) (string, error) {
+	csrfToken, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	setCookie(c, "access_token", accessToken, accessTokenMaxAge, true)
+	setCookie(c, "refresh_token", refreshToken, refreshTokenMaxAge, true)
+	// Not HttpOnly: the frontend reads it and echoes it in the CSRF header.
+	setCookie(c, middleware.CSRFCookieName, csrfToken, refreshTokenMaxAge, false)
+	return csrfToken, nil
+}
+
+func clearAuthCookies(c *gin.Context) {
+	setCookie(c, "access_token", "", -1, true)
+	setCookie(c, "refresh_token", "", -1, true)
+	setCookie(c, middleware.CSRFCookieName, "", -1, false)
+}
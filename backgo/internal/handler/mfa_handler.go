@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/totp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mfaIssuer is the account label shown in authenticator apps.
+var mfaIssuer = "Cat Breeds"
+
+func SetMFAIssuer(issuer string) {
+	mfaIssuer = issuer
+}
+
+// mfaChallenge returns a challenge token when the user has MFA enabled, or
+// an empty string when the login can go ahead without a second factor.
+func mfaChallenge(user infoDB.User, method string) (string, error) {
+	enabled, err := infoDB.IsMFAEnabled(user.ID)
+	if err != nil || !enabled {
+		return "", err
+	}
+
+	state, err := infoDB.GetAuthState(user.ID)
+	if err != nil {
+		return "", err
+	}
+	return infoDB.GenerateMFAChallengeToken(user.ID, state.TokenVersion, method)
+}
+
+// GetMFAStatusHandler handles GET /api/auth/mfa
+
+// GetMFAStatusHandler godoc
+// @Summary      Two-factor status
+// @Description  Whether TOTP is enabled for the logged-in user and how many recovery codes are left
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  infoDB.MFAStatus
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa [get]
+func GetMFAStatusHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	status, err := infoDB.GetMFAStatus(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	roles, _ := c.Get("roles")
+	userRoles, _ := roles.([]string)
+	status.Required = infoDB.MFARequiredForRoles(userRoles)
+
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollMFAHandler handles POST /api/auth/mfa/enroll
+
+// EnrollMFAHandler godoc
+// @Summary      Start TOTP enrollment
+// @Description  Generate a TOTP secret and otpauth URI. MFA is enabled once a code is confirmed.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "secret, otpauth_uri"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      409  {object}  map[string]interface{}  "MFA already enabled"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa/enroll [post]
+func EnrollMFAHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	secret, err := infoDB.BeginMFAEnrollment(userID.(int))
+	if err == infoDB.ErrMFAAlreadyEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "mfa_enroll_start", "auth", nil, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.KeyURI(mfaIssuer, c.GetString("username"), secret),
+	})
+}
+
+// ConfirmMFAHandler handles POST /api/auth/mfa/confirm
+
+// ConfirmMFAHandler godoc
+// @Summary      Confirm TOTP enrollment
+// @Description  Enable MFA with a code from the authenticator app and return recovery codes. The current session is upgraded to MFA.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.MFACodeRequest   true  "TOTP code"
+// @Success      200   {object}  map[string]interface{}  "recovery_codes: []string"
+// @Failure      400   {object}  map[string]interface{}  "Invalid code or enrollment not started"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      409   {object}  map[string]interface{}  "MFA already enabled"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa/confirm [post]
+func ConfirmMFAHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	codes, err := infoDB.ConfirmMFAEnrollment(userID, req.Code)
+	switch {
+	case err == infoDB.ErrMFAAlreadyEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrMFANotEnrolled || err == infoDB.ErrInvalidMFACode:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	infoDB.LogAudit(userID, "mfa_enable", "auth", nil, nil, c)
+
+	// The code just proved possession of the second factor, so the current
+	// session does not need to log in again to reach MFA-only routes.
+	if refreshToken, err := c.Cookie("refresh_token"); err == nil {
+		if err := infoDB.MarkSessionMFAVerified(refreshToken); err != nil {
+			log.Printf("Failed to upgrade session of user %d to MFA: %v", userID, err)
+		}
+	}
+	state, err := infoDB.GetAuthState(userID)
+	if err == nil {
+		roles, _ := c.Get("roles")
+		userRoles, _ := roles.([]string)
+		accessToken, err := infoDB.GenerateAccessToken(userID, c.GetString("username"), userRoles, state.TokenVersion, true)
+		if err == nil {
+			setCookie(c, "access_token", accessToken, accessTokenMaxAge, true)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled, store the recovery codes somewhere safe",
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodesHandler handles POST /api/auth/mfa/recovery-codes
+
+// RegenerateRecoveryCodesHandler godoc
+// @Summary      Regenerate recovery codes
+// @Description  Replace all recovery codes. Requires a current TOTP code.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.MFACodeRequest   true  "TOTP code"
+// @Success      200   {object}  map[string]interface{}  "recovery_codes: []string"
+// @Failure      400   {object}  map[string]interface{}  "Invalid code"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa/recovery-codes [post]
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	if err := infoDB.VerifyMFACode(userID, req.Code); err != nil {
+		if err == infoDB.ErrInvalidMFACode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	codes, err := infoDB.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	infoDB.LogAudit(userID, "mfa_recovery_codes_regenerate", "auth", nil, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableMFAHandler handles DELETE /api/auth/mfa
+
+// DisableMFAHandler godoc
+// @Summary      Disable two-factor authentication
+// @Description  Turn off TOTP. Not allowed for roles that require MFA.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.DisableMFARequest  true  "Password and TOTP code"
+// @Success      200   {object}  map[string]interface{}  "MFA disabled"
+// @Failure      400   {object}  map[string]interface{}  "Invalid code"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized or wrong password"
+// @Failure      403   {object}  map[string]interface{}  "MFA is mandatory for this account"
+// @Failure      429   {object}  map[string]interface{}  "Too many wrong passwords or account locked"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa [delete]
+func DisableMFAHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	roles, _ := c.Get("roles")
+	userRoles, _ := roles.([]string)
+	if infoDB.MFARequiredForRoles(userRoles) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is mandatory for this account"})
+		return
+	}
+
+	if !checkCurrentPassword(c, userID, req.Password) {
+		return
+	}
+
+	if err := infoDB.VerifyMFACode(userID, req.Code); err != nil {
+		if err == infoDB.ErrInvalidMFACode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if err := infoDB.DisableMFA(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	infoDB.LogAudit(userID, "mfa_disable", "auth", nil, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// VerifyMFALoginHandler handles POST /api/auth/mfa/verify
+
+// VerifyMFALoginHandler godoc
+// @Summary      Complete login with a second factor
+// @Description  Exchange the mfa_token from the login response and a TOTP or recovery code for session cookies
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      infoDB.MFAVerifyRequest  true  "Challenge token and code"
+// @Success      200   {object}  map[string]interface{}  "User info with roles"
+// @Failure      400   {object}  map[string]interface{}  "Invalid request"
+// @Failure      401   {object}  map[string]interface{}  "Invalid challenge or code"
+// @Failure      429   {object}  map[string]interface{}  "Too many failed attempts"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/mfa/verify [post]
+func VerifyMFALoginHandler(c *gin.Context) {
+	var req infoDB.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	challenge, err := infoDB.VerifyMFAChallengeToken(req.MFAToken)
+	if errors.Is(err, infoDB.ErrInvalidMFAChallenge) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	user, err := infoDB.GetUserByID(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	// Guessing codes counts against the same throttle as guessing passwords.
+	ip := c.ClientIP()
+	block, err := infoDB.CheckLoginBlocked(user.Username, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if block != nil {
+		respondLoginBlocked(c, block)
+		return
+	}
+
+	factor := "totp"
+	if req.Code != "" {
+		err = infoDB.VerifyMFACode(user.ID, req.Code)
+	} else {
+		factor = "recovery_code"
+		err = infoDB.UseRecoveryCode(user.ID, req.RecoveryCode)
+	}
+	if err == infoDB.ErrInvalidMFACode {
+		recordLoginFailure(c, user.ID, user.Username, "wrong_mfa_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if err := infoDB.ResetLoginFailures(user.Username); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", user.Username, err)
+	}
+	if factor == "recovery_code" {
+		infoDB.LogAudit(user.ID, "mfa_recovery_code_used", "auth", nil, nil, c)
+	}
+
+	if refusal := checkLoginAllowed(user); refusal != nil {
+		c.JSON(refusal.status, refusal.body)
+		return
+	}
+
+	userInfo, csrfToken, err := startSession(c, user, challenge.Method+"+"+factor, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":       userInfo,
+		"csrf_token": csrfToken,
+	})
+}
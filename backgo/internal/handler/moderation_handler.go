@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/moderation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondModerationError writes the filter's findings when err is a
+// rejection by the content filter and reports whether it did.
+func respondModerationError(c *gin.Context, err error) bool {
+	var modErr *moderation.Error
+	if !errors.As(err, &modErr) {
+		return false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":    "message was rejected by the content filter",
+		"findings": modErr.Findings,
+	})
+	return true
+}
+
+// ListModerationWordsHandler handles GET /api/admin/moderation/words (Moderator only)
+
+// ListModerationWordsHandler godoc
+// @Summary      List filtered words (moderator)
+// @Description  Words the content filter looks for in discussions. Thai words match anywhere, English words only as whole words.
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        language  query     string  false  "en or th"
+// @Success      200       {object}  map[string]interface{}  "data: []infoDB.ModerationWord"
+// @Failure      400       {object}  map[string]interface{}  "Invalid language"
+// @Failure      401       {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403       {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500       {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/words [get]
+func ListModerationWordsHandler(c *gin.Context) {
+	language := c.Query("language")
+	if language != "" && language != "en" && language != "th" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "language must be en or th"})
+		return
+	}
+
+	words, err := infoDB.ListModerationWords(language)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": words, "count": len(words)})
+}
+
+// AddModerationWordHandler handles POST /api/admin/moderation/words (Moderator only)
+
+// AddModerationWordHandler godoc
+// @Summary      Add filtered word (moderator)
+// @Description  Add a word to the content filter. mask hides the word, flag publishes the discussion but queues it for a moderator, hold sends the discussion to the moderation queue and reject refuses it. Spacing, repeated letters, look-alike characters and Thai tone marks are ignored when matching.
+// @Tags         admin, moderation
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.CreateModerationWordRequest  true  "Word"
+// @Success      201   {object}  infoDB.ModerationWord
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body or word"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      409   {object}  map[string]interface{}  "Word already listed"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/words [post]
+func AddModerationWordHandler(c *gin.Context) {
+	moderatorID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req infoDB.CreateModerationWordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	word, err := infoDB.AddModerationWord(moderatorID.(int), req)
+	switch {
+	case err == infoDB.ErrInvalidModerationWord:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrModerationWordExists:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(moderatorID.(int), "moderation_word_add", "moderation_word", word.ID, gin.H{"word": word.Word, "language": word.Language, "action": word.Action}, c)
+
+	c.JSON(http.StatusCreated, word)
+}
+
+// DeleteModerationWordHandler handles DELETE /api/admin/moderation/words/:id (Moderator only)
+
+// DeleteModerationWordHandler godoc
+// @Summary      Remove filtered word (moderator)
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "Word ID"
+// @Success      200  {object}  map[string]interface{}  "Word removed"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Word not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/words/{id} [delete]
+func DeleteModerationWordHandler(c *gin.Context) {
+	moderatorID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	wordID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	word, err := infoDB.DeleteModerationWord(wordID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "word not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(moderatorID.(int), "moderation_word_delete", "moderation_word", word.ID, gin.H{"word": word.Word, "language": word.Language}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "word removed"})
+}
+
+// GetModerationQueueHandler handles GET /api/admin/moderation/queue (Moderator only)
+
+// GetModerationQueueHandler godoc
+// @Summary      Moderation queue (moderator)
+// @Description  Discussions held or flagged by the content filter, oldest first, with what the filter found and links to the discussions its findings refer to, such as the reviews a near-duplicate matches
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        status  query     string  false  "held or flagged; both when empty"
+// @Param        limit   query     int     false  "Limit number of results"  default(20)
+// @Param        offset  query     int     false  "Offset for pagination"    default(0)
+// @Success      200     {object}  map[string]interface{}  "data: []infoDB.ModeratedDiscussion, count, total"
+// @Failure      400     {object}  map[string]interface{}  "Invalid status"
+// @Failure      401     {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403     {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500     {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/queue [get]
+func GetModerationQueueHandler(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != infoDB.ModerationHeld && status != infoDB.ModerationFlagged {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be held or flagged"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	queue, total, err := infoDB.GetModerationQueue(status, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": queue, "count": len(queue), "total": total})
+}
+
+// GetModeratedDiscussionHandler handles GET /api/admin/moderation/discussions/:id (Moderator only)
+
+// GetModeratedDiscussionHandler godoc
+// @Summary      Get discussion for moderation (moderator)
+// @Description  Any discussion whatever its status, with the content filter's findings and the discussions they refer to. Queue entries link here.
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "Discussion ID"
+// @Success      200  {object}  infoDB.ModeratedDiscussion
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Discussion not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/discussions/{id} [get]
+func GetModeratedDiscussionHandler(c *gin.Context) {
+	discussionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discussion id"})
+		return
+	}
+
+	discussion, err := infoDB.GetModeratedDiscussion(discussionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "discussion not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, discussion)
+}
+
+// ApproveDiscussionHandler handles POST /api/admin/moderation/discussions/:id/approve (Moderator only)
+
+// ApproveDiscussionHandler godoc
+// @Summary      Approve queued discussion (moderator)
+// @Description  Publish a held discussion, or clear the flag on a flagged one
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "Discussion ID"
+// @Success      200  {object}  map[string]interface{}  "Discussion published"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Discussion not found"
+// @Failure      409  {object}  map[string]interface{}  "Discussion is not queued"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/discussions/{id}/approve [post]
+func ApproveDiscussionHandler(c *gin.Context) {
+	reviewQueuedDiscussion(c, true)
+}
+
+// RejectDiscussionHandler handles POST /api/admin/moderation/discussions/:id/reject (Moderator only)
+
+// RejectDiscussionHandler godoc
+// @Summary      Reject queued discussion (moderator)
+// @Description  Hide a held or flagged discussion from everyone but its author, who sees it marked as rejected
+// @Tags         admin, moderation
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "Discussion ID"
+// @Success      200  {object}  map[string]interface{}  "Discussion rejected"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Discussion not found"
+// @Failure      409  {object}  map[string]interface{}  "Discussion is not queued"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/moderation/discussions/{id}/reject [post]
+func RejectDiscussionHandler(c *gin.Context) {
+	reviewQueuedDiscussion(c, false)
+}
+
+func reviewQueuedDiscussion(c *gin.Context, approve bool) {
+	moderatorID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	discussionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discussion id"})
+		return
+	}
+
+	err = infoDB.ReviewQueuedDiscussion(discussionID, approve)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "discussion not found"})
+		return
+	case err == infoDB.ErrDiscussionNotQueued:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action, message := "discussion_reject", "discussion rejected"
+	if approve {
+		action, message = "discussion_approve", "discussion published"
+	}
+	infoDB.LogAudit(moderatorID.(int), action, "discussion", discussionID, nil, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
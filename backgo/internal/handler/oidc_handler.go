@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+
+	// oidcStateCookie ties a login flow to the browser that started it, so a
+	// callback URL carrying someone else's code and state is refused.
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/api/auth/oidc/"
+)
+
+var oidcProviders = map[string]*oidc.Provider{}
+
+func SetOIDCProviders(providers map[string]*oidc.Provider) {
+	oidcProviders = providers
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setOIDCStateCookie stores the flow's state and nonce in the browser. It is
+// always SameSite=Lax: the provider sends the browser back with a cross-site
+// top-level GET, which Strict would strip the cookie from.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcCookiePath, cookieDomain, cookieSecure, true)
+}
+
+// oidcLoginRedirect sends the browser back to the frontend, with an error code
+// when the login failed.
+func oidcLoginRedirect(c *gin.Context, errCode string) {
+	target := appBaseURL + "/login"
+	if errCode == "" {
+		target = appBaseURL + "/"
+	} else {
+		target += "?error=" + url.QueryEscape(errCode)
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// GetOIDCProvidersHandler handles GET /api/auth/oidc/providers
+
+// GetOIDCProvidersHandler godoc
+// @Summary      List social login providers
+// @Description  Names of the configured OpenID Connect providers
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}  "data: []string"
+// @Router       /auth/oidc/providers [get]
+func GetOIDCProvidersHandler(c *gin.Context) {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.JSON(http.StatusOK, gin.H{"data": names})
+}
+
+// OIDCLoginHandler handles GET /api/auth/oidc/:provider/login
+
+// OIDCLoginHandler godoc
+// @Summary      Start social login
+// @Description  Redirect to the identity provider using the authorization code flow with PKCE
+// @Tags         auth
+// @Param        provider  path  string  true  "Provider name"
+// @Success      302
+// @Failure      404  {object}  map[string]interface{}  "Unknown provider"
+// @Failure      502  {object}  map[string]interface{}  "Provider unavailable"
+// @Router       /auth/oidc/{provider}/login [get]
+func OIDCLoginHandler(c *gin.Context) {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
+		return
+	}
+
+	state, err1 := randomString(32)
+	nonce, err2 := randomString(32)
+	verifier, err3 := randomString(48)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	if err := infoDB.CreateOIDCState(provider.Name, state, nonce, verifier, oidcStateTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "login provider is unavailable"})
+		return
+	}
+
+	setOIDCStateCookie(c, state+"."+nonce, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallbackHandler handles GET /api/auth/oidc/:provider/callback
+
+// OIDCCallbackHandler godoc
+// @Summary      Finish social login
+// @Description  Exchange the authorization code, link or create the account and set session cookies
+// @Tags         auth
+// @Param        provider  path   string  true  "Provider name"
+// @Param        code      query  string  true  "Authorization code"
+// @Param        state     query  string  true  "Login state"
+// @Success      302
+// @Router       /auth/oidc/{provider}/callback [get]
+func OIDCCallbackHandler(c *gin.Context) {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown login provider"})
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		oidcLoginRedirect(c, "provider_denied")
+		return
+	}
+
+	cookie, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	cookieState, cookieNonce, _ := strings.Cut(cookie, ".")
+	state := c.Query("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		oidcLoginRedirect(c, "invalid_state")
+		return
+	}
+
+	nonce, verifier, err := infoDB.ConsumeOIDCState(provider.Name, state)
+	if err != nil || subtle.ConstantTimeCompare([]byte(nonce), []byte(cookieNonce)) != 1 {
+		oidcLoginRedirect(c, "invalid_state")
+		return
+	}
+
+	claims, err := provider.Exchange(c.Query("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("OIDC login with %s failed: %v", provider.Name, err)
+		oidcLoginRedirect(c, "provider_error")
+		return
+	}
+
+	user, created, err := infoDB.ResolveOIDCUser(infoDB.OIDCIdentity{
+		Provider:          provider.Name,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	})
+	switch {
+	case errors.Is(err, infoDB.ErrOIDCEmailConflict):
+		oidcLoginRedirect(c, "email_in_use")
+		return
+	case errors.Is(err, infoDB.ErrOIDCNoEmail):
+		oidcLoginRedirect(c, "email_required")
+		return
+	case err != nil:
+		log.Printf("Failed to resolve OIDC user for %s: %v", provider.Name, err)
+		oidcLoginRedirect(c, "internal_error")
+		return
+	}
+
+	if created {
+		infoDB.LogAudit(user.ID, "register", "auth", nil, gin.H{"username": user.Username, "provider": provider.Name}, c)
+	}
+	if refusal := checkLoginAllowed(user); refusal != nil {
+		oidcLoginRedirect(c, refusal.code)
+		return
+	}
+
+	method := "oidc:" + provider.Name
+	challenge, err := mfaChallenge(user, method)
+	if err != nil {
+		oidcLoginRedirect(c, "internal_error")
+		return
+	}
+	if challenge != "" {
+		// The fragment keeps the challenge out of server logs and referrers.
+		c.Redirect(http.StatusFound, appBaseURL+"/login/mfa#mfa_token="+url.QueryEscape(challenge))
+		return
+	}
+
+	if _, _, err := startSession(c, user, method, false); err != nil {
+		oidcLoginRedirect(c, "internal_error")
+		return
+	}
+
+	oidcLoginRedirect(c, "")
+}
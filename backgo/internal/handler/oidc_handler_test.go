@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backgo/internal/oidc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The state checks run before the login state is looked up, so a callback
+// that fails them never reaches the database or the provider.
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetOIDCProviders(map[string]*oidc.Provider{
+		"mock": oidc.NewProvider("mock", "http://127.0.0.1:0", "client", "", "http://localhost/callback", nil),
+	})
+	t.Cleanup(func() { SetOIDCProviders(map[string]*oidc.Provider{}) })
+
+	r := gin.New()
+	r.GET("/api/auth/oidc/:provider/callback", OIDCCallbackHandler)
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{name: "no cookie", query: "?code=c&state=abc"},
+		{name: "cookie from another flow", query: "?code=c&state=abc", cookie: "xyz.nonce"},
+		{name: "missing state", query: "?code=c", cookie: ".nonce"},
+		{name: "state is a prefix of the cookie", query: "?code=c&state=ab", cookie: "abc.nonce"},
+		{name: "state carries the nonce", query: "?code=c&state=abc.nonce", cookie: "abc.nonce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+			}
+			if got, want := w.Header().Get("Location"), appBaseURL+"/login?error=invalid_state"; got != want {
+				t.Fatalf("Location = %q, want %q", got, want)
+			}
+
+			cleared := false
+			for _, c := range w.Result().Cookies() {
+				if c.Name == oidcStateCookie && c.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if !cleared {
+				t.Fatal("state cookie was not cleared")
+			}
+		})
+	}
+}
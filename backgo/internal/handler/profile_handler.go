@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"backgo/internal/imaging"
+	"backgo/internal/infoDB"
+	"backgo/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAvatarSize = 2 << 20
+
+var blobStore storage.BlobStore
+
+func SetBlobStore(store storage.BlobStore) {
+	blobStore = store
+}
+
+// UpdateMeHandler handles PATCH /api/auth/me
+
+// UpdateMeHandler godoc
+// @Summary      Update own profile
+// @Description  Update display name, bio, location and owned cats of the logged-in user
+// @Tags         auth, users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.UpdateProfileRequest  true  "Profile fields to change"
+// @Success      200   {object}  infoDB.Profile
+// @Failure      400   {object}  map[string]interface{}  "Invalid request body or unknown breed"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/me [patch]
+func UpdateMeHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	var req infoDB.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	if err := infoDB.UpdateProfile(userID, req); err != nil {
+		if errors.Is(err, infoDB.ErrUnknownBreed) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile", "details": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID, "profile_update", "user", userID, nil, c)
+
+	profile, err := infoDB.GetProfile(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UploadAvatarHandler handles POST /api/auth/me/avatar
+
+// UploadAvatarHandler godoc
+// @Summary      Upload avatar
+// @Description  Upload a JPEG, PNG, GIF or WebP avatar (max 2 MB) for the logged-in user
+// @Tags         auth, users
+// @Accept       multipart/form-data
+// @Produce      json
+// @Security     BearerAuth
+// @Param        avatar  formData  file  true  "Avatar image"
+// @Success      200     {object}  map[string]interface{}  "avatar_url: string"
+// @Failure      400     {object}  map[string]interface{}  "Missing, too large or unsupported file"
+// @Failure      401     {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500     {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/me/avatar [post]
+func UploadAvatarHandler(c *gin.Context) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userID := userIDVal.(int)
+
+	if blobStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "avatar storage is not configured"})
+		return
+	}
+
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing avatar file"})
+		return
+	}
+	if fileHeader.Size > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be 2 MB or smaller"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read avatar file"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil || len(data) > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be 2 MB or smaller"})
+		return
+	}
+
+	// The type is taken from the file's content, not what the client
+	// declared, and EXIF, GPS and other metadata are stripped before the
+	// avatar is made public.
+	processed, err := imaging.Process(data, nil)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar must be a JPEG, PNG, GIF or WebP image"})
+		return
+	} else if errors.Is(err, imaging.ErrInvalidImage) || errors.Is(err, imaging.ErrTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process avatar"})
+		return
+	}
+	avatar := processed.Original
+
+	sum := sha256.Sum256(avatar.Data)
+	key := fmt.Sprintf("avatars/%d-%s%s", userID, hex.EncodeToString(sum[:8]), avatar.Format.Ext())
+	if err := blobStore.Put(key, bytes.NewReader(avatar.Data)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store avatar"})
+		return
+	}
+
+	avatarURL := blobStore.URL(key)
+	previousKey, err := infoDB.SetAvatar(userID, key, avatarURL)
+	if err != nil {
+		_ = blobStore.Delete(key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update avatar"})
+		return
+	}
+
+	if previousKey != "" && previousKey != key {
+		if err := blobStore.Delete(previousKey); err != nil {
+			log.Printf("Failed to delete old avatar %s: %v", previousKey, err)
+		}
+	}
+
+	infoDB.LogAudit(userID, "avatar_update", "user", userID, gin.H{"key": key}, c)
+
+	c.JSON(http.StatusOK, gin.H{"avatar_url": avatarURL})
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backgo/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// avatarRouter serves the avatar upload as userID, storing blobs in a
+// temporary directory.
+func avatarRouter(t *testing.T, userID int) (*gin.Engine, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	root := t.TempDir()
+	store, err := storage.NewLocalStore(root, "/media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetBlobStore(store)
+	t.Cleanup(func() { SetBlobStore(nil) })
+
+	r := gin.New()
+	r.POST("/api/auth/me/avatar", func(c *gin.Context) { c.Set("user_id", userID) }, UploadAvatarHandler)
+	return r, root
+}
+
+func postAvatar(r *gin.Engine, filename, contentType string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="avatar"; filename="` + filename + `"`},
+		"Content-Type":        {contentType},
+	})
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/me/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// jpegWithGPS is a JPEG carrying an EXIF segment with a GPS marker in it.
+func jpegWithGPS(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.Black)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00GPSLatitude 51.5N")
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	data := buf.Bytes()
+	return append(append(append([]byte(nil), data[:2]...), append(segment, payload...)...), data[2:]...)
+}
+
+func TestUploadAvatarRejectsNonImages(t *testing.T) {
+	r, root := avatarRouter(t, 1)
+
+	tests := []struct {
+		name        string
+		filename    string
+		contentType string
+		data        []byte
+	}{
+		{"HTML declared as PNG", "a.png", "image/png", []byte("<html><script>alert(1)</script></html>")},
+		{"SVG", "a.svg", "image/svg+xml", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script/></svg>`)},
+		{"truncated JPEG", "a.jpg", "image/jpeg", jpegWithGPS(t)[:300]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postAvatar(r, tt.filename, tt.contentType, tt.data)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if entries, _ := os.ReadDir(root); len(entries) != 0 {
+				t.Fatal("a rejected avatar was stored")
+			}
+		})
+	}
+}
+
+func TestUploadAvatarStripsMetadata(t *testing.T) {
+	d := useTestDB(t)
+	userID, _ := createTestUser(t, d)
+	r, root := avatarRouter(t, userID)
+
+	// Declared as a PNG to show the stored type comes from the content.
+	w := postAvatar(r, "me.png", "image/png", jpegWithGPS(t))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		AvatarURL string `json:"avatar_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if !strings.HasSuffix(body.AvatarURL, ".jpg") {
+		t.Fatalf("avatar_url = %q, want a .jpg", body.AvatarURL)
+	}
+
+	stored, err := os.ReadFile(filepath.Join(root, strings.TrimPrefix(body.AvatarURL, "/media/")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("GPSLatitude")) {
+		t.Fatal("stored avatar still carries EXIF metadata")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stored)); err != nil {
+		t.Fatalf("stored avatar does not decode: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRolesHandler handles GET /api/admin/roles
+
+// ListRolesHandler godoc
+// @Summary      List roles (admin)
+// @Description  Every role with its permissions and number of users
+// @Tags         admin, roles
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "data: []infoDB.Role"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/roles [get]
+func ListRolesHandler(c *gin.Context) {
+	roles, err := infoDB.ListRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": roles})
+}
+
+// ListPermissionsHandler handles GET /api/admin/permissions
+
+// ListPermissionsHandler godoc
+// @Summary      List permissions (admin)
+// @Description  Every permission that can be granted to a role
+// @Tags         admin, roles
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "data: []infoDB.Permission"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/permissions [get]
+func ListPermissionsHandler(c *gin.Context) {
+	permissions, err := infoDB.ListPermissions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": permissions})
+}
+
+// CreateRoleHandler handles POST /api/admin/roles
+
+// CreateRoleHandler godoc
+// @Summary      Create role (admin)
+// @Description  Create a role, optionally with an initial set of permissions
+// @Tags         admin, roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      infoDB.CreateRoleRequest  true  "Role"
+// @Success      201   {object}  infoDB.Role
+// @Failure      400   {object}  map[string]interface{}  "Invalid name or unknown permission"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      409   {object}  map[string]interface{}  "Role already exists"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/roles [post]
+func CreateRoleHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req infoDB.CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	role, err := infoDB.CreateRole(req)
+	switch {
+	case err == infoDB.ErrRoleExists:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrInvalidRoleName || err == infoDB.ErrUnknownPermission:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "role_create", "role", role.ID, gin.H{"name": role.Name, "permissions": role.Permissions}, c)
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// GrantRolePermissionsHandler handles POST /api/admin/roles/:id/permissions
+
+// GrantRolePermissionsHandler godoc
+// @Summary      Grant permissions (admin)
+// @Description  Add permissions to a role
+// @Tags         admin, roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                            true  "Role ID"
+// @Param        body  body      infoDB.RolePermissionsRequest  true  "Permissions"
+// @Success      200   {object}  infoDB.Role
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID or unknown permission"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "Role not found"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/roles/{id}/permissions [post]
+func GrantRolePermissionsHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	roleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req infoDB.RolePermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	role, err := infoDB.GrantRolePermissions(roleID, req.Permissions)
+	switch {
+	case err == infoDB.ErrUnknownRole:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrUnknownPermission:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "role_permission_grant", "role", roleID, gin.H{"role": role.Name, "permissions": req.Permissions}, c)
+
+	c.JSON(http.StatusOK, role)
+}
+
+// RevokeRolePermissionHandler handles DELETE /api/admin/roles/:id/permissions/:permission
+
+// RevokeRolePermissionHandler godoc
+// @Summary      Revoke permission (admin)
+// @Description  Remove a permission from a role
+// @Tags         admin, roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id          path      int     true  "Role ID"
+// @Param        permission  path      string  true  "Permission name"
+// @Success      200         {object}  map[string]interface{}  "Permission revoked"
+// @Failure      400         {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401         {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403         {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404         {object}  map[string]interface{}  "Role does not have the permission"
+// @Failure      409         {object}  map[string]interface{}  "Permission the admin role must keep"
+// @Failure      500         {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/roles/{id}/permissions/{permission} [delete]
+func RevokeRolePermissionHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	roleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	permission := c.Param("permission")
+
+	err = infoDB.RevokeRolePermission(roleID, permission)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "role does not have this permission"})
+		return
+	case err == infoDB.ErrAdminPermission:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "role_permission_revoke", "role", roleID, gin.H{"permission": permission}, c)
+
+	c.JSON(http.StatusOK, gin.H{"message": "permission revoked"})
+}
+
+// AssignUserRoleHandler handles POST /api/admin/users/:id/roles
+
+// AssignUserRoleHandler godoc
+// @Summary      Assign role (admin)
+// @Description  Give a user a role. The user's access tokens are revoked so the new role takes effect.
+// @Tags         admin, roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                       true  "User ID"
+// @Param        body  body      infoDB.AssignRoleRequest  true  "Role name"
+// @Success      200   {object}  map[string]interface{}  "roles: []string"
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID or unknown role"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "User not found"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/roles [post]
+func AssignUserRoleHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req infoDB.AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	if _, err := infoDB.GetUserBaseInfoByID(userID); err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = infoDB.AssignRole(userID, req.Role)
+	if err == infoDB.ErrUnknownRole {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "role_assign", "user", userID, gin.H{"role": req.Role}, c)
+
+	roles, _ := infoDB.GetUserRoles(userID)
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// RemoveUserRoleHandler handles DELETE /api/admin/users/:id/roles/:role
+
+// RemoveUserRoleHandler godoc
+// @Summary      Remove role (admin)
+// @Description  Take a role away from a user. The user's access tokens are revoked so the change takes effect.
+// @Tags         admin, roles
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int     true  "User ID"
+// @Param        role  path      string  true  "Role name"
+// @Success      200   {object}  map[string]interface{}  "roles: []string"
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "User does not have the role"
+// @Failure      409   {object}  map[string]interface{}  "Last admin"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/roles/{role} [delete]
+func RemoveUserRoleHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	role := c.Param("role")
+
+	err = infoDB.RemoveRole(userID, role)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "user does not have this role"})
+		return
+	case err == infoDB.ErrLastAdmin:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "role_remove", "user", userID, gin.H{"role": role}, c)
+
+	roles, _ := infoDB.GetUserRoles(userID)
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// SetUserRolesHandler handles PUT /api/admin/users/:id/roles
+
+// SetUserRolesHandler godoc
+// @Summary      Replace roles (admin)
+// @Description  Set the user's roles to exactly the given list. The user's access tokens are revoked so the change takes effect.
+// @Tags         admin, roles
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                         true  "User ID"
+// @Param        body  body      infoDB.SetUserRolesRequest  true  "Role names"
+// @Success      200   {object}  map[string]interface{}  "roles: []string"
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID or unknown role"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "User not found"
+// @Failure      409   {object}  map[string]interface{}  "Would remove the last admin"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/roles [put]
+func SetUserRolesHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req infoDB.SetUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	previous, _ := infoDB.GetUserRoles(userID)
+
+	err = infoDB.SetUserRoles(userID, req.Roles)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	case err == infoDB.ErrUnknownRole:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err == infoDB.ErrLastAdmin:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, _ := infoDB.GetUserRoles(userID)
+	infoDB.LogAudit(adminID.(int), "role_set", "user", userID, gin.H{"previous": previous, "roles": roles}, c)
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondSanctioned writes the response for a request refused because the
+// user is muted and reports whether it did.
+func respondSanctioned(c *gin.Context, err error) bool {
+	var muted *infoDB.MutedError
+	if !errors.As(err, &muted) {
+		return false
+	}
+	body := gin.H{"error": muted.Error()}
+	if muted.Until != nil {
+		body["muted_until"] = muted.Until.UTC().Format(time.RFC3339)
+	}
+	c.JSON(http.StatusForbidden, body)
+	return true
+}
+
+// canSanction reports whether the caller may sanction or lift a sanction on
+// target. Nobody can act on their own sanctions, and moderators cannot act
+// on each other without users:manage.
+func canSanction(c *gin.Context, callerID, target int) (bool, string) {
+	if callerID == target {
+		return false, "you cannot change your own sanctions"
+	}
+	if infoDB.CheckUserPermission(target, infoDB.PermUsersSanction) && !middleware.HasPermission(c, infoDB.PermUsersManage) {
+		return false, "only user managers can sanction other moderators"
+	}
+	return true, ""
+}
+
+// GetUserSanctionsHandler handles GET /api/admin/users/:id/sanctions (Admin only)
+
+// GetUserSanctionsHandler godoc
+// @Summary      List user sanctions (admin)
+// @Description  Every sanction the user has had, newest first, with whether it is still in force
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "User ID"
+// @Success      200  {object}  map[string]interface{}  "data: []infoDB.Sanction"
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/sanctions [get]
+func GetUserSanctionsHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	sanctions, err := infoDB.GetUserSanctions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sanctions, "count": len(sanctions)})
+}
+
+// CreateSanctionHandler handles POST /api/admin/users/:id/sanctions (Admin only)
+
+// CreateSanctionHandler godoc
+// @Summary      Sanction user (admin)
+// @Description  Mute a user (no posting or reacting), shadow-ban them (their discussions are only visible to themselves) or zero the weight of their reactions. Leave duration_minutes out for a sanction that lasts until lifted.
+// @Tags         admin, users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id    path      int                           true  "User ID"
+// @Param        body  body      infoDB.CreateSanctionRequest  true  "Sanction"
+// @Success      201   {object}  infoDB.Sanction
+// @Failure      400   {object}  map[string]interface{}  "Invalid ID or request body, or own account"
+// @Failure      401   {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403   {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404   {object}  map[string]interface{}  "User not found"
+// @Failure      500   {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/users/{id}/sanctions [post]
+func CreateSanctionHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req infoDB.CreateSanctionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
+		return
+	}
+
+	if ok, reason := canSanction(c, adminID.(int), userID); !ok {
+		status := http.StatusForbidden
+		if userID == adminID.(int) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": reason})
+		return
+	}
+
+	sanction, err := infoDB.CreateSanction(userID, adminID.(int), req)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil && sanction.ID == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "sanction_create", "user", userID, gin.H{
+		"sanction_id": sanction.ID,
+		"type":        sanction.Type,
+		"reason":      sanction.Reason,
+		"expires_at":  sanction.ExpiresAt,
+	}, c)
+
+	if err != nil {
+		// The sanction is in force; only updating counts or ratings failed.
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "sanction": sanction})
+		return
+	}
+
+	c.JSON(http.StatusCreated, sanction)
+}
+
+// LiftSanctionHandler handles DELETE /api/admin/sanctions/:id (Admin only)
+
+// LiftSanctionHandler godoc
+// @Summary      Lift sanction (admin)
+// @Description  End a sanction before it expires. It stays in the user's sanction history.
+// @Tags         admin, users
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      int  true  "Sanction ID"
+// @Success      200  {object}  infoDB.Sanction
+// @Failure      400  {object}  map[string]interface{}  "Invalid ID or own sanction"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      403  {object}  map[string]interface{}  "Insufficient permissions"
+// @Failure      404  {object}  map[string]interface{}  "Sanction not found"
+// @Failure      409  {object}  map[string]interface{}  "Sanction already ended"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /admin/sanctions/{id} [delete]
+func LiftSanctionHandler(c *gin.Context) {
+	adminID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	sanctionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	existing, err := infoDB.GetSanction(sanctionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "sanction not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ok, reason := canSanction(c, adminID.(int), existing.UserID); !ok {
+		status := http.StatusForbidden
+		if existing.UserID == adminID.(int) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": reason})
+		return
+	}
+
+	sanction, err := infoDB.LiftSanction(sanctionID, adminID.(int))
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "sanction not found"})
+		return
+	case err == infoDB.ErrSanctionNotActive:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil && sanction.ID == 0:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(adminID.(int), "sanction_lift", "user", sanction.UserID, gin.H{
+		"sanction_id": sanction.ID,
+		"type":        sanction.Type,
+	}, c)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "sanction": sanction})
+		return
+	}
+
+	c.JSON(http.StatusOK, sanction)
+}
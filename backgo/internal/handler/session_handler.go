@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+func deviceInfo(c *gin.Context) infoDB.DeviceInfo {
+	return infoDB.DeviceInfo{
+		UserAgent: c.GetHeader("User-Agent"),
+		IP:        c.ClientIP(),
+	}
+}
+
+// currentSessionID returns the session of the refresh token cookie, if any.
+func currentSessionID(c *gin.Context) string {
+	refreshToken, err := c.Cookie("refresh_token")
+	if err != nil {
+		return ""
+	}
+	sessionID, err := infoDB.GetSessionID(refreshToken)
+	if err != nil {
+		return ""
+	}
+	return sessionID
+}
+
+// GetSessionsHandler handles GET /api/auth/sessions
+
+// GetSessionsHandler godoc
+// @Summary      List sessions
+// @Description  List the devices the logged-in user is signed in on
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "data: []infoDB.Session, count: int"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/sessions [get]
+func GetSessionsHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	sessions, err := infoDB.GetActiveSessions(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := currentSessionID(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  sessions,
+		"count": len(sessions),
+	})
+}
+
+// RevokeSessionHandler handles DELETE /api/auth/sessions/:id
+
+// RevokeSessionHandler godoc
+// @Summary      Revoke session
+// @Description  Log out one device of the logged-in user
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Session ID"
+// @Success      200  {object}  map[string]interface{}  "Session revoked"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      404  {object}  map[string]interface{}  "Session not found"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/sessions/{id} [delete]
+func RevokeSessionHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	sessionID := c.Param("id")
+	err := infoDB.RevokeSession(userID.(int), sessionID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "session_revoke", "auth", sessionID, nil, c)
+
+	if sessionID == currentSessionID(c) {
+		clearAuthCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeAllSessionsHandler handles DELETE /api/auth/sessions
+
+// RevokeAllSessionsHandler godoc
+// @Summary      Log out everywhere
+// @Description  Revoke every session of the logged-in user, including the current one
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "All sessions revoked"
+// @Failure      401  {object}  map[string]interface{}  "Unauthorized"
+// @Failure      500  {object}  map[string]interface{}  "Internal server error"
+// @Router       /auth/sessions [delete]
+func RevokeAllSessionsHandler(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := infoDB.RevokeAllRefreshTokens(userID.(int)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := infoDB.BumpTokenVersion(userID.(int)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	infoDB.LogAudit(userID.(int), "logout_all", "auth", nil, nil, c)
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out of all sessions"})
+}
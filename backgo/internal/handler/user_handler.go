@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"backgo/internal/infoDB"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserProfileHandler handles GET /api/users/:username
+
+// GetUserProfileHandler godoc
+// @Summary      Get public user profile
+// @Description  Get display info, join date, review count, likes received and favourite breeds of a user
+// @Tags         users
+// @Produce      json
+// @Param        username  path      string  true  "Username"
+// @Success      200       {object}  infoDB.PublicProfile
+// @Failure      404       {object}  map[string]interface{}  "User not found"
+// @Failure      500       {object}  map[string]interface{}  "Internal server error"
+// @Router       /users/{username} [get]
+func GetUserProfileHandler(c *gin.Context) {
+	var currentUserID *int
+	if uid, exists := c.Get("user_id"); exists {
+		id := uid.(int)
+		currentUserID = &id
+	}
+
+	profile, err := infoDB.GetPublicProfile(c.Param("username"), currentUserID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+const (
+	defaultDiscussionPageSize = 20
+	maxDiscussionPageSize     = 100
+)
+
+// discussionPage reads the limit and offset of a discussion feed, clamping
+// limit to 1..maxDiscussionPageSize. It responds 400 and returns false when
+// either is not a number or offset is negative.
+func discussionPage(c *gin.Context) (limit, offset int, ok bool) {
+	limit, err1 := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDiscussionPageSize)))
+	offset, err2 := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err1 != nil || err2 != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit and offset must be numbers and offset cannot be negative"})
+		return 0, 0, false
+	}
+	if limit < 1 {
+		limit = 1
+	} else if limit > maxDiscussionPageSize {
+		limit = maxDiscussionPageSize
+	}
+	return limit, offset, true
+}
+
+// GetUserDiscussionsHandler handles GET /api/users/:username/discussions
+
+// GetUserDiscussionsHandler godoc
+// @Summary      Get user reviews
+// @Description  Get the paginated review feed of a user
+// @Tags         users, discussions
+// @Produce      json
+// @Param        username  path      string  true   "Username"
+// @Param        limit     query     int     false  "Limit, 1 to 100"  default(20)
+// @Param        offset    query     int     false  "Offset"          default(0)
+// @Success      200       {object}  map[string]interface{}  "data: []infoDB.Discussion, count: int, total: int"
+// @Failure      400       {object}  map[string]interface{}  "Invalid limit or offset"
+// @Failure      404       {object}  map[string]interface{}  "User not found"
+// @Failure      500       {object}  map[string]interface{}  "Internal server error"
+// @Router       /users/{username}/discussions [get]
+func GetUserDiscussionsHandler(c *gin.Context) {
+	userID, err := infoDB.GetUserIDByUsername(c.Param("username"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, offset, ok := discussionPage(c)
+	if !ok {
+		return
+	}
+
+	var currentUserID *int
+	if uid, exists := c.Get("user_id"); exists {
+		id := uid.(int)
+		currentUserID = &id
+	}
+
+	discussions, err := infoDB.GetDiscussionsByUserID(userID, currentUserID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total, err := infoDB.CountDiscussionsByUserID(userID, currentUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  discussions,
+		"count": len(discussions),
+		"total": total,
+	})
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDiscussionPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query      string
+		wantOK     bool
+		wantLimit  int
+		wantOffset int
+	}{
+		{"", true, 20, 0},
+		{"?limit=50&offset=40", true, 50, 40},
+		{"?limit=0", true, 1, 0},
+		{"?limit=-5", true, 1, 0},
+		{"?limit=1000", true, 100, 0},
+		{"?offset=-1", false, 0, 0},
+		{"?limit=ten", false, 0, 0},
+		{"?offset=ten", false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+
+			limit, offset, ok := discussionPage(c)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+				}
+				return
+			}
+			if limit != tt.wantLimit || offset != tt.wantOffset {
+				t.Fatalf("discussionPage() = %d, %d, want %d, %d", limit, offset, tt.wantLimit, tt.wantOffset)
+			}
+		})
+	}
+}
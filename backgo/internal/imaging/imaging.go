@@ -0,0 +1,251 @@
+// Package imaging checks uploaded images, removes the metadata cameras and
+// editors embed in them and makes thumbnails, all in pure Go.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	GIF  Format = "gif"
+	WebP Format = "webp"
+)
+
+func (f Format) Ext() string {
+	switch f {
+	case JPEG:
+		return ".jpg"
+	case PNG:
+		return ".png"
+	case GIF:
+		return ".gif"
+	case WebP:
+		return ".webp"
+	}
+	return ""
+}
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+const (
+	// Limits on decoded size, checked from the header before decoding so a
+	// small file cannot claim a huge canvas and exhaust memory.
+	MaxSide   = 12000
+	MaxPixels = 40_000_000
+
+	originalQuality  = 92
+	thumbnailQuality = 82
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("image must be a JPEG, PNG, GIF or WebP file")
+	ErrInvalidImage      = errors.New("image file is corrupt or truncated")
+	ErrTooLarge          = fmt.Errorf("image must be at most %d pixels on a side and %d megapixels", MaxSide, MaxPixels/1_000_000)
+)
+
+// Sniff identifies an image from its magic bytes, whatever the file name or
+// declared content type say.
+func Sniff(data []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return JPEG, true
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, true
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return GIF, true
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return WebP, true
+	}
+	return "", false
+}
+
+// Image is an encoded image with its dimensions.
+type Image struct {
+	Data   []byte
+	Format Format
+	Width  int
+	Height int
+}
+
+// Result is an uploaded image with its metadata removed and its thumbnails,
+// smallest first.
+type Result struct {
+	Original   Image
+	Thumbnails []Image
+}
+
+// Process checks that data is an image in a supported format, strips its
+// metadata and makes a thumbnail for each of widths that is narrower than
+// the image. Thumbnails are JPEG unless the image has transparency, then
+// PNG. A JPEG that its EXIF data says to rotate is rotated and re-encoded,
+// since the orientation would be lost with the rest of the metadata.
+func Process(data []byte, widths []int) (*Result, error) {
+	format, ok := Sniff(data)
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	cfg, err := decodeConfig(format, data)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width > MaxSide || cfg.Height > MaxSide || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, err := decode(format, data)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	stripped, orientation, err := stripMetadata(format, data)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if orientation > 1 {
+		img = orient(img, orientation)
+		if stripped, err = encode(img, JPEG, originalQuality); err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := img.Bounds()
+	result := &Result{Original: Image{Data: stripped, Format: format, Width: bounds.Dx(), Height: bounds.Dy()}}
+	sorted := append([]int(nil), widths...)
+	sort.Ints(sorted)
+	for i, width := range sorted {
+		if width <= 0 || (i > 0 && width == sorted[i-1]) {
+			continue
+		}
+		if width >= bounds.Dx() {
+			break
+		}
+		thumb, err := thumbnail(img, width)
+		if err != nil {
+			return nil, err
+		}
+		result.Thumbnails = append(result.Thumbnails, thumb)
+	}
+	return result, nil
+}
+
+func decodeConfig(format Format, data []byte) (image.Config, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case JPEG:
+		return jpeg.DecodeConfig(r)
+	case PNG:
+		return png.DecodeConfig(r)
+	case GIF:
+		return gif.DecodeConfig(r)
+	case WebP:
+		return webp.DecodeConfig(r)
+	}
+	return image.Config{}, ErrUnsupportedFormat
+}
+
+// decode decodes the image, only the first frame of an animated GIF.
+func decode(format Format, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch format {
+	case JPEG:
+		return jpeg.Decode(r)
+	case PNG:
+		return png.Decode(r)
+	case GIF:
+		return gif.Decode(r)
+	case WebP:
+		return webp.Decode(r)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+func encode(img image.Image, format Format, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case PNG:
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	default:
+		err = ErrUnsupportedFormat
+	}
+	return buf.Bytes(), err
+}
+
+// thumbnail scales img to width, keeping its aspect ratio.
+func thumbnail(img image.Image, width int) (Image, error) {
+	src := img.Bounds()
+	height := (src.Dy()*width + src.Dx()/2) / src.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+
+	format := PNG
+	if dst.Opaque() {
+		format = JPEG
+	}
+	data, err := encode(dst, format, thumbnailQuality)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Data: data, Format: format, Width: width, Height: height}, nil
+}
+
+// orient turns img the way EXIF orientation o says it should be shown.
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
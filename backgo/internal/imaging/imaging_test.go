@@ -0,0 +1,365 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halves is a w×h image, red on the left and blue on the right.
+func halves(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: alpha}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: alpha}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func segment(marker byte, payload []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// exifOrientationSegment is an APP1 segment holding only an orientation tag.
+func exifOrientationSegment(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	return segment(0xe1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// jpegWith encodes img and inserts segments straight after the SOI marker.
+func jpegWith(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func pngChunk(chunkType string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngWith encodes img and inserts chunks straight after IHDR.
+func pngWith(t *testing.T, img image.Image, chunks ...[]byte) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	const afterIHDR = 8 + 12 + 13
+	out := append([]byte(nil), data[:afterIHDR]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	return append(out, data[afterIHDR:]...)
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		want   Format
+		wantOK bool
+	}{
+		{"JPEG", []byte{0xff, 0xd8, 0xff, 0xe0}, JPEG, true},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n...."), PNG, true},
+		{"GIF87a", []byte("GIF87a...."), GIF, true},
+		{"GIF89a", []byte("GIF89a...."), GIF, true},
+		{"WebP", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), WebP, true},
+		{"RIFF that is not WebP", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), "", false},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "", false},
+		{"HTML named .jpg", []byte("<html><script>alert(1)</script>"), "", false},
+		{"truncated JPEG magic", []byte{0xff, 0xd8}, "", false},
+		{"empty", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Sniff(tt.data)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("Sniff() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	frame := func() *image.Paletted {
+		return image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	}
+	var gifBuf bytes.Buffer
+	if err := gif.EncodeAll(&gifBuf, &gif.GIF{
+		Image: []*image.Paletted{frame(), frame()},
+		Delay: []int{10, 10},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	gifData := gifBuf.Bytes()
+	// A comment extension straight after the header and logical screen
+	// descriptor; the encoder writes no global colour table here.
+	const afterHeader = 13
+	comment := append([]byte{0x21, 0xfe, 9}, []byte("GPS 51.5N")...)
+	comment = append(comment, 0)
+	gifWithComment := append(append(append([]byte(nil), gifData[:afterHeader]...), comment...), gifData[afterHeader:]...)
+
+	tests := []struct {
+		name string
+		data []byte
+		// gone are byte strings that must not survive; kept ones must.
+		gone   []string
+		kept   []string
+		format Format
+	}{
+		{
+			name: "JPEG EXIF, IPTC and comment",
+			data: jpegWith(t, halves(64, 32, 255),
+				exifOrientationSegment(1),
+				segment(0xed, []byte("Photoshop 3.0\x00IPTC secret")),
+				segment(0xfe, []byte("shot at 51.5N 0.1W")),
+				segment(0xe2, []byte("ICC_PROFILE\x00keep me")),
+			),
+			gone:   []string{"Exif", "IPTC secret", "51.5N"},
+			kept:   []string{"ICC_PROFILE"},
+			format: JPEG,
+		},
+		{
+			name: "PNG text and EXIF chunks",
+			data: pngWith(t, halves(64, 32, 255),
+				pngChunk("tEXt", []byte("Author\x00Jane")),
+				pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")),
+				pngChunk("eXIf", []byte("MM\x00\x2a")),
+				pngChunk("gAMA", []byte{0, 0, 0xb1, 0x8f}),
+			),
+			gone:   []string{"Author", "xmpmeta", "eXIf"},
+			kept:   []string{"gAMA"},
+			format: PNG,
+		},
+		{
+			name:   "GIF comment",
+			data:   gifWithComment,
+			gone:   []string{"GPS 51.5N"},
+			kept:   []string{"NETSCAPE2.0"},
+			format: GIF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(tt.data, nil)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			out := result.Original.Data
+			if result.Original.Format != tt.format {
+				t.Fatalf("format = %q, want %q", result.Original.Format, tt.format)
+			}
+			for _, s := range tt.gone {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q survived", s)
+				}
+			}
+			for _, s := range tt.kept {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q was removed", s)
+				}
+			}
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("stripped image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessOrientation(t *testing.T) {
+	tests := []struct {
+		orientation  uint16
+		wantW, wantH int
+		topLeftRed   bool
+	}{
+		{1, 100, 60, true},
+		{3, 100, 60, false},
+		{6, 60, 100, true},
+		{8, 60, 100, false},
+	}
+	for _, tt := range tests {
+		data := jpegWith(t, halves(100, 60, 255), exifOrientationSegment(tt.orientation))
+		result, err := Process(data, nil)
+		if err != nil {
+			t.Fatalf("orientation %d: %v", tt.orientation, err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(result.Original.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := img.Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH || result.Original.Width != tt.wantW || result.Original.Height != tt.wantH {
+			t.Fatalf("orientation %d: %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+		r, _, _, _ := img.At(5, 5).RGBA()
+		if isRed := r > 0x8000; isRed != tt.topLeftRed {
+			t.Errorf("orientation %d: top left red = %v, want %v", tt.orientation, isRed, tt.topLeftRed)
+		}
+		if bytes.Contains(result.Original.Data, []byte("Exif")) {
+			t.Errorf("orientation %d: EXIF survived", tt.orientation)
+		}
+	}
+}
+
+func TestProcessThumbnails(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		widths     []int
+		wantWidths []int
+		wantHeight int
+		wantFormat Format
+	}{
+		{
+			name:       "opaque image gets JPEG thumbnails",
+			data:       jpegWith(t, halves(1000, 600, 255)),
+			widths:     []int{640, 160, 320, 160, 0, -1, 1000, 2000},
+			wantWidths: []int{160, 320, 640},
+			wantHeight: 96,
+			wantFormat: JPEG,
+		},
+		{
+			name:       "transparent image gets PNG thumbnails",
+			data:       pngWith(t, halves(400, 200, 128)),
+			widths:     []int{100},
+			wantWidths: []int{100},
+			wantHeight: 50,
+			wantFormat: PNG,
+		},
+		{
+			name:   "image narrower than every width",
+			data:   pngWith(t, halves(80, 40, 255)),
+			widths: []int{160, 320},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Process(tt.data, tt.widths)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Thumbnails) != len(tt.wantWidths) {
+				t.Fatalf("%d thumbnails, want widths %v", len(result.Thumbnails), tt.wantWidths)
+			}
+			for i, thumb := range result.Thumbnails {
+				if thumb.Width != tt.wantWidths[i] || thumb.Format != tt.wantFormat {
+					t.Fatalf("thumbnail %d = %d wide %s, want %d wide %s", i, thumb.Width, thumb.Format, tt.wantWidths[i], tt.wantFormat)
+				}
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(thumb.Data))
+				if err != nil || format != string(tt.wantFormat) || cfg.Width != thumb.Width || cfg.Height != thumb.Height {
+					t.Fatalf("thumbnail %d decodes as %s %dx%d, %v", i, format, cfg.Width, cfg.Height, err)
+				}
+			}
+			if len(result.Thumbnails) > 0 && result.Thumbnails[0].Height != tt.wantHeight {
+				t.Fatalf("first thumbnail height = %d, want %d", result.Thumbnails[0].Height, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	valid := pngWith(t, halves(16, 16, 255))
+
+	// A PNG header claiming a canvas far larger than the file.
+	huge := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint32(huge[16:], 20000)
+	binary.BigEndian.PutUint32(huge[20:], 20000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not an image", []byte("GIF? no, plain text"), ErrUnsupportedFormat},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), ErrUnsupportedFormat},
+		{"truncated PNG", valid[:len(valid)/2], ErrInvalidImage},
+		{"truncated JPEG", jpegWith(t, halves(64, 64, 255))[:200], ErrInvalidImage},
+		{"magic bytes only", []byte{0xff, 0xd8, 0xff, 0xe0, 0, 0}, ErrInvalidImage},
+		{"decompression bomb", huge, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data, nil); !errors.Is(err, tt.want) {
+				t.Fatalf("Process() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	chunk := func(fourCC string, payload []byte) []byte {
+		c := append([]byte(fourCC), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(c[4:], uint32(len(payload)))
+		c = append(c, payload...)
+		if len(payload)%2 == 1 {
+			c = append(c, 0)
+		}
+		return c
+	}
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x10 | 0x08 | 0x04 // alpha, EXIF and XMP
+
+	body := []byte("WEBP")
+	body = append(body, chunk("VP8X", vp8x)...)
+	body = append(body, chunk("VP8L", []byte("pixels"))...)
+	body = append(body, chunk("EXIF", []byte("MM\x00\x2aGPS"))...)
+	body = append(body, chunk("XMP ", []byte("<x:xmpmeta/>!"))...)
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+
+	out, err := stripWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"EXIF", "GPS", "XMP ", "xmpmeta"} {
+		if bytes.Contains(out, []byte(s)) {
+			t.Errorf("%q survived", s)
+		}
+	}
+	if !bytes.Contains(out, []byte("VP8L\x06\x00\x00\x00pixels")) {
+		t.Error("image data was removed")
+	}
+	if got := binary.LittleEndian.Uint32(out[4:]); int(got) != len(out)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(out)-8)
+	}
+	if flags := out[20]; flags != 0x10 {
+		t.Errorf("VP8X flags = %#x, want only alpha (0x10)", flags)
+	}
+
+	if _, err := stripWebP(data[:len(data)-3]); err == nil {
+		t.Error("stripWebP() accepted a truncated chunk")
+	}
+}
@@ -0,0 +1,269 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image structure")
+
+// stripMetadata removes EXIF, XMP, IPTC, comments and similar metadata by
+// rewriting the container, so pixel data is never re-encoded. For JPEG it
+// also returns the EXIF orientation, 0 when there is none.
+func stripMetadata(format Format, data []byte) ([]byte, int, error) {
+	switch format {
+	case JPEG:
+		return stripJPEG(data)
+	case PNG:
+		out, err := stripPNG(data)
+		return out, 0, err
+	case GIF:
+		out, err := stripGIF(data)
+		return out, 0, err
+	case WebP:
+		out, err := stripWebP(data)
+		return out, 0, err
+	}
+	return nil, 0, ErrUnsupportedFormat
+}
+
+// stripJPEG drops every APPn segment but JFIF (APP0), the ICC colour profile
+// (APP2) and Adobe's colour transform flag (APP14), and all comments.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 0
+
+	i := 2
+	for {
+		if i >= len(data) || data[i] != 0xff {
+			return nil, 0, errMalformed
+		}
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i >= len(data) {
+			return nil, 0, errMalformed
+		}
+		marker := data[i]
+		i++
+		if marker == 0xd9 {
+			out.Write([]byte{0xff, marker})
+			return out.Bytes(), orientation, nil
+		}
+		if i+2 > len(data) {
+			return nil, 0, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, 0, errMalformed
+		}
+		segment := data[i+2 : i+length]
+
+		keep := true
+		switch {
+		case marker == 0xe1:
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) && orientation == 0 {
+				orientation = exifOrientation(segment[6:])
+			}
+			keep = false
+		case marker >= 0xe0 && marker <= 0xef:
+			keep = marker == 0xe0 || marker == 0xe2 || marker == 0xee
+		case marker == 0xfe:
+			keep = false
+		}
+		if keep {
+			out.Write([]byte{0xff, marker})
+			out.Write(data[i : i+length])
+		}
+		i += length
+
+		// Entropy-coded data follows the start of scan; nothing after it
+		// is metadata worth parsing.
+		if marker == 0xda {
+			out.Write(data[i:])
+			return out.Bytes(), orientation, nil
+		}
+	}
+}
+
+// exifOrientation reads the orientation tag from IFD0 of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// pngMetadataChunks are ancillary chunks that hold text, EXIF or edit times
+// rather than anything that affects how the image looks.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		chunkType := string(data[i+4 : i+8])
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		if chunkType == "IEND" {
+			return out.Bytes(), nil
+		}
+		i = end
+	}
+	return nil, errMalformed
+}
+
+// stripGIF drops comment extensions and application extensions other than
+// the looping ones; XMP is stored in an application extension.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 {
+		return nil, errMalformed
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:i])
+
+	// subBlocks returns the end of the data sub-blocks starting at j.
+	subBlocks := func(j int) (int, error) {
+		for j < len(data) {
+			size := int(data[j])
+			j++
+			if size == 0 {
+				return j, nil
+			}
+			j += size
+		}
+		return 0, errMalformed
+	}
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3b:
+			out.WriteByte(0x3b)
+			return out.Bytes(), nil
+
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+			label := data[i+1]
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label != 0xfe
+			if label == 0xff {
+				app := data[i+2 : end]
+				keep = len(app) >= 12 && (string(app[1:12]) == "NETSCAPE2.0" || string(app[1:12]) == "ANIMEXTS1.0")
+			}
+			if keep {
+				out.Write(data[start:end])
+			}
+			i = end
+
+		case 0x2c:
+			if i+10 > len(data) {
+				return nil, errMalformed
+			}
+			j := i + 10
+			if flags := data[i+9]; flags&0x80 != 0 {
+				j += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size, then the image data.
+			end, err := subBlocks(j + 1)
+			if err != nil || j >= len(data) {
+				return nil, errMalformed
+			}
+			out.Write(data[start:end])
+			i = end
+
+		default:
+			return nil, errMalformed
+		}
+	}
+	return nil, errMalformed
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// extended header.
+func stripWebP(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
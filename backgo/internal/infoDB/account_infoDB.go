@@ -0,0 +1,181 @@
+package infoDB
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateUserToken issues a single-use token for purpose. Only its hash is
+// stored; earlier unused tokens for the same purpose are invalidated.
+func CreateUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, hashToken(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// LookupUserToken returns the owner of a still usable token without using it
+// up, so the request can be validated before the token is spent.
+func LookupUserToken(token, purpose string) (int, error) {
+
+	var userID int
+	err := db.QueryRow(`
+		SELECT user_id FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	`, hashToken(token), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidUserToken
+	}
+	return userID, err
+}
+
+// ConsumeUserToken marks the token as used and returns its owner. A token can
+// only be consumed once and only before it expires.
+func ConsumeUserToken(token, purpose string) (int, error) {
+
+	var userID int
+	err := db.QueryRow(`
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, hashToken(token), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidUserToken
+	}
+	return userID, err
+}
+
+func GetUserByEmail(email string) (User, error) {
+
+	var user User
+	query := `SELECT id, username, email, password_hash, is_active, email_verified, password_reset_required, created_at
+			  FROM users WHERE LOWER(email) = LOWER($1)`
+
+	err := db.QueryRow(query, email).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.IsActive,
+		&user.EmailVerified,
+		&user.PasswordResetRequired,
+		&user.CreatedAt,
+	)
+	return user, err
+}
+
+func GetPasswordHash(userID int) (string, error) {
+
+	var hash string
+	err := db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hash)
+	return hash, err
+}
+
+func UpdatePassword(userID int, newPassword string) error {
+
+	info, err := GetUserBaseInfoByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := ValidatePassword(newPassword, info.Username, info.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE users SET password_hash = $1, token_version = token_version + 1,
+			password_reset_required = FALSE
+		WHERE id = $2
+	`, hashedPassword, userID)
+	InvalidateAuthState(userID)
+	return err
+}
+
+func MarkEmailVerified(userID int) error {
+
+	_, err := db.Exec(`
+		UPDATE users SET email_verified = TRUE, email_verified_at = NOW()
+		WHERE id = $1 AND email_verified = FALSE
+	`, userID)
+	return err
+}
+
+func RevokeAllRefreshTokens(userID int) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := db.Exec(query, userID)
+	return err
+}
@@ -0,0 +1,370 @@
+package infoDB
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AdminUserSummary is a row of the admin user list.
+type AdminUserSummary struct {
+	ID                    int        `json:"id"`
+	Username              string     `json:"username"`
+	Email                 string     `json:"email"`
+	DisplayName           string     `json:"display_name"`
+	IsActive              bool       `json:"is_active"`
+	EmailVerified         bool       `json:"email_verified"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	Roles                 []string   `json:"roles"`
+	CreatedAt             time.Time  `json:"created_at"`
+	LastLogin             *time.Time `json:"last_login,omitempty"`
+}
+
+// AdminUserDetail adds activity counts to the summary.
+type AdminUserDetail struct {
+	AdminUserSummary
+	MFAEnabled     bool `json:"mfa_enabled"`
+	ReviewCount    int  `json:"review_count"`
+	ReplyCount     int  `json:"reply_count"`
+	ReactionCount  int  `json:"reaction_count"`
+	ActiveSessions int  `json:"active_sessions"`
+	ActiveAPIKeys  int  `json:"active_api_keys"`
+}
+
+// AdminUserQuery filters and orders the admin user list. Query matches
+// username, email and display name.
+type AdminUserQuery struct {
+	Query  string
+	Role   string
+	Active *bool
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+type DeactivateUserRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// adminUserSorts maps the sort keys the API accepts to columns. Users who
+// never logged in sort last either way.
+var adminUserSorts = map[string]string{
+	"created_at": "u.created_at",
+	"last_login": "u.last_login",
+	"username":   "u.username",
+}
+
+func IsValidAdminUserSort(sort string) bool {
+	_, ok := adminUserSorts[sort]
+	return ok
+}
+
+const adminUserColumns = `u.id, u.username, u.email, COALESCE(u.display_name, ''), u.is_active,
+	u.email_verified, u.password_reset_required, u.created_at, u.last_login,
+	COALESCE(ARRAY(
+		SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = u.id ORDER BY r.name
+	), '{}')`
+
+func scanAdminUser(scanner interface{ Scan(...interface{}) error }) (AdminUserSummary, error) {
+	var user AdminUserSummary
+	var emailVerified sql.NullBool
+	var isActive sql.NullBool
+	var roles pq.StringArray
+	err := scanner.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName, &isActive,
+		&emailVerified, &user.PasswordResetRequired, &user.CreatedAt, &user.LastLogin, &roles)
+	if err != nil {
+		return AdminUserSummary{}, err
+	}
+	user.IsActive = isActive.Bool
+	user.EmailVerified = emailVerified.Bool
+	user.Roles = []string(roles)
+	return user, nil
+}
+
+// ListUsers returns one page of users and the total number matching.
+func ListUsers(q AdminUserQuery) ([]AdminUserSummary, int, error) {
+
+	orderBy, ok := adminUserSorts[q.Sort]
+	if !ok {
+		orderBy = adminUserSorts["created_at"]
+	}
+	direction := "ASC"
+	if q.Desc {
+		direction = "DESC"
+	}
+
+	pattern := ""
+	if q.Query != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q.Query)
+		pattern = "%" + escaped + "%"
+	}
+
+	where := `
+		WHERE ($1 = '' OR u.username ILIKE $1 OR u.email ILIKE $1 OR u.display_name ILIKE $1)
+		AND ($2 = '' OR EXISTS (
+			SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+			WHERE ur.user_id = u.id AND r.name = $2
+		))
+		AND ($3::boolean IS NULL OR u.is_active = $3)
+	`
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users u `+where, pattern, q.Role, q.Active).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// orderBy and direction come from fixed lists above, never from input.
+	rows, err := db.Query(`
+		SELECT `+adminUserColumns+`
+		FROM users u
+		`+where+`
+		ORDER BY `+orderBy+` `+direction+` NULLS LAST, u.id `+direction+`
+		LIMIT $4 OFFSET $5
+	`, pattern, q.Role, q.Active, q.Limit, q.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []AdminUserSummary{}
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+func GetAdminUserDetail(userID int) (AdminUserDetail, error) {
+
+	summary, err := scanAdminUser(db.QueryRow(`
+		SELECT `+adminUserColumns+`
+		FROM users u
+		WHERE u.id = $1
+	`, userID))
+	if err != nil {
+		return AdminUserDetail{}, err
+	}
+
+	detail := AdminUserDetail{AdminUserSummary: summary}
+	err = db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM discussions WHERE user_id = $1 AND parent_id IS NULL AND is_deleted = FALSE),
+			(SELECT COUNT(*) FROM discussions WHERE user_id = $1 AND parent_id IS NOT NULL AND is_deleted = FALSE),
+			(SELECT COUNT(*) FROM breed_reactions WHERE user_id = $1)
+				+ (SELECT COUNT(*) FROM discussion_reactions WHERE user_id = $1),
+			(SELECT COUNT(DISTINCT family_id) FROM refresh_tokens
+				WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()),
+			(SELECT COUNT(*) FROM api_keys
+				WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())),
+			COALESCE((SELECT enabled FROM user_mfa WHERE user_id = $1), FALSE)
+	`, userID).Scan(&detail.ReviewCount, &detail.ReplyCount, &detail.ReactionCount,
+		&detail.ActiveSessions, &detail.ActiveAPIKeys, &detail.MFAEnabled)
+	if err != nil {
+		return AdminUserDetail{}, err
+	}
+	return detail, nil
+}
+
+// lockAdminCount locks every admin assignment and counts the admins that are
+// still active, so two admins cannot lock each other out at the same time.
+func lockAdminCount(tx *sql.Tx) (int, error) {
+	var admins int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM (
+			SELECT ur.user_id FROM user_roles ur
+			JOIN roles r ON r.id = ur.role_id
+			JOIN users u ON u.id = ur.user_id
+			WHERE r.name = 'admin' AND u.is_active = TRUE
+			FOR UPDATE OF ur
+		) a
+	`).Scan(&admins)
+	return admins, err
+}
+
+// isActiveAdmin reports whether the user is an active admin, the only kind
+// whose demotion or deactivation can leave nobody able to administer the site.
+func isActiveAdmin(tx *sql.Tx, userID int) (bool, error) {
+	var active bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_roles ur
+			JOIN roles r ON r.id = ur.role_id
+			JOIN users u ON u.id = ur.user_id
+			WHERE ur.user_id = $1 AND r.name = 'admin' AND u.is_active = TRUE
+		)
+	`, userID).Scan(&active)
+	return active, err
+}
+
+// SetUserActive activates or deactivates an account. Deactivating ends every
+// session and invalidates issued access tokens; API keys stop working
+// because they check the owner's status on each use.
+func SetUserActive(userID int, active bool) error {
+
+	err := func() (err error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			} else if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+
+		if !active {
+			isAdmin, err := isActiveAdmin(tx, userID)
+			if err != nil {
+				return err
+			}
+			if isAdmin {
+				admins, err := lockAdminCount(tx)
+				if err != nil {
+					return err
+				}
+				if admins <= 1 {
+					return ErrLastAdmin
+				}
+			}
+		}
+
+		result, err := tx.Exec(`
+			UPDATE users SET is_active = $2, token_version = token_version + 1
+			WHERE id = $1
+		`, userID, active)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+
+		if !active {
+			_, err = tx.Exec(`
+				UPDATE refresh_tokens SET revoked_at = NOW()
+				WHERE user_id = $1 AND revoked_at IS NULL
+			`, userID)
+		}
+		return err
+	}()
+	InvalidateAuthState(userID)
+	return err
+}
+
+// SetUserRoles replaces the user's roles with exactly roles.
+func SetUserRoles(userID int, roles []string) error {
+	err := func() (err error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			} else if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+
+		roleIDs := make([]int64, 0, len(roles))
+		keepsAdmin := false
+		for _, name := range roles {
+			var roleID int64
+			err = tx.QueryRow(`SELECT id FROM roles WHERE name = $1`, name).Scan(&roleID)
+			if err == sql.ErrNoRows {
+				return ErrUnknownRole
+			} else if err != nil {
+				return err
+			}
+			roleIDs = append(roleIDs, roleID)
+			keepsAdmin = keepsAdmin || name == "admin"
+		}
+
+		if !keepsAdmin {
+			wasAdmin, err := isActiveAdmin(tx, userID)
+			if err != nil {
+				return err
+			}
+			if wasAdmin {
+				admins, err := lockAdminCount(tx)
+				if err != nil {
+					return err
+				}
+				if admins <= 1 {
+					return ErrLastAdmin
+				}
+			}
+		}
+
+		if _, err = tx.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT $1, unnest($2::int[])
+			ON CONFLICT DO NOTHING
+		`, userID, pq.Array(roleIDs))
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	return roleAssignmentChanged(userID)
+}
+
+// RequirePasswordReset refuses password logins until the user sets a new
+// password, and ends every current session.
+func RequirePasswordReset(userID int) error {
+
+	result, err := db.Exec(`
+		UPDATE users SET password_reset_required = TRUE, token_version = token_version + 1
+		WHERE id = $1
+	`, userID)
+	InvalidateAuthState(userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return RevokeAllRefreshTokens(userID)
+}
@@ -0,0 +1,268 @@
+package infoDB
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	apiKeyTag         = "cbk"
+	maxAPIKeysPerUser = 20
+	DefaultAPIKeyDays = 90
+	MaxAPIKeyDays     = 365
+	apiKeyUseThrottle = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid or expired API key")
+	ErrTooManyAPIKeys    = errors.New("API key limit reached, revoke an unused key first")
+	ErrScopeNotPermitted = errors.New("API key scopes must be permissions you hold")
+)
+
+// APIKey is the stored description of a key; the secret itself is only ever
+// returned once, when the key is created.
+type APIKey struct {
+	ID                 int        `json:"id"`
+	UserID             int        `json:"user_id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute *int       `json:"rate_limit_per_minute,omitempty"`
+	MFAVerified        bool       `json:"mfa_verified"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt         *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP         string     `json:"last_used_ip,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays defaults to DefaultAPIKeyDays.
+	ExpiresInDays      int  `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+	RateLimitPerMinute *int `json:"rate_limit_per_minute" binding:"omitempty,min=1,max=10000"`
+}
+
+// APIKeyPrincipal is who a request authenticated with an API key acts as.
+type APIKeyPrincipal struct {
+	KeyID              int
+	UserID             int
+	Username           string
+	Roles              []string
+	Scopes             []string
+	RateLimitPerMinute int
+	// MFAVerified is whether the key was created from an MFA-verified
+	// session.
+	MFAVerified bool
+}
+
+// newAPIKey returns a key of the form cbk_<prefix>_<secret>. The prefix is
+// stored in clear so keys can be recognised in listings and logs.
+func newAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyTag + "_" + hex.EncodeToString(b)
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, rate_limit_per_minute, mfa_verified,
+	expires_at, last_used_at, COALESCE(last_used_ip, ''), created_at, revoked_at`
+
+func scanAPIKey(scanner interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var scopes pq.StringArray
+	var rateLimit sql.NullInt64
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := scanner.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &rateLimit, &key.MFAVerified,
+		&expiresAt, &lastUsedAt, &key.LastUsedIP, &key.CreatedAt, &revokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	key.Scopes = []string(scopes)
+	if rateLimit.Valid {
+		limit := int(rateLimit.Int64)
+		key.RateLimitPerMinute = &limit
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+// CreateAPIKey issues a key for userID. Scopes must be a subset of the
+// owner's current permissions. mfaVerified records whether the session
+// creating the key passed MFA, which the key then carries.
+func CreateAPIKey(userID int, req CreateAPIKeyRequest, mfaVerified bool) (APIKey, string, error) {
+
+	permissions, err := GetUserPermissions(userID)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := map[string]bool{}
+	for _, scope := range req.Scopes {
+		scope = strings.TrimSpace(scope)
+		if !permissions[scope] {
+			return APIKey{}, "", ErrScopeNotPermitted
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var active int
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, userID).Scan(&active)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	if active >= maxAPIKeysPerUser {
+		return APIKey{}, "", ErrTooManyAPIKeys
+	}
+
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = DefaultAPIKeyDays
+	}
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+
+	secret, prefix, err := newAPIKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key, err := scanAPIKey(db.QueryRow(`
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, rate_limit_per_minute, mfa_verified, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+apiKeyColumns,
+		userID, strings.TrimSpace(req.Name), prefix, hashToken(secret), pq.Array(scopes),
+		req.RateLimitPerMinute, mfaVerified, expiresAt))
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// GetAPIKeys lists the keys of a user, or of every user when userID is nil.
+func GetAPIKeys(userID *int) ([]APIKey, error) {
+
+	rows, err := db.Query(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE ($1::int IS NULL OR user_id = $1)
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key. When ownerID is non-nil the key must belong to
+// that user; admins pass nil. It returns the key's owner.
+func RevokeAPIKey(keyID int, ownerID *int) (int, error) {
+
+	var userID int
+	err := db.QueryRow(`
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND ($2::int IS NULL OR user_id = $2) AND revoked_at IS NULL
+		RETURNING user_id
+	`, keyID, ownerID).Scan(&userID)
+	return userID, err
+}
+
+// AuthenticateAPIKey resolves a presented key. The effective permissions of
+// the key are its scopes limited to what the owner still holds.
+func AuthenticateAPIKey(secret, ip string) (*APIKeyPrincipal, error) {
+
+	if !strings.HasPrefix(secret, apiKeyTag+"_") {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var principal APIKeyPrincipal
+	var scopes pq.StringArray
+	var rateLimit sql.NullInt64
+	var isActive bool
+	err := db.QueryRow(`
+		SELECT k.id, k.user_id, u.username, u.is_active, k.scopes, k.rate_limit_per_minute, k.mfa_verified
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	`, hashToken(secret)).Scan(&principal.KeyID, &principal.UserID, &principal.Username,
+		&isActive, &scopes, &rateLimit, &principal.MFAVerified)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+	if !isActive {
+		return nil, ErrInvalidAPIKey
+	}
+	if rateLimit.Valid {
+		principal.RateLimitPerMinute = int(rateLimit.Int64)
+	}
+
+	permissions, err := GetUserPermissions(principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	principal.Scopes = []string{}
+	for _, scope := range scopes {
+		if permissions[scope] {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+
+	principal.Roles, err = GetUserRoles(principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writing on every request would turn reads into writes, so last use is
+	// recorded at most once per apiKeyUseThrottle.
+	_, err = db.Exec(`
+		UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - make_interval(secs => $3))
+	`, principal.KeyID, ip, apiKeyUseThrottle.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return &principal, nil
+}
@@ -0,0 +1,224 @@
+package infoDB
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// auditGenesisHash is the prev_hash of the first entry in the chain.
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// auditChainLock is the advisory lock that serialises appends to the chain,
+// so several API instances can share one audit table.
+const auditChainLock = 0x61756469 // "audi"
+
+// AuditChainBreak is an entry whose stored hashes do not match.
+type AuditChainBreak struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type AuditChainReport struct {
+	Entries  int64             `json:"entries"`
+	HeadID   int64             `json:"head_id"`
+	HeadHash string            `json:"head_hash"`
+	Breaks   []AuditChainBreak `json:"breaks"`
+}
+
+// AuditCheckpoint is a signed statement of the chain head at a point in
+// time. Rows deleted from the end of the chain leave no break behind, but
+// they do leave a checkpoint pointing past the new head.
+type AuditCheckpoint struct {
+	LastID   int64  `json:"last_id"`
+	LastHash string `json:"last_hash"`
+	jwt.RegisteredClaims
+}
+
+func auditAudience() string {
+	return tokenAudience + ":audit"
+}
+
+// canonicalAuditDetails re-encodes details the same way whether they come
+// from LogAudit or back out of jsonb, which reorders keys and drops spacing.
+func canonicalAuditDetails(raw []byte) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, nil
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(canonical), nil
+}
+
+// auditEntryHash hashes an entry's content and its predecessor's hash.
+// created_at is hashed at the microsecond precision PostgreSQL stores.
+func auditEntryHash(prevHash string, e auditEntry) string {
+	payload, _ := json.Marshal([]interface{}{
+		prevHash,
+		e.userID,
+		e.action,
+		e.resource,
+		e.resourceID,
+		e.details,
+		e.ip,
+		e.userAgent,
+		e.createdAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditChainHead returns the newest hashed entry, or the genesis hash for
+// an empty chain.
+func auditChainHead(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}) (int64, string, error) {
+	var id int64
+	var hash string
+	err := q.QueryRow(`
+		SELECT id, entry_hash FROM audit_logs
+		WHERE entry_hash IS NOT NULL
+		ORDER BY id DESC LIMIT 1
+	`).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return 0, auditGenesisHash, nil
+	}
+	return id, hash, err
+}
+
+// VerifyAuditChain walks every entry in order, recomputing each hash. After
+// a break it carries on from the stored hash, so one tampered row is
+// reported once rather than poisoning the rest of the chain.
+func VerifyAuditChain() (AuditChainReport, error) {
+	report := AuditChainReport{HeadHash: auditGenesisHash, Breaks: []AuditChainBreak{}}
+
+	rows, err := db.Query(`
+		SELECT id, user_id, action, resource, COALESCE(resource_id, ''), details,
+			COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at,
+			COALESCE(prev_hash, ''), COALESCE(entry_hash, '')
+		FROM audit_logs
+		ORDER BY id
+	`)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var userID sql.NullInt64
+		var details []byte
+		var prevHash, entryHash string
+		var e auditEntry
+		err := rows.Scan(&id, &userID, &e.action, &e.resource, &e.resourceID, &details,
+			&e.ip, &e.userAgent, &e.createdAt, &prevHash, &entryHash)
+		if err != nil {
+			return report, err
+		}
+		if userID.Valid {
+			e.userID = int(userID.Int64)
+		}
+		report.add(id, e, details, prevHash, entryHash)
+	}
+	return report, rows.Err()
+}
+
+// add checks the next entry of the chain against the ones before it.
+// details is the entry's details as stored.
+func (report *AuditChainReport) add(id int64, e auditEntry, details []byte, prevHash, entryHash string) {
+	report.Entries++
+	report.HeadID = id
+
+	var err error
+	e.details, err = canonicalAuditDetails(details)
+
+	switch {
+	case entryHash == "":
+		// Not part of the chain, so the next entry follows on from the
+		// one before.
+		report.Breaks = append(report.Breaks, AuditChainBreak{ID: id, Reason: "entry has no hash"})
+		return
+	case err != nil:
+		report.Breaks = append(report.Breaks, AuditChainBreak{ID: id, Reason: "details are not valid JSON"})
+	case prevHash != report.HeadHash:
+		report.Breaks = append(report.Breaks, AuditChainBreak{ID: id, Reason: "previous hash does not match the entry before it; entries were deleted or reordered"})
+	case auditEntryHash(prevHash, e) != entryHash:
+		report.Breaks = append(report.Breaks, AuditChainBreak{ID: id, Reason: "content does not match its hash; entry was modified"})
+	}
+	report.HeadHash = entryHash
+}
+
+// IssueAuditCheckpoint signs the current chain head with the token signing
+// key, so it can be checked against the published JWKS.
+func IssueAuditCheckpoint() (string, *AuditCheckpoint, error) {
+	id, hash, err := auditChainHead(db)
+	if err != nil {
+		return "", nil, err
+	}
+
+	checkpoint := &AuditCheckpoint{
+		LastID:   id,
+		LastHash: hash,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   tokenIssuer,
+			Audience: jwt.ClaimStrings{auditAudience()},
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	signed, err := signToken(checkpoint)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, checkpoint, nil
+}
+
+// CheckAuditCheckpoint verifies a checkpoint's signature and that the entry
+// it names is still in the table with the same hash.
+func CheckAuditCheckpoint(token string) (*AuditCheckpoint, error) {
+	if keyring == nil {
+		return nil, fmt.Errorf("no signing keyring configured")
+	}
+
+	checkpoint := &AuditCheckpoint{}
+	_, err := jwt.ParseWithClaims(token, checkpoint, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(auditAudience()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if checkpoint.LastID == 0 {
+		return checkpoint, nil
+	}
+
+	var hash sql.NullString
+	err = db.QueryRow(`SELECT entry_hash FROM audit_logs WHERE id = $1`, checkpoint.LastID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return checkpoint, fmt.Errorf("entry %d is missing", checkpoint.LastID)
+	} else if err != nil {
+		return checkpoint, err
+	}
+	if hash.String != checkpoint.LastHash {
+		return checkpoint, fmt.Errorf("entry %d hash differs from the checkpoint", checkpoint.LastID)
+	}
+	return checkpoint, nil
+}
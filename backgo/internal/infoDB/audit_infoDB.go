@@ -0,0 +1,254 @@
+package infoDB
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultAuditBuffer = 4096
+	auditBatchSize     = 100
+)
+
+type AuditLog struct {
+	ID         int64           `json:"id"`
+	UserID     *int            `json:"user_id,omitempty"`
+	Username   string          `json:"username,omitempty"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceID string          `json:"resource_id,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty" swaggertype:"object"`
+	IPAddress  string          `json:"ip_address"`
+	UserAgent  string          `json:"user_agent"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows an audit query. Zero values match everything. Before
+// is the pagination cursor: only entries with a smaller ID are returned.
+// A Limit of 0 means no limit.
+type AuditFilter struct {
+	UserID     *int
+	Action     string
+	Resource   string
+	ResourceID string
+	From       *time.Time
+	To         *time.Time
+	Before     int64
+	Limit      int
+}
+
+// auditEntry is a LogAudit call captured at request time, so it can be
+// written after the request context is gone.
+type auditEntry struct {
+	userID     interface{}
+	action     string
+	resource   string
+	resourceID string
+	details    interface{}
+	ip         string
+	userAgent  string
+	createdAt  time.Time
+}
+
+var (
+	auditQueue   chan auditEntry
+	auditStart   sync.Once
+	auditDropped atomic.Int64
+)
+
+// StartAuditWriter starts the background writer with room for buffer
+// pending entries. LogAudit starts it with DefaultAuditBuffer if nothing
+// else has.
+func StartAuditWriter(buffer int) {
+	auditStart.Do(func() {
+		auditQueue = make(chan auditEntry, buffer)
+		go runAuditWriter(auditQueue)
+	})
+}
+
+func runAuditWriter(queue <-chan auditEntry) {
+	batch := make([]auditEntry, 0, auditBatchSize)
+	for entry := range queue {
+		batch = append(batch[:0], entry)
+		// Drain whatever else is already waiting so bursts become one insert.
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case next := <-queue:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		if err := writeAuditBatch(batch); err != nil {
+			log.Printf("Failed to write %d audit entries: %v", len(batch), err)
+		}
+	}
+}
+
+// writeAuditBatch appends entries to the hash chain. The advisory lock keeps
+// concurrent writers from both extending the same head.
+func writeAuditBatch(batch []auditEntry) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return err
+	}
+	_, prevHash, err := auditChainHead(tx)
+	if err != nil {
+		return err
+	}
+
+	var query strings.Builder
+	query.WriteString(`INSERT INTO audit_logs
+		(user_id, action, resource, resource_id, details, ip_address, user_agent, created_at, prev_hash, entry_hash)
+		VALUES `)
+	args := make([]interface{}, 0, len(batch)*10)
+	for i, e := range batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := i * 10
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10)
+		entryHash := auditEntryHash(prevHash, e)
+		args = append(args, e.userID, e.action, e.resource, e.resourceID, e.details, e.ip, e.userAgent, e.createdAt, prevHash, entryHash)
+		prevHash = entryHash
+	}
+
+	// Rows of a multi-row VALUES take their serial IDs in order, so ID order
+	// is chain order.
+	_, err = tx.Exec(query.String(), args...)
+	return err
+}
+
+// LogAudit records an action. The entry is queued and written in the
+// background; when the queue is full the entry is dropped and counted
+// rather than making the request wait on the database.
+func LogAudit(userID int, action, resource string, resourceID interface{}, details map[string]interface{}, c *gin.Context) {
+	StartAuditWriter(DefaultAuditBuffer)
+
+	entry := auditEntry{
+		action:    action,
+		resource:  resource,
+		ip:        c.ClientIP(),
+		userAgent: c.GetHeader("User-Agent"),
+		createdAt: time.Now().Truncate(time.Microsecond),
+	}
+	if details != nil {
+		// Kept as a string: lib/pq would send []byte as bytea, which jsonb
+		// rejects.
+		if detailsJSON, err := json.Marshal(details); err == nil {
+			entry.details, _ = canonicalAuditDetails(detailsJSON)
+		}
+	}
+	if resourceID != nil {
+		entry.resourceID = fmt.Sprintf("%v", resourceID)
+	}
+	// Anonymous events such as failed logins for unknown users have no user.
+	if userID > 0 {
+		entry.userID = userID
+	}
+
+	select {
+	case auditQueue <- entry:
+	default:
+		if dropped := auditDropped.Add(1); dropped == 1 || dropped%100 == 0 {
+			log.Printf("Audit queue full, %d entries dropped so far", dropped)
+		}
+	}
+}
+
+const auditQuery = `
+	SELECT a.id, a.user_id, COALESCE(u.username, ''), a.action, a.resource,
+		COALESCE(a.resource_id, ''), a.details, COALESCE(a.ip_address, ''),
+		COALESCE(a.user_agent, ''), a.created_at
+	FROM audit_logs a
+	LEFT JOIN users u ON u.id = a.user_id
+	WHERE ($1::int IS NULL OR a.user_id = $1)
+	AND ($2 = '' OR a.action = $2)
+	AND ($3 = '' OR a.resource = $3)
+	AND ($4 = '' OR a.resource_id = $4)
+	AND ($5::timestamptz IS NULL OR a.created_at >= $5)
+	AND ($6::timestamptz IS NULL OR a.created_at < $6)
+	AND ($7::bigint = 0 OR a.id < $7)
+	ORDER BY a.id DESC
+	LIMIT $8
+`
+
+// EachAuditLog calls fn for every entry matching filter, newest first,
+// without holding them all in memory. It stops at the first error from fn.
+func EachAuditLog(filter AuditFilter, fn func(AuditLog) error) error {
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	rows, err := db.Query(auditQuery, filter.UserID, filter.Action, filter.Resource,
+		filter.ResourceID, filter.From, filter.To, filter.Before, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditLog
+		var details []byte
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Username, &entry.Action, &entry.Resource,
+			&entry.ResourceID, &details, &entry.IPAddress, &entry.UserAgent, &entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if len(details) > 0 && string(details) != "null" {
+			entry.Details = json.RawMessage(details)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// QueryAuditLogs returns one page of entries and the cursor for the next
+// page, which is 0 on the last page.
+func QueryAuditLogs(filter AuditFilter) ([]AuditLog, int64, error) {
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	logs := []AuditLog{}
+	err := EachAuditLog(filter, func(entry AuditLog) error {
+		logs = append(logs, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if len(logs) > pageSize {
+		logs = logs[:pageSize]
+		next = logs[pageSize-1].ID
+	}
+	return logs, next, nil
+}
@@ -2,13 +2,18 @@ package infoDB
 
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gin-gonic/gin"
+	"backgo/internal/jwtkeys"
+	"backgo/internal/passwordpolicy"
+
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,6 +25,8 @@ type User struct {
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
 	IsActive     bool      `json:"is_active"`
+	EmailVerified bool     `json:"email_verified"`
+	PasswordResetRequired bool `json:"-"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -28,6 +35,7 @@ type UserInfo struct {
 	Username string   `json:"username"`
 	Email    string   `json:"email"`
 	Roles    []string `json:"roles"`
+	Profile  *Profile `json:"profile,omitempty"`
 }
 
 type UserBaseInfo struct {
@@ -40,12 +48,12 @@ type UserBaseInfo struct {
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=3"`
+	Password string `json:"password" binding:"required"`
 	Role     string `json:"role"`
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+	Username string `json:"username" binding:"required,max=50"`
 	Password string `json:"password" binding:"required"`
 }
 
@@ -54,19 +62,66 @@ type RefreshRequest struct {
 }
 
 type CustomClaims struct {
-	UserID   int      `json:"user_id"`
-	Username string   `json:"username"`
-	Roles    []string `json:"roles"`
+	UserID       int      `json:"user_id"`
+	Username     string   `json:"username"`
+	Roles        []string `json:"roles"`
+	TokenVersion int      `json:"ver"`
+	// MFA is set when the session was started with a second factor.
+	MFA bool `json:"mfa,omitempty"`
 	jwt.RegisteredClaims
 }
 
 
-var jwtSecret = []byte("my-super-secret-key-change-in-production-2024")
+var (
+	keyring       *jwtkeys.Keyring
+	tokenIssuer   = "cat-breeds-api"
+	tokenAudience = "cat-breeds"
+)
+
+// SetKeyring sets the keys tokens are signed and verified with.
+func SetKeyring(ring *jwtkeys.Keyring) {
+	keyring = ring
+}
+
+func SetTokenIssuer(issuer, audience string) {
+	tokenIssuer = issuer
+	tokenAudience = audience
+}
+
+func GetJWKS() jwtkeys.JWKSet {
+	if keyring == nil {
+		return jwtkeys.JWKSet{Keys: []jwtkeys.JWK{}}
+	}
+	return keyring.JWKS()
+}
+
+// refreshAudience keeps refresh tokens from being accepted as access tokens.
+func refreshAudience() string {
+	return tokenAudience + ":refresh"
+}
+
+func signToken(claims jwt.Claims) (string, error) {
+	if keyring == nil {
+		return "", fmt.Errorf("no signing keyring configured")
+	}
+	key := keyring.Active()
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+
+var passwordPolicy = passwordpolicy.Default()
 
-func SetJWTSecret(secret string) {
-	jwtSecret = []byte(secret)
+func SetPasswordPolicy(policy passwordpolicy.Policy) {
+	passwordPolicy = policy
 }
 
+// ValidatePassword applies the password policy. The returned error is a
+// *passwordpolicy.Error carrying per-rule feedback.
+func ValidatePassword(password, username, email string) error {
+	return passwordPolicy.Validate(password, username, email)
+}
 
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
@@ -81,48 +136,76 @@ func VerifyPassword(hashedPassword, password string) error {
 }
 
 
-func GenerateAccessToken(userID int, username string, roles []string) (string, error) {
+func GenerateAccessToken(userID int, username string, roles []string, tokenVersion int, mfa bool) (string, error) {
 
 	expirationTime := time.Now().Add(15 * time.Minute)
 	claims := &CustomClaims{
-		UserID:   userID,
-		Username: username,
-		Roles:    roles,
+		UserID:       userID,
+		Username:     username,
+		Roles:        roles,
+		TokenVersion: tokenVersion,
+		MFA:          mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "bookstore-api",
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{tokenAudience},
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
 }
 
 func GenerateRefreshToken(userID int, username string) (string, error) {
 
-	expirationTime := time.Now().Add(7 * 24 * time.Hour)
+	// A random ID keeps two tokens issued in the same second distinct.
+	jti, err := newFamilyID()
+	if err != nil {
+		return "", err
+	}
+
+	expirationTime := time.Now().Add(RefreshTokenTTL)
 	claims := &CustomClaims{
 		UserID:   userID,
 		Username: username,
 		Roles:    []string{},
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "bookstore-api",
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{refreshAudience()},
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims)
+}
+
+// verificationKey picks the keyring key named by the token's kid header.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keyring.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method().Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
 }
 
 func VerifyToken(tokenString string) (*CustomClaims, error) {
 
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	if keyring == nil {
+		return nil, fmt.Errorf("no signing keyring configured")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -137,6 +220,10 @@ func VerifyToken(tokenString string) (*CustomClaims, error) {
 
 func CreateUser(req RegisterRequest) (User, error) {
 
+	if err := ValidatePassword(req.Password, req.Username, req.Email); err != nil {
+		return User{}, err
+	}
+
 	hashedPassword, err := HashPassword(req.Password)
 	if err != nil {
 		return User{}, fmt.Errorf("failed to hash password: %w", err)
@@ -160,11 +247,11 @@ func CreateUser(req RegisterRequest) (User, error) {
 
 	var newUser User
 	err = tx.QueryRow(`
-		INSERT INTO users (username, email, password_hash, is_active)
-		VALUES ($1, $2, $3, TRUE)
-		RETURNING id, username, email, is_active, created_at
+		INSERT INTO users (username, email, password_hash, is_active, email_verified)
+		VALUES ($1, $2, $3, TRUE, FALSE)
+		RETURNING id, username, email, is_active, email_verified, created_at
 	`, req.Username, req.Email, hashedPassword).Scan(
-		&newUser.ID, &newUser.Username, &newUser.Email, &newUser.IsActive, &newUser.CreatedAt,
+		&newUser.ID, &newUser.Username, &newUser.Email, &newUser.IsActive, &newUser.EmailVerified, &newUser.CreatedAt,
 	)
 	if err != nil {
 
@@ -175,25 +262,33 @@ func CreateUser(req RegisterRequest) (User, error) {
 	}
 
 
-	defaultRole := "user"
+	err = assignDefaultRole(tx, newUser.ID)
+	if err != nil {
+		return User{}, err
+	}
 
-	_, err = tx.Exec(`
+	return newUser, nil
+}
+
+const defaultRole = "user"
+
+func assignDefaultRole(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(`
 		INSERT INTO user_roles (user_id, role_id)
 		SELECT $1, id FROM roles WHERE name = $2
-	`, newUser.ID, defaultRole)
+	`, userID, defaultRole)
 
 	if err != nil {
-		return User{}, fmt.Errorf("failed to assign default role: %w", err)
+		return fmt.Errorf("failed to assign default role: %w", err)
 	}
-
-	return newUser, nil
+	return nil
 }
 
 
 func GetUserByUsername(username string) (User, error) {
 
 	var user User
-	query := `SELECT id, username, email, password_hash, is_active, created_at 
+	query := `SELECT id, username, email, password_hash, is_active, email_verified, password_reset_required, created_at 
 			  FROM users WHERE username = $1`
 
 	err := db.QueryRow(query, username).Scan(
@@ -202,6 +297,29 @@ func GetUserByUsername(username string) (User, error) {
 		&user.Email,
 		&user.PasswordHash,
 		&user.IsActive,
+		&user.EmailVerified,
+		&user.PasswordResetRequired,
+		&user.CreatedAt,
+	)
+
+	return user, err
+}
+
+
+func GetUserByID(userID int) (User, error) {
+
+	var user User
+	query := `SELECT id, username, email, password_hash, is_active, email_verified, password_reset_required, created_at
+			  FROM users WHERE id = $1`
+
+	err := db.QueryRow(query, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.IsActive,
+		&user.EmailVerified,
+		&user.PasswordResetRequired,
 		&user.CreatedAt,
 	)
 
@@ -249,24 +367,6 @@ func GetUserRoles(userID int) ([]string, error) {
 }
 
 
-func CheckUserPermission(userID int, permission string) bool {
-
-	query := `
-		SELECT COUNT(*)
-		FROM permissions p
-		JOIN role_permissions rp ON p.id = rp.permission_id
-		JOIN user_roles ur ON rp.role_id = ur.role_id
-		WHERE ur.user_id = $1 AND p.name = $2
-	`
-	var count int
-	err := db.QueryRow(query, userID, permission).Scan(&count)
-	if err != nil {
-		log.Printf("Error checking permission: %v", err)
-		return false
-	}
-	return count > 0
-}
-
 
 func UpdateLastLogin(userID int) error {
 
@@ -276,64 +376,172 @@ func UpdateLastLogin(userID int) error {
 }
 
 
-func StoreRefreshToken(userID int, token string, expiresAt time.Time) error {
-	query := `
-		INSERT INTO refresh_tokens (user_id, token, expires_at)
-		VALUES ($1, $2, $3)
-	`
-	_, err := db.Exec(query, userID, token, expiresAt)
-	return err
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenReuse describes a revoked refresh token that was presented
+// again. The whole family has been revoked by the time it is returned.
+type RefreshTokenReuse struct {
+	UserID   int
+	FamilyID string
 }
 
+func (e *RefreshTokenReuse) Error() string {
+	return ErrRefreshTokenReused.Error()
+}
 
-func RevokeRefreshToken(token string) error {
-	query := `
-		UPDATE refresh_tokens
-		SET revoked_at = NOW()
-		WHERE token = $1 AND revoked_at IS NULL
-	`
-	_, err := db.Exec(query, token)
-	return err
+func (e *RefreshTokenReuse) Unwrap() error {
+	return ErrRefreshTokenReused
+}
+
+func newFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
+// DeviceInfo identifies the client a refresh token was issued to.
+type DeviceInfo struct {
+	UserAgent string
+	IP        string
+}
 
-func IsRefreshTokenValid(token string) (int, bool) {
-	query := `
-		SELECT user_id
-		FROM refresh_tokens
-		WHERE token = $1
-		AND expires_at > NOW()
-		AND revoked_at IS NULL
-	`
-	var userID int
-	err := db.QueryRow(query, token).Scan(&userID)
+// IssueRefreshToken starts a new token family for a fresh login. mfaVerified
+// records whether the login passed a second factor; rotation carries it over.
+func IssueRefreshToken(userID int, username string, device DeviceInfo, mfaVerified bool) (string, error) {
+
+	familyID, err := newFamilyID()
 	if err != nil {
-		return 0, false
+		return "", err
 	}
-	return userID, true
-}
 
+	token, err := GenerateRefreshToken(userID, username)
+	if err != nil {
+		return "", err
+	}
 
-func LogAudit(userID int, action, resource string, resourceID interface{}, details map[string]interface{}, c *gin.Context) {
-	detailsJSON, _ := json.Marshal(details)
-	query := `
-		INSERT INTO audit_logs
-		(user_id, action, resource, resource_id, details, ip_address, user_agent)
+	_, err = db.Exec(`
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, expires_at, mfa_verified)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`
+	`, userID, hashToken(token), familyID, device.UserAgent, device.IP, time.Now().Add(RefreshTokenTTL), mfaVerified)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a valid refresh token for a new one in the same
+// family and revokes the old one. Presenting a token that was already rotated
+// or revoked revokes the entire family and returns a *RefreshTokenReuse.
+// mfaVerified tells whether the session was started with a second factor.
+func RotateRefreshToken(token string, device DeviceInfo) (newToken string, userID int, mfaVerified bool, err error) {
 
-	var resourceIDStr string
-	if resourceID != nil {
-		resourceIDStr = fmt.Sprintf("%v", resourceID)
+	tx, err := db.Begin()
+	if err != nil {
+		return "", 0, false, err
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil && !errors.Is(err, ErrRefreshTokenReused) {
+			tx.Rollback()
+		} else {
+			if commitErr := tx.Commit(); commitErr != nil {
+				err = commitErr
+			}
+		}
+	}()
 
-	db.Exec(query,
-		userID,
-		action,
-		resource,
-		resourceIDStr,
-		detailsJSON,
-		c.ClientIP(),
-		c.GetHeader("User-Agent"),
-	)
+	var tokenID int
+	var familyID string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT id, user_id, family_id, expires_at, revoked_at, mfa_verified
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`, hashToken(token)).Scan(&tokenID, &userID, &familyID, &expiresAt, &revokedAt, &mfaVerified)
+	if err == sql.ErrNoRows {
+		return "", 0, false, ErrInvalidRefreshToken
+	} else if err != nil {
+		return "", 0, false, err
+	}
+
+	if revokedAt.Valid {
+		_, err = tx.Exec(`
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE family_id = $1 AND revoked_at IS NULL
+		`, familyID)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return "", userID, false, &RefreshTokenReuse{UserID: userID, FamilyID: familyID}
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return "", 0, false, ErrInvalidRefreshToken
+	}
+
+	var username string
+	err = tx.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	newToken, err = GenerateRefreshToken(userID, username)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	var newID int
+	err = tx.QueryRow(`
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, created_at, expires_at, mfa_verified)
+		SELECT $1, $2, $3, $4, $5, created_at, $6, mfa_verified FROM refresh_tokens WHERE id = $7
+		RETURNING id
+	`, userID, hashToken(newToken), familyID, device.UserAgent, device.IP,
+		time.Now().Add(RefreshTokenTTL), tokenID).Scan(&newID)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1
+		WHERE id = $2
+	`, newID, tokenID)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	return newToken, userID, mfaVerified, nil
+}
+
+// RevokeRefreshToken revokes the token and everything else in its family, so
+// logging out also ends any copy of the session that was rotated elsewhere.
+// It returns the owner of the session, or 0 when it was already revoked.
+func RevokeRefreshToken(token string) (int, error) {
+	query := `
+		WITH revoked AS (
+			UPDATE refresh_tokens
+			SET revoked_at = NOW()
+			WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+			AND revoked_at IS NULL
+			RETURNING user_id
+		)
+		SELECT user_id FROM revoked LIMIT 1
+	`
+	var userID int
+	err := db.QueryRow(query, hashToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userID, err
 }
+
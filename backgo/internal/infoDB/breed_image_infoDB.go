@@ -0,0 +1,324 @@
+package infoDB
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrBreedImageExists   = errors.New("image is already in the breed's gallery")
+	ErrImageOrderMismatch = errors.New("image_ids must list every image of the breed exactly once")
+)
+
+// BreedImage is one image in a breed's gallery. The primary image is also
+// the breed's image_url.
+type BreedImage struct {
+	ID         int               `json:"id"`
+	BreedID    int               `json:"breed_id"`
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	Width      *int              `json:"width,omitempty"`
+	Height     *int              `json:"height,omitempty"`
+	Caption    string            `json:"caption"`
+	AltText    string            `json:"alt_text"`
+	Credit     string            `json:"credit"`
+	License    string            `json:"license"`
+	SortOrder  int               `json:"sort_order"`
+	IsPrimary  bool              `json:"is_primary"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// BreedImageDetails are the form fields sent with an uploaded image.
+type BreedImageDetails struct {
+	Caption string `form:"caption" binding:"max=500"`
+	AltText string `form:"alt_text" binding:"max=500"`
+	Credit  string `form:"credit" binding:"max=255"`
+	License string `form:"license" binding:"max=100"`
+	Primary bool   `form:"primary"`
+}
+
+// NewBreedImage is an image in the blob store, or hosted elsewhere when Keys
+// is empty, to be added to a gallery.
+type NewBreedImage struct {
+	BreedImageDetails
+	URL        string
+	Keys       []string
+	Thumbnails map[string]string
+	Width      int
+	Height     int
+}
+
+type UpdateBreedImageRequest struct {
+	Caption *string `json:"caption" binding:"omitempty,max=500"`
+	AltText *string `json:"alt_text" binding:"omitempty,max=500"`
+	Credit  *string `json:"credit" binding:"omitempty,max=255"`
+	License *string `json:"license" binding:"omitempty,max=100"`
+}
+
+type ReorderBreedImagesRequest struct {
+	ImageIDs []int `json:"image_ids" binding:"required,min=1,dive,min=1"`
+}
+
+const breedImageColumns = `
+	bi.id, bi.breed_id, bi.url, bi.thumbnails, bi.width, bi.height,
+	bi.caption, bi.alt_text, bi.credit, bi.license, bi.sort_order, bi.is_primary,
+	bi.created_at, bi.updated_at`
+
+func scanBreedImage(scanner interface{ Scan(...interface{}) error }) (BreedImage, error) {
+	var img BreedImage
+	var thumbnailsJSON []byte
+	err := scanner.Scan(&img.ID, &img.BreedID, &img.URL, &thumbnailsJSON, &img.Width, &img.Height,
+		&img.Caption, &img.AltText, &img.Credit, &img.License, &img.SortOrder, &img.IsPrimary,
+		&img.CreatedAt, &img.UpdatedAt)
+	if err != nil {
+		return BreedImage{}, err
+	}
+	_ = json.Unmarshal(thumbnailsJSON, &img.Thumbnails)
+	return img, nil
+}
+
+// GetBreedImages returns the galleries of the given breeds in display order,
+// keyed by breed ID.
+func GetBreedImages(breedIDs []int) (map[int][]BreedImage, error) {
+	galleries := make(map[int][]BreedImage, len(breedIDs))
+	if len(breedIDs) == 0 {
+		return galleries, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT `+breedImageColumns+`
+		FROM breed_images bi
+		WHERE bi.breed_id = ANY($1)
+		ORDER BY bi.breed_id, bi.sort_order, bi.id
+	`, pq.Array(breedIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		img, err := scanBreedImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		galleries[img.BreedID] = append(galleries[img.BreedID], img)
+	}
+	return galleries, rows.Err()
+}
+
+// attachBreedImages fills in the gallery of each cat, with an empty gallery
+// rather than null for breeds without images.
+func attachBreedImages(cats []Cat) error {
+	ids := make([]int, len(cats))
+	for i, cat := range cats {
+		ids[i] = cat.ID
+	}
+	galleries, err := GetBreedImages(ids)
+	if err != nil {
+		return err
+	}
+	for i := range cats {
+		cats[i].Images = galleries[cats[i].ID]
+		if cats[i].Images == nil {
+			cats[i].Images = []BreedImage{}
+		}
+	}
+	return nil
+}
+
+func breedGallery(breedID int) ([]BreedImage, error) {
+	galleries, err := GetBreedImages([]int{breedID})
+	if err != nil {
+		return nil, err
+	}
+	if galleries[breedID] == nil {
+		return []BreedImage{}, nil
+	}
+	return galleries[breedID], nil
+}
+
+// inBreedTx runs fn in a transaction holding a lock on the breed, so gallery
+// changes to one breed never interleave. It returns sql.ErrNoRows if the
+// breed does not exist.
+func inBreedTx(breedID int, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var id int
+	if err = tx.QueryRow(`SELECT id FROM cat_breeds WHERE id = $1 FOR UPDATE`, breedID).Scan(&id); err != nil {
+		return err
+	}
+	return fn(tx)
+}
+
+// insertBreedImage adds img at the end of the gallery. The first image of a
+// breed is always primary.
+func insertBreedImage(tx *sql.Tx, breedID int, createdBy *int, img NewBreedImage) (BreedImage, error) {
+	var hasPrimary bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM breed_images WHERE breed_id = $1 AND is_primary)`, breedID).Scan(&hasPrimary); err != nil {
+		return BreedImage{}, err
+	}
+	primary := img.Primary || !hasPrimary
+	if primary && hasPrimary {
+		if _, err := tx.Exec(`UPDATE breed_images SET is_primary = FALSE WHERE breed_id = $1 AND is_primary`, breedID); err != nil {
+			return BreedImage{}, err
+		}
+	}
+
+	thumbnails := img.Thumbnails
+	if thumbnails == nil {
+		thumbnails = map[string]string{}
+	}
+	thumbnailsJSON, err := json.Marshal(thumbnails)
+	if err != nil {
+		return BreedImage{}, err
+	}
+	keys := img.Keys
+	if keys == nil {
+		keys = []string{}
+	}
+
+	created, err := scanBreedImage(tx.QueryRow(`
+		INSERT INTO breed_images AS bi (
+			breed_id, url, blob_keys, thumbnails, width, height,
+			caption, alt_text, credit, license, sort_order, is_primary, created_by
+		)
+		SELECT $1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), $7, $8, $9, $10,
+			COALESCE((SELECT MAX(sort_order) + 1 FROM breed_images WHERE breed_id = $1), 0), $11, $12
+		ON CONFLICT (breed_id, url) DO NOTHING
+		RETURNING `+breedImageColumns,
+		breedID, img.URL, pq.Array(keys), string(thumbnailsJSON), img.Width, img.Height,
+		img.Caption, img.AltText, img.Credit, img.License, primary, createdBy))
+	if err == sql.ErrNoRows {
+		return BreedImage{}, ErrBreedImageExists
+	}
+	return created, err
+}
+
+// setPrimaryBreedImage makes imageID the breed's only primary image. The old
+// primary is cleared first since the unique index is checked row by row.
+func setPrimaryBreedImage(tx *sql.Tx, breedID, imageID int) error {
+	if _, err := tx.Exec(`UPDATE breed_images SET is_primary = FALSE WHERE breed_id = $1 AND is_primary AND id <> $2`, breedID, imageID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE breed_images SET is_primary = TRUE WHERE breed_id = $1 AND id = $2 AND NOT is_primary`, breedID, imageID)
+	return err
+}
+
+// AddBreedImage adds an image to the end of the breed's gallery. It returns
+// ErrBreedImageExists if the gallery already has an image at that URL.
+func AddBreedImage(breedID, userID int, img NewBreedImage) (BreedImage, error) {
+	var created BreedImage
+	err := inBreedTx(breedID, func(tx *sql.Tx) error {
+		var err error
+		created, err = insertBreedImage(tx, breedID, &userID, img)
+		return err
+	})
+	return created, err
+}
+
+func UpdateBreedImage(breedID, imageID int, req UpdateBreedImageRequest) (BreedImage, error) {
+	return scanBreedImage(db.QueryRow(`
+		UPDATE breed_images bi
+		SET caption = COALESCE($3, caption),
+			alt_text = COALESCE($4, alt_text),
+			credit = COALESCE($5, credit),
+			license = COALESCE($6, license)
+		WHERE bi.breed_id = $1 AND bi.id = $2
+		RETURNING `+breedImageColumns,
+		breedID, imageID, req.Caption, req.AltText, req.Credit, req.License))
+}
+
+// SetPrimaryBreedImage makes the image the breed's primary image and returns
+// the gallery.
+func SetPrimaryBreedImage(breedID, imageID int) ([]BreedImage, error) {
+	err := inBreedTx(breedID, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM breed_images WHERE breed_id = $1 AND id = $2)`, breedID, imageID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return setPrimaryBreedImage(tx, breedID, imageID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return breedGallery(breedID)
+}
+
+// ReorderBreedImages puts the gallery in the order of imageIDs, which must
+// name every image of the breed once, and returns the reordered gallery.
+func ReorderBreedImages(breedID int, imageIDs []int) ([]BreedImage, error) {
+	err := inBreedTx(breedID, func(tx *sql.Tx) error {
+		var current pq.Int64Array
+		if err := tx.QueryRow(`SELECT COALESCE(array_agg(id), '{}') FROM breed_images WHERE breed_id = $1`, breedID).Scan(&current); err != nil {
+			return err
+		}
+		if len(current) != len(imageIDs) {
+			return ErrImageOrderMismatch
+		}
+		listed := make(map[int64]bool, len(imageIDs))
+		for _, id := range imageIDs {
+			listed[int64(id)] = true
+		}
+		for _, id := range current {
+			if !listed[id] {
+				return ErrImageOrderMismatch
+			}
+		}
+
+		_, err := tx.Exec(`
+			UPDATE breed_images bi
+			SET sort_order = o.position - 1
+			FROM unnest($2::int[]) WITH ORDINALITY AS o(id, position)
+			WHERE bi.breed_id = $1 AND bi.id = o.id AND bi.sort_order <> o.position - 1
+		`, breedID, pq.Array(imageIDs))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return breedGallery(breedID)
+}
+
+// DeleteBreedImage removes the image from the gallery and returns its blob
+// keys so the caller can remove them from the blob store. If it was the
+// primary image, the first remaining image takes its place.
+func DeleteBreedImage(breedID, imageID int) ([]string, error) {
+	var keys pq.StringArray
+	err := inBreedTx(breedID, func(tx *sql.Tx) error {
+		var wasPrimary bool
+		err := tx.QueryRow(`
+			DELETE FROM breed_images WHERE breed_id = $1 AND id = $2
+			RETURNING blob_keys, is_primary
+		`, breedID, imageID).Scan(&keys, &wasPrimary)
+		if err != nil || !wasPrimary {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE breed_images SET is_primary = TRUE
+			WHERE id = (SELECT id FROM breed_images WHERE breed_id = $1 ORDER BY sort_order, id LIMIT 1)
+		`, breedID)
+		return err
+	})
+	return keys, err
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/json"
 	"time"
 	"fmt"
+
+	"backgo/internal/moderation"
+
+	"github.com/lib/pq"
 )
 
 
@@ -17,7 +21,9 @@ type Cat struct {
 	Temperament 	string  `json:"temperament"`
 	Care            string  `json:"care"`
 	ImageURL        string  `json:"image_url"`
-	
+	// Thumbnail URLs by width of the primary image, if it was uploaded.
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	Images     []BreedImage      `json:"images"`
 
 	LikeCount       int `json:"like_count"`
 	DislikeCount    int `json:"dislike_count"`
@@ -61,6 +67,8 @@ type Discussion struct {
 	BreedName       string        `json:"breed_name"`
 	UserID          int           `json:"user_id"`
 	Username        string        `json:"username"`
+	DisplayName     string        `json:"display_name"`
+	AvatarURL       string        `json:"avatar_url"`
 	Message         string        `json:"message"`
 	ParentID        *int          `json:"parent_id,omitempty"`
 	
@@ -71,6 +79,8 @@ type Discussion struct {
 	UserReaction    *string       `json:"user_reaction,omitempty"`
 	IsDeleted       bool          `json:"is_deleted"`
 	IsOwner      	bool          `json:"is_owner"`
+	// Only the author sees discussions that are not "visible".
+	ModerationStatus string       `json:"moderation_status"`
 	CreatedAt       time.Time     `json:"created_at"`
 	UpdatedAt       time.Time     `json:"updated_at"`
 	Replies         []Discussion  `json:"replies,omitempty"`
@@ -121,6 +131,7 @@ func CalculateAndSetAverageRatings(breedID int) error {
 			)
 		FROM discussions d
 		WHERE d.breed_id = $1 AND d.parent_id IS NULL AND d.ratings IS NOT NULL AND d.is_deleted = FALSE
+		AND `+discussionVisibleTo("0")+`
 	`, breedID).Scan(&avgRatingsJSON)
 
 	if err != nil {
@@ -132,9 +143,10 @@ func CalculateAndSetAverageRatings(breedID int) error {
 		SET 
 			average_ratings = $1, 
 			discussion_count = (
-				SELECT COUNT(id) 
-				FROM discussions 
-				WHERE breed_id = $2 AND parent_id IS NULL AND is_deleted = FALSE AND ratings IS NOT NULL
+				SELECT COUNT(d.id) 
+				FROM discussions d
+				WHERE d.breed_id = $2 AND d.parent_id IS NULL AND d.is_deleted = FALSE AND d.ratings IS NOT NULL
+				AND `+discussionVisibleTo("0")+`
 			)
 		WHERE id = $2
 	`, avgRatingsJSON, breedID)
@@ -151,10 +163,10 @@ func GetAllCats(currentUserID *int, limit, offset int, search string) ([]Cat, er
 
 	rows, err := db.Query(`
 		SELECT 
-			cb.id, cb.name, cb.origin, cb.history, cb.appearance, cb.temperament, cb.care_instructions, cb.image_url,
+			cb.id, cb.name, cb.origin, cb.history, cb.appearance, cb.temperament, cb.care_instructions, COALESCE(cb.image_url, ''),
 			cb.like_count, cb.dislike_count, cb.discussion_count, cb.view_count,
 			cb.created_at, cb.updated_at, cb.created_by,
-			cb.average_ratings, 
+			cb.average_ratings, cb.image_thumbnails,
 			br.reaction_type as user_reaction
 		FROM cat_breeds cb
 		LEFT JOIN breed_reactions br ON cb.id = br.breed_id AND br.user_id = $1
@@ -173,13 +185,13 @@ func GetAllCats(currentUserID *int, limit, offset int, search string) ([]Cat, er
 		var cat Cat
 		var userReaction sql.NullString
 		var createdBy sql.NullInt64
-		var avgRatingsJSON []byte
+		var avgRatingsJSON, thumbnailsJSON []byte
 		err := rows.Scan(
 			&cat.ID, &cat.Name, &cat.Origin, &cat.History, &cat.Appearance, &cat.Temperament,
 			&cat.Care, &cat.ImageURL,
 			&cat.LikeCount, &cat.DislikeCount, &cat.DiscussionCount, &cat.ViewCount,
 			&cat.CreatedAt, &cat.UpdatedAt, &createdBy,
-			&avgRatingsJSON,
+			&avgRatingsJSON, &thumbnailsJSON,
 			&userReaction,
 		)
 		if err != nil {
@@ -190,6 +202,7 @@ func GetAllCats(currentUserID *int, limit, offset int, search string) ([]Cat, er
 			cat.AverageRatings = make(map[string]float64)
 			_ = json.Unmarshal(avgRatingsJSON, &cat.AverageRatings)
 		}
+		_ = json.Unmarshal(thumbnailsJSON, &cat.Thumbnails)
 
 		if userReaction.Valid {
 			cat.UserReaction = &userReaction.String
@@ -202,6 +215,13 @@ func GetAllCats(currentUserID *int, limit, offset int, search string) ([]Cat, er
 
 		cats = append(cats, cat)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := attachBreedImages(cats); err != nil {
+		return nil, err
+	}
 
 	return cats, nil
 }
@@ -215,14 +235,14 @@ func GetCat(id int, currentUserID *int) (Cat, error) {
 	var cat Cat
 	var userReaction sql.NullString
 	var createdBy sql.NullInt64
-	var avgRatingsJSON []byte
+	var avgRatingsJSON, thumbnailsJSON []byte
 
 	row := db.QueryRow(`
 		SELECT
-			cb.id, cb.name, cb.origin, cb.history, cb.appearance, cb.temperament, cb.care_instructions, cb.image_url,
+			cb.id, cb.name, cb.origin, cb.history, cb.appearance, cb.temperament, cb.care_instructions, COALESCE(cb.image_url, ''),
 			cb.like_count, cb.dislike_count, cb.discussion_count, cb.view_count,
 			cb.created_at, cb.updated_at, cb.created_by,
-			cb.average_ratings,
+			cb.average_ratings, cb.image_thumbnails,
 			br.reaction_type as user_reaction
 		FROM cat_breeds cb
 		LEFT JOIN breed_reactions br ON cb.id = br.breed_id AND br.user_id = $1
@@ -234,7 +254,7 @@ func GetCat(id int, currentUserID *int) (Cat, error) {
 		&cat.Care, &cat.ImageURL,
 		&cat.LikeCount, &cat.DislikeCount, &cat.DiscussionCount, &cat.ViewCount,
 		&cat.CreatedAt, &cat.UpdatedAt, &createdBy,
-		&avgRatingsJSON,
+		&avgRatingsJSON, &thumbnailsJSON,
 		&userReaction,
 	)
 
@@ -246,6 +266,7 @@ func GetCat(id int, currentUserID *int) (Cat, error) {
 		cat.AverageRatings = make(map[string]float64)
 		_ = json.Unmarshal(avgRatingsJSON, &cat.AverageRatings)
 	}
+	_ = json.Unmarshal(thumbnailsJSON, &cat.Thumbnails)
 
 	if userReaction.Valid {
 		cat.UserReaction = &userReaction.String
@@ -256,24 +277,44 @@ func GetCat(id int, currentUserID *int) (Cat, error) {
 		cat.CreatedBy = &cb
 	}
 
+	if cat.Images, err = breedGallery(id); err != nil {
+		return Cat{}, err
+	}
+
 	db.Exec("UPDATE cat_breeds SET view_count = view_count + 1 WHERE id = $1", id)
 
 	return cat, nil
 }
 
-func CreateCat(userID int, req CreateCatRequest) (Cat, error) {
-	var cat Cat
+// CreateCat adds a breed. An image_url becomes the first, primary image of
+// its gallery.
+func CreateCat(userID int, req CreateCatRequest) (cat Cat, err error) {
 	var createdBy sql.NullInt64
 
-	row := db.QueryRow(`
+	tx, err := db.Begin()
+	if err != nil {
+		return Cat{}, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	row := tx.QueryRow(`
 		INSERT INTO cat_breeds (name, origin, history, appearance, temperament, care_instructions, image_url, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, name, origin, history, appearance, temperament, care_instructions, image_url,
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING id, name, origin, history, appearance, temperament, care_instructions, COALESCE(image_url, ''),
 					like_count, dislike_count, discussion_count, view_count,
 					created_at, updated_at, created_by
 	`, req.Name, req.Origin, req.History, req.Appearance, req.Temperament, req.Care, req.ImageURL, userID)
 
-	err := row.Scan(
+	err = row.Scan(
 		&cat.ID, &cat.Name, &cat.Origin, &cat.History, &cat.Appearance, &cat.Temperament,
 		&cat.Care, &cat.ImageURL,
 		&cat.LikeCount, &cat.DislikeCount, &cat.DiscussionCount, &cat.ViewCount,
@@ -289,69 +330,117 @@ func CreateCat(userID int, req CreateCatRequest) (Cat, error) {
 		cat.CreatedBy = &cb
 	}
 
+	cat.Images = []BreedImage{}
+	if req.ImageURL != "" {
+		img, err := insertBreedImage(tx, cat.ID, &userID, NewBreedImage{
+			BreedImageDetails: BreedImageDetails{AltText: req.Name, Primary: true},
+			URL:               req.ImageURL,
+		})
+		if err != nil {
+			return Cat{}, err
+		}
+		cat.Images = append(cat.Images, img)
+	}
+
 	return cat, nil
 }
 
+// UpdateCat changes the breed's details. An image_url becomes the primary
+// image, added to the gallery unless it is already there.
 func UpdateCat(catID int, req UpdateCatRequest) (Cat, error) {
 	var cat Cat
 	var createdBy sql.NullInt64
+	var thumbnailsJSON []byte
 
-	row := db.QueryRow(`
-		UPDATE cat_breeds
-		SET name = COALESCE(NULLIF($1, ''), name),
-			origin = COALESCE(NULLIF($2, ''), origin),
-			history = COALESCE(NULLIF($3, ''), history),
-			appearance = COALESCE(NULLIF($4, ''), appearance),
-			temperament = COALESCE(NULLIF($5, ''), temperament),
-			care_instructions = COALESCE(NULLIF($6, ''), care_instructions),
-			image_url = COALESCE(NULLIF($7, ''), image_url),
-			updated_at = CURRENT_TIMESTAMP
-		WHERE id = $8
-		RETURNING id, name, origin, history, appearance, temperament, care_instructions, image_url,
-					like_count, dislike_count, discussion_count, view_count,
-					created_at, updated_at, created_by
-	`, req.Name, req.Origin, req.History, req.Appearance, req.Temperament, req.Care, req.ImageURL, catID)
-
-	err := row.Scan(
-		&cat.ID, &cat.Name, &cat.Origin, &cat.History, &cat.Appearance, &cat.Temperament,
-		&cat.Care, &cat.ImageURL,
-		&cat.LikeCount, &cat.DislikeCount, &cat.DiscussionCount, &cat.ViewCount,
-		&cat.CreatedAt, &cat.UpdatedAt, &createdBy,
-	)
+	err := inBreedTx(catID, func(tx *sql.Tx) error {
+		if req.ImageURL != "" {
+			var imageID int
+			err := tx.QueryRow(`SELECT id FROM breed_images WHERE breed_id = $1 AND url = $2`, catID, req.ImageURL).Scan(&imageID)
+			if err == sql.ErrNoRows {
+				_, err = insertBreedImage(tx, catID, nil, NewBreedImage{
+					BreedImageDetails: BreedImageDetails{AltText: req.Name, Primary: true},
+					URL:               req.ImageURL,
+				})
+			} else if err == nil {
+				err = setPrimaryBreedImage(tx, catID, imageID)
+			}
+			if err != nil {
+				return err
+			}
+		}
 
+		return tx.QueryRow(`
+			UPDATE cat_breeds
+			SET name = COALESCE(NULLIF($1, ''), name),
+				origin = COALESCE(NULLIF($2, ''), origin),
+				history = COALESCE(NULLIF($3, ''), history),
+				appearance = COALESCE(NULLIF($4, ''), appearance),
+				temperament = COALESCE(NULLIF($5, ''), temperament),
+				care_instructions = COALESCE(NULLIF($6, ''), care_instructions),
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $7
+			RETURNING id, name, origin, history, appearance, temperament, care_instructions, COALESCE(image_url, ''),
+						like_count, dislike_count, discussion_count, view_count,
+						created_at, updated_at, created_by, image_thumbnails
+		`, req.Name, req.Origin, req.History, req.Appearance, req.Temperament, req.Care, catID).Scan(
+			&cat.ID, &cat.Name, &cat.Origin, &cat.History, &cat.Appearance, &cat.Temperament,
+			&cat.Care, &cat.ImageURL,
+			&cat.LikeCount, &cat.DislikeCount, &cat.DiscussionCount, &cat.ViewCount,
+			&cat.CreatedAt, &cat.UpdatedAt, &createdBy, &thumbnailsJSON,
+		)
+	})
 	if err != nil {
 		return Cat{}, err
 	}
+	_ = json.Unmarshal(thumbnailsJSON, &cat.Thumbnails)
 
 	if createdBy.Valid {
 		cb := int(createdBy.Int64)
 		cat.CreatedBy = &cb
 	}
 
+	if cat.Images, err = breedGallery(catID); err != nil {
+		return Cat{}, err
+	}
+
 	return cat, nil
 }
 
-func DeleteCat(catID int) error {
-	result, err := db.Exec(`DELETE FROM cat_breeds WHERE id = $1`, catID)
-	if err != nil {
-		return err
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
-	}
+// DeleteCat deletes the breed with its gallery and returns the blob keys of
+// its uploaded images so the caller can remove them from the blob store.
+func DeleteCat(catID int) ([]string, error) {
+	var id int
+	var keys pq.StringArray
+	err := db.QueryRow(`
+		WITH deleted AS (DELETE FROM cat_breeds WHERE id = $1 RETURNING id)
+		SELECT deleted.id, COALESCE((
+			SELECT array_agg(k) FROM breed_images bi, unnest(bi.blob_keys) k WHERE bi.breed_id = deleted.id
+		), '{}')
+		FROM deleted
+	`, catID).Scan(&id, &keys)
+	return keys, err
+}
 
-	return nil
+func CatExists(catID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM cat_breeds WHERE id = $1)`, catID).Scan(&exists)
+	return exists, err
 }
 
 func ToggleCatReaction(catID, userID int, reactionType string) (ReactionResponse, error) {
 	if reactionType != "like" && reactionType != "dislike" {
 		return ReactionResponse{}, sql.ErrNoRows
 	}
+	if err := checkNotMuted(userID); err != nil {
+		return ReactionResponse{}, err
+	}
+	weight, err := reactionWeight(userID)
+	if err != nil {
+		return ReactionResponse{}, err
+	}
 
 	var existingReaction sql.NullString
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 		SELECT reaction_type 
 		FROM breed_reactions 
 		WHERE breed_id = $1 AND user_id = $2
@@ -376,9 +465,9 @@ func ToggleCatReaction(catID, userID int, reactionType string) (ReactionResponse
 		}
 	} else {
 		_, err = db.Exec(`
-			INSERT INTO breed_reactions (breed_id, user_id, reaction_type) 
-			VALUES ($1, $2, $3)
-		`, catID, userID, reactionType)
+			INSERT INTO breed_reactions (breed_id, user_id, reaction_type, weight) 
+			VALUES ($1, $2, $3, $4)
+		`, catID, userID, reactionType, weight)
 	}
 
 	if err != nil {
@@ -448,15 +537,16 @@ func GetDiscussionReplies(parentID int, currentUserID *int, limit, offset int) (
 
 	rows, err := db.Query(`
 		SELECT 
-			d.id, d.breed_id, d.user_id, u.username, d.parent_id,
+			d.id, d.breed_id, d.user_id, u.username, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), d.parent_id,
 			d.message, d.like_count, d.dislike_count, d.reply_count,
 			d.ratings, d.tags,
-			d.is_deleted, d.created_at, d.updated_at,
+			d.is_deleted, d.created_at, d.updated_at, d.moderation_status,
 			dr.reaction_type as user_reaction
 		FROM discussions d
 		JOIN users u ON d.user_id = u.id
 		LEFT JOIN discussion_reactions dr ON d.id = dr.discussion_id AND dr.user_id = $1
 		WHERE d.parent_id = $2 AND d.is_deleted = FALSE
+		AND `+discussionVisibleTo("$1")+`
 		ORDER BY d.created_at ASC
 		LIMIT $3 OFFSET $4
 	`, userID, parentID, limit, offset)
@@ -476,10 +566,11 @@ func GetDiscussionReplies(parentID int, currentUserID *int, limit, offset int) (
 
 		err := rows.Scan(
 			&discussion.ID, &discussion.BreedID, &discussion.UserID, &discussion.Username,
+			&discussion.DisplayName, &discussion.AvatarURL,
 			&parentIDVal, &discussion.Message, &discussion.LikeCount, &discussion.DislikeCount,
 			&discussion.ReplyCount,
 			&ratingsJSON, &tagsJSON,
-			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt,
+			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt, &discussion.ModerationStatus,
 			&userReaction,
 		)
 		if err != nil {
@@ -524,15 +615,16 @@ func GetCatDiscussions(catID int, currentUserID *int, limit, offset int) ([]Disc
 
 	rows, err := db.Query(`
 		SELECT 
-			d.id, d.breed_id, d.user_id, u.username, d.parent_id,
+			d.id, d.breed_id, d.user_id, u.username, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), d.parent_id,
 			d.message, d.like_count, d.dislike_count, d.reply_count,
 			d.ratings, d.tags,
-			d.is_deleted, d.created_at, d.updated_at,
+			d.is_deleted, d.created_at, d.updated_at, d.moderation_status,
 			dr.reaction_type as user_reaction
 		FROM discussions d
 		JOIN users u ON d.user_id = u.id
 		LEFT JOIN discussion_reactions dr ON d.id = dr.discussion_id AND dr.user_id = $1
 		WHERE d.breed_id = $2 AND d.parent_id IS NULL AND d.is_deleted = FALSE
+		AND `+discussionVisibleTo("$1")+`
 		ORDER BY d.created_at DESC
 		LIMIT $3 OFFSET $4
 	`, userID, catID, limit, offset)
@@ -552,10 +644,11 @@ func GetCatDiscussions(catID int, currentUserID *int, limit, offset int) ([]Disc
 
 		err := rows.Scan(
 			&discussion.ID, &discussion.BreedID, &discussion.UserID, &discussion.Username,
+			&discussion.DisplayName, &discussion.AvatarURL,
 			&parentID, &discussion.Message, &discussion.LikeCount, &discussion.DislikeCount,
 			&discussion.ReplyCount,
 			&ratingsJSON, &tagsJSON,
-			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt,
+			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt, &discussion.ModerationStatus,
 			&userReaction,
 		)
 		if err != nil {
@@ -601,6 +694,9 @@ func GetCatDiscussions(catID int, currentUserID *int, limit, offset int) ([]Disc
 
 func CreateDiscussion(userID int, req CreateDiscussionRequest) (Discussion, error) {
 	fmt.Println("Received review:", req)
+	if err := checkNotMuted(userID); err != nil {
+		return Discussion{}, err
+	}
 	var discussion Discussion
 	var parentID sql.NullInt64
 
@@ -620,6 +716,13 @@ func CreateDiscussion(userID int, req CreateDiscussionRequest) (Discussion, erro
 	}
 
 
+	moderated, err := moderateMessage(moderation.Input{UserID: userID, BreedID: req.BreedID, ParentID: req.ParentID, Message: req.Message})
+	if err != nil {
+		return Discussion{}, err
+	}
+	status, findingsArg := moderationColumns(moderated)
+
+
 	var ratingsArg interface{} = nil
 	if req.Ratings != nil && len(req.Ratings) > 0 {
 		b, _ := json.Marshal(req.Ratings)
@@ -633,23 +736,28 @@ func CreateDiscussion(userID int, req CreateDiscussionRequest) (Discussion, erro
 	}
 
 
+	// The fingerprint is of what the user wrote, so a message posted again
+	// is caught even when the stored copy was masked.
 	row := db.QueryRow(`
-        INSERT INTO discussions (breed_id, user_id, parent_id, message, ratings, tags)
-        VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb)
+        INSERT INTO discussions (breed_id, user_id, parent_id, message, ratings, tags,
+                                 moderation_status, moderation_findings, message_fingerprint)
+        VALUES ($1, $2, $3, $4, $5::jsonb, $6::jsonb, $7, $8::jsonb, NULLIF($9, ''))
         RETURNING id, breed_id, user_id, parent_id, message, 
                   ratings, tags,
-                  like_count, dislike_count, reply_count, is_deleted, created_at, updated_at
-    `, req.BreedID, userID, req.ParentID, req.Message, ratingsArg, tagsArg)
+                  like_count, dislike_count, reply_count, is_deleted, created_at, updated_at, moderation_status
+    `, req.BreedID, userID, req.ParentID, moderated.Message, ratingsArg, tagsArg,
+		status, findingsArg, moderation.Fingerprint(req.Message))
 
 	var ratingsBytes []byte
 	var tagsBytes []byte
 
-	err := row.Scan(
+	err = row.Scan(
 		&discussion.ID, &discussion.BreedID, &discussion.UserID, &parentID,
 		&discussion.Message,
 		&ratingsBytes, &tagsBytes,
 		&discussion.LikeCount, &discussion.DislikeCount,
 		&discussion.ReplyCount, &discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt,
+		&discussion.ModerationStatus,
 	)
 	if err != nil {
 		return Discussion{}, err
@@ -673,10 +781,18 @@ func CreateDiscussion(userID int, req CreateDiscussionRequest) (Discussion, erro
 		discussion.ParentID = &pid
 	}
 
-	_ = db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&discussion.Username)
+	_ = db.QueryRow("SELECT username, COALESCE(display_name, ''), COALESCE(avatar_url, '') FROM users WHERE id = $1", userID).Scan(
+		&discussion.Username, &discussion.DisplayName, &discussion.AvatarURL,
+	)
 	discussion.IsOwner = true
 
 
+	if req.ParentID == nil {
+		if err := indexReviewText(discussion.ID, req.Message); err != nil {
+			return Discussion{}, err
+		}
+	}
+
 	if req.ParentID == nil && len(discussion.Ratings) > 0 {
 		if err := CalculateAndSetAverageRatings(req.BreedID); err != nil {
 			return Discussion{}, err
@@ -688,6 +804,10 @@ func CreateDiscussion(userID int, req CreateDiscussionRequest) (Discussion, erro
 
 
 func UpdateDiscussion(discussionID, userID int, req UpdateDiscussionRequest) (Discussion, error) {
+	if err := checkNotMuted(userID); err != nil {
+		return Discussion{}, err
+	}
+
 	var discussion Discussion
 	var parentID sql.NullInt64
 	var breedID int
@@ -698,6 +818,17 @@ func UpdateDiscussion(discussionID, userID int, req UpdateDiscussionRequest) (Di
 		return Discussion{}, err
 	}
 
+	var parent *int
+	if parentID.Valid {
+		pid := int(parentID.Int64)
+		parent = &pid
+	}
+	moderated, err := moderateMessage(moderation.Input{UserID: userID, BreedID: breedID, DiscussionID: discussionID, ParentID: parent, Message: req.Message})
+	if err != nil {
+		return Discussion{}, err
+	}
+	status, findingsArg := moderationColumns(moderated)
+
 
 	var ratingsArg interface{} = nil
 	if req.Ratings != nil && len(req.Ratings) > 0 {
@@ -711,14 +842,18 @@ func UpdateDiscussion(discussionID, userID int, req UpdateDiscussionRequest) (Di
 		tagsArg = string(b)
 	}
 
+	// A discussion a moderator rejected stays rejected however it is edited.
 	row := db.QueryRow(`
 		UPDATE discussions 
-		SET message = $1, ratings = $2::jsonb, tags = $3::jsonb, updated_at = CURRENT_TIMESTAMP
+		SET message = $1, ratings = $2::jsonb, tags = $3::jsonb, updated_at = CURRENT_TIMESTAMP,
+			moderation_status = CASE WHEN moderation_status = 'rejected' THEN moderation_status ELSE $6 END,
+			moderation_findings = $7::jsonb, message_fingerprint = NULLIF($8, '')
 		WHERE id = $4 AND user_id = $5
 		RETURNING id, breed_id, user_id, parent_id, message, 
 				  ratings, tags,
-				  like_count, dislike_count, reply_count, is_deleted, created_at, updated_at
-	`, req.Message, ratingsArg, tagsArg, discussionID, userID)
+				  like_count, dislike_count, reply_count, is_deleted, created_at, updated_at, moderation_status
+	`, moderated.Message, ratingsArg, tagsArg, discussionID, userID,
+		status, findingsArg, moderation.Fingerprint(req.Message))
 
 	var ratingsBytes []byte
 	var tagsBytes []byte
@@ -729,6 +864,7 @@ func UpdateDiscussion(discussionID, userID int, req UpdateDiscussionRequest) (Di
 		&ratingsBytes, &tagsBytes,
 		&discussion.LikeCount, &discussion.DislikeCount,
 		&discussion.ReplyCount, &discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt,
+		&discussion.ModerationStatus,
 	)
 	if err != nil {
 		return Discussion{}, err
@@ -749,8 +885,16 @@ func UpdateDiscussion(discussionID, userID int, req UpdateDiscussionRequest) (Di
 		discussion.ParentID = &pid
 	}
 
-	_ = db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&discussion.Username)
+	_ = db.QueryRow("SELECT username, COALESCE(display_name, ''), COALESCE(avatar_url, '') FROM users WHERE id = $1", userID).Scan(
+		&discussion.Username, &discussion.DisplayName, &discussion.AvatarURL,
+	)
+
 
+	if !parentID.Valid {
+		if err := indexReviewText(discussionID, req.Message); err != nil {
+			return Discussion{}, err
+		}
+	}
 
 	if !parentID.Valid && len(discussion.Ratings) > 0 {
 		if err := CalculateAndSetAverageRatings(breedID); err != nil {
@@ -817,9 +961,16 @@ func ToggleDiscussionReaction(discussionID, userID int, reactionType string) (Re
 	if reactionType != "like" && reactionType != "dislike" {
 		return ReactionResponse{}, sql.ErrNoRows
 	}
+	if err := checkNotMuted(userID); err != nil {
+		return ReactionResponse{}, err
+	}
+	weight, err := reactionWeight(userID)
+	if err != nil {
+		return ReactionResponse{}, err
+	}
 
 	var existingReaction sql.NullString
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 		SELECT reaction_type 
 		FROM discussion_reactions 
 		WHERE discussion_id = $1 AND user_id = $2
@@ -844,9 +995,9 @@ func ToggleDiscussionReaction(discussionID, userID int, reactionType string) (Re
 		}
 	} else {
 		_, err = db.Exec(`
-			INSERT INTO discussion_reactions (discussion_id, user_id, reaction_type) 
-			VALUES ($1, $2, $3)
-		`, discussionID, userID, reactionType)
+			INSERT INTO discussion_reactions (discussion_id, user_id, reaction_type, weight) 
+			VALUES ($1, $2, $3, $4)
+		`, discussionID, userID, reactionType, weight)
 	}
 
 	if err != nil {
@@ -877,63 +1028,41 @@ func ToggleDiscussionReaction(discussionID, userID int, reactionType string) (Re
 	return response, err
 }
 
-func GetDiscussionsByUserID(userID int) ([]Discussion, error) {
+func GetDiscussionsByUserID(userID int, currentUserID *int, limit, offset int) ([]Discussion, error) {
+	var viewerID int
+	if currentUserID != nil {
+		viewerID = *currentUserID
+	}
+
 	rows, err := db.Query(`
 		SELECT 
-			d.id, d.breed_id, cb.name as breed_name, d.user_id, u.username, d.parent_id,
+			d.id, d.breed_id, cb.name as breed_name, d.user_id, u.username, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), d.parent_id,
 			d.message, d.like_count, d.dislike_count, d.reply_count,
 			d.ratings, d.tags,
-			d.is_deleted, d.created_at, d.updated_at,
-			NULL as user_reaction 
+			d.is_deleted, d.created_at, d.updated_at, d.moderation_status,
+			dr.reaction_type as user_reaction
 		FROM discussions d
 		JOIN users u ON d.user_id = u.id
 		JOIN cat_breeds cb ON d.breed_id = cb.id
+		LEFT JOIN discussion_reactions dr ON d.id = dr.discussion_id AND dr.user_id = $2
 		WHERE d.user_id = $1 AND d.is_deleted = FALSE AND d.parent_id IS NULL 
+		AND `+discussionVisibleTo("$2")+`
 		ORDER BY d.created_at DESC
-	`, userID)
+		LIMIT $3 OFFSET $4
+	`, userID, viewerID, limit, offset)
 
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var discussions []Discussion
-	for rows.Next() {
-		var discussion Discussion
-		var parentID sql.NullInt64
-		var userReaction sql.NullString
-		var ratingsJSON []byte
-		var tagsJSON []byte
-
-		err := rows.Scan(
-			&discussion.ID, &discussion.BreedID, &discussion.BreedName, &discussion.UserID, &discussion.Username,
-			&parentID, &discussion.Message, &discussion.LikeCount, &discussion.DislikeCount,
-			&discussion.ReplyCount,
-			&ratingsJSON, &tagsJSON,
-			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt,
-			&userReaction,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(ratingsJSON) > 0 {
-			discussion.Ratings = make(map[string]int)
-			_ = json.Unmarshal(ratingsJSON, &discussion.Ratings)
-		}
-		if len(tagsJSON) > 0 {
-			var t []string
-			if err := json.Unmarshal(tagsJSON, &t); err == nil {
-				discussion.Tags = t
-			}
-		}
-
-		if parentID.Valid {
-			pid := int(parentID.Int64)
-			discussion.ParentID = &pid
-		}
+	discussions, err := scanUserDiscussions(rows)
+	if err != nil {
+		return nil, err
+	}
 
-		discussions = append(discussions, discussion)
+	for i := range discussions {
+		discussions[i].IsOwner = (currentUserID != nil && discussions[i].UserID == *currentUserID)
 	}
 	return discussions, nil
-}
\ No newline at end of file
+}
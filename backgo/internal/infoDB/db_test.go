@@ -0,0 +1,64 @@
+package infoDB
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// useTestDB points the package at the database in TEST_DATABASE_URL, which
+// must have the schema from catbasedetail/docker/init.sql loaded. Tests that
+// need it are skipped when it is not set.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	d, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	SetDB(d)
+	t.Cleanup(func() {
+		SetDB(previous)
+		d.Close()
+	})
+}
+
+// uniqueName returns a name no other test run has used, for rows that must
+// not collide with existing data.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, time.Now().UnixNano()%1_000_000_000_000)
+}
+
+// createTestUser inserts a user and deletes it, with everything that
+// cascades from it, when the test ends.
+func createTestUser(t *testing.T, email string, emailVerified bool) User {
+	t.Helper()
+	user := User{Username: uniqueName("t_"), Email: email, IsActive: true, EmailVerified: emailVerified}
+	err := db.QueryRow(`
+		INSERT INTO users (username, email, password_hash, is_active, email_verified)
+		VALUES ($1, $2, 'x', TRUE, $3)
+		RETURNING id
+	`, user.Username, email, emailVerified).Scan(&user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { deleteTestUsersByEmail(t, email) })
+	return user
+}
+
+func deleteTestUsersByEmail(t *testing.T, email string) {
+	if _, err := db.Exec(`DELETE FROM users WHERE LOWER(email) = LOWER($1)`, email); err != nil {
+		t.Errorf("cleanup: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package infoDB
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	LoginScopeUsername = "username"
+	LoginScopeIP       = "ip"
+)
+
+// LockoutPolicy describes how failed logins for one key are throttled. Every
+// failure blocks further attempts for BaseDelay doubled per failure (capped at
+// MaxDelay); after MaxFailures the key is locked for LockoutDuration. Failures
+// older than Window are forgotten.
+type LockoutPolicy struct {
+	MaxFailures     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+var (
+	usernameLockout = LockoutPolicy{
+		MaxFailures:     5,
+		BaseDelay:       1 * time.Second,
+		MaxDelay:        1 * time.Minute,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	}
+	// Many users can share one IP, so the IP limit is looser.
+	ipLockout = LockoutPolicy{
+		MaxFailures:     20,
+		BaseDelay:       250 * time.Millisecond,
+		MaxDelay:        30 * time.Second,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	}
+)
+
+// LoginLockoutPolicies returns the policies for usernames and for IPs.
+func LoginLockoutPolicies() (username, ip LockoutPolicy) {
+	return usernameLockout, ipLockout
+}
+
+func SetLoginLockoutPolicies(username, ip LockoutPolicy) {
+	usernameLockout = username
+	ipLockout = ip
+}
+
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	if failures >= p.MaxFailures {
+		return p.LockoutDuration
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+type LoginBlock struct {
+	Scope      string
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+// CheckLoginBlocked reports whether a login for username from ip must be
+// refused without checking the password.
+func CheckLoginBlocked(username, ip string) (*LoginBlock, error) {
+
+	rows, err := db.Query(`
+		SELECT scope, blocked_until, locked
+		FROM login_failures
+		WHERE ((scope = $1 AND key = $2) OR (scope = $3 AND key = $4))
+		AND blocked_until > NOW()
+		ORDER BY blocked_until DESC
+	`, LoginScopeUsername, username, LoginScopeIP, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var block LoginBlock
+	var blockedUntil time.Time
+	if err := rows.Scan(&block.Scope, &blockedUntil, &block.Locked); err != nil {
+		return nil, err
+	}
+	block.RetryAfter = time.Until(blockedUntil)
+	return &block, nil
+}
+
+func recordLoginFailure(scope, key string, policy LockoutPolicy) (bool, error) {
+
+	var failures int
+	err := db.QueryRow(`
+		INSERT INTO login_failures (scope, key, failure_count, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE SET
+			failure_count = CASE
+				WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+				ELSE login_failures.failure_count + 1
+			END,
+			locked = CASE
+				WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $3) THEN FALSE
+				ELSE login_failures.locked
+			END,
+			last_failure_at = NOW()
+		RETURNING failure_count
+	`, scope, key, policy.Window.Seconds()).Scan(&failures)
+	if err != nil {
+		return false, err
+	}
+
+	locked := failures >= policy.MaxFailures
+	_, err = db.Exec(`
+		UPDATE login_failures
+		SET blocked_until = NOW() + make_interval(secs => $1), locked = $2
+		WHERE scope = $3 AND key = $4
+	`, policy.delay(failures).Seconds(), locked, scope, key)
+	return locked, err
+}
+
+// RecordLoginFailure counts a failed login against both the username and the
+// IP and returns the scopes that became locked by this failure. The scopes
+// are counted independently, so a failure on one never skips the other.
+func RecordLoginFailure(username, ip string) ([]string, error) {
+
+	var newlyLocked []string
+
+	usernameLocked, usernameErr := countLoginFailure(LoginScopeUsername, username, usernameLockout)
+	if usernameLocked {
+		newlyLocked = append(newlyLocked, LoginScopeUsername)
+	}
+	ipLocked, ipErr := countLoginFailure(LoginScopeIP, ip, ipLockout)
+	if ipLocked {
+		newlyLocked = append(newlyLocked, LoginScopeIP)
+	}
+
+	return newlyLocked, errors.Join(usernameErr, ipErr)
+}
+
+// countLoginFailure records a failure for one scope and reports whether it
+// locked the key.
+func countLoginFailure(scope, key string, policy LockoutPolicy) (bool, error) {
+
+	wasBlocked, err := isLocked(scope, key)
+	if err != nil {
+		return false, err
+	}
+	locked, err := recordLoginFailure(scope, key, policy)
+	if err != nil {
+		return false, err
+	}
+	return locked && !wasBlocked, nil
+}
+
+func isLocked(scope, key string) (bool, error) {
+
+	var locked bool
+	err := db.QueryRow(`
+		SELECT locked FROM login_failures
+		WHERE scope = $1 AND key = $2 AND blocked_until > NOW()
+	`, scope, key).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return locked, err
+}
+
+// ResetLoginFailures clears the username counter after a successful login.
+// The IP counter is left to expire on its own, or anyone with one valid
+// account could reset it between guesses at other accounts.
+func ResetLoginFailures(username string) error {
+
+	_, err := db.Exec(`
+		DELETE FROM login_failures WHERE scope = $1 AND key = $2
+	`, LoginScopeUsername, username)
+	return err
+}
+
+// UnlockAccount clears the failed login counter of a user and returns the
+// username that was unlocked.
+func UnlockAccount(userID int) (string, error) {
+
+	var username string
+	err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		DELETE FROM login_failures WHERE scope = $1 AND key = $2
+	`, LoginScopeUsername, username)
+	return username, err
+}
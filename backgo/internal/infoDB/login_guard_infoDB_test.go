@@ -0,0 +1,76 @@
+package infoDB
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func loginFailureCount(t *testing.T, scope, key string) int {
+	t.Helper()
+	var n int
+	db.QueryRow(`SELECT COALESCE(MAX(failure_count), 0) FROM login_failures WHERE scope = $1 AND key = $2`, scope, key).Scan(&n)
+	return n
+}
+
+func TestLoginFailureScopes(t *testing.T) {
+	useTestDB(t)
+
+	tests := []struct {
+		name     string
+		username string
+		// reset logs in successfully after the failure.
+		reset        bool
+		wantUsername int
+		wantIP       int
+	}{
+		{name: "counts both scopes", username: uniqueName("u"), wantUsername: 1, wantIP: 1},
+		{name: "username too long for the key column", username: strings.Repeat("x", 101), wantUsername: 0, wantIP: 1},
+		{name: "success keeps the IP count", username: uniqueName("u"), reset: true, wantUsername: 0, wantIP: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip := uniqueName("203.0.113.")
+			t.Cleanup(func() {
+				db.Exec(`DELETE FROM login_failures WHERE key IN ($1, $2)`, tt.username, ip)
+			})
+
+			_, err := RecordLoginFailure(tt.username, ip)
+			if tt.wantUsername > 0 && err != nil {
+				t.Fatal(err)
+			}
+			if tt.reset {
+				if err := ResetLoginFailures(tt.username); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := loginFailureCount(t, LoginScopeUsername, tt.username); got != tt.wantUsername {
+				t.Errorf("username failures = %d, want %d", got, tt.wantUsername)
+			}
+			if got := loginFailureCount(t, LoginScopeIP, ip); got != tt.wantIP {
+				t.Errorf("IP failures = %d, want %d", got, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	p := LockoutPolicy{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second, LockoutDuration: time.Hour}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{5, time.Hour},
+		{9, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
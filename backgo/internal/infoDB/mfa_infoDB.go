@@ -0,0 +1,435 @@
+package infoDB
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backgo/internal/totp"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	MFAChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	// One step either side tolerates about 30 seconds of clock drift.
+	totpSkew = 1
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled      = errors.New("two-factor authentication enrollment has not been started")
+	ErrInvalidMFACode      = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor challenge")
+)
+
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	ConfirmedAt            *time.Time `json:"confirmed_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+	Required               bool       `json:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type DisableMFARequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// MFAChallengeClaims identify a user who passed the first login step and
+// still owes a second factor. They carry their own audience so they can never
+// be used as access or refresh tokens.
+type MFAChallengeClaims struct {
+	UserID       int    `json:"user_id"`
+	TokenVersion int    `json:"ver"`
+	Method       string `json:"method"`
+	jwt.RegisteredClaims
+}
+
+func mfaAudience() string {
+	return tokenAudience + ":mfa"
+}
+
+// Roles listed here may not use the API's admin routes without a second
+// factor. Empty means MFA is optional for everybody.
+var mfaRequiredRoles = map[string]bool{}
+
+func SetMFARequiredRoles(roles []string) {
+	required := map[string]bool{}
+	for _, role := range roles {
+		if role = strings.TrimSpace(role); role != "" {
+			required[role] = true
+		}
+	}
+	mfaRequiredRoles = required
+}
+
+// MFARequiredForRoles reports whether any of roles must use two-factor
+// authentication.
+func MFARequiredForRoles(roles []string) bool {
+	for _, role := range roles {
+		if mfaRequiredRoles[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateMFAChallengeToken is handed out instead of a session when the
+// password was right but a TOTP code is still needed. method is the first
+// factor, e.g. "password" or "oidc:google".
+func GenerateMFAChallengeToken(userID, tokenVersion int, method string) (string, error) {
+
+	jti, err := newFamilyID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &MFAChallengeClaims{
+		UserID:       userID,
+		TokenVersion: tokenVersion,
+		Method:       method,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(MFAChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{mfaAudience()},
+		},
+	}
+	return signToken(claims)
+}
+
+func VerifyMFAChallengeToken(tokenString string) (*MFAChallengeClaims, error) {
+
+	if keyring == nil {
+		return nil, fmt.Errorf("no signing keyring configured")
+	}
+
+	var claims MFAChallengeClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, verificationKey,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(mfaAudience()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	// A password change or forced logout in the meantime voids the challenge.
+	state, err := GetAuthState(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !state.IsActive || state.TokenVersion != claims.TokenVersion {
+		return nil, ErrInvalidMFAChallenge
+	}
+	return &claims, nil
+}
+
+func IsMFAEnabled(userID int) (bool, error) {
+
+	var enabled bool
+	err := db.QueryRow(`SELECT enabled FROM user_mfa WHERE user_id = $1`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+func GetMFAStatus(userID int) (MFAStatus, error) {
+
+	var status MFAStatus
+	var confirmedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT enabled, confirmed_at FROM user_mfa WHERE user_id = $1
+	`, userID).Scan(&status.Enabled, &confirmedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return MFAStatus{}, err
+	}
+	if !status.Enabled {
+		return status, nil
+	}
+	if confirmedAt.Valid {
+		status.ConfirmedAt = &confirmedAt.Time
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&status.RecoveryCodesRemaining)
+	return status, err
+}
+
+// BeginMFAEnrollment stores a new pending secret for the user. Calling it
+// again before confirming replaces the secret.
+func BeginMFAEnrollment(userID int) (string, error) {
+
+	enabled, err := IsMFAEnabled(userID)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_mfa (user_id, totp_secret, enabled)
+		VALUES ($1, $2, FALSE)
+		ON CONFLICT (user_id) DO UPDATE SET
+			totp_secret = EXCLUDED.totp_secret,
+			last_used_step = 0,
+			created_at = NOW()
+		WHERE user_mfa.enabled = FALSE
+	`, userID, secret)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmMFAEnrollment enables MFA once the user proves their authenticator
+// produces valid codes, and returns a fresh set of recovery codes.
+func ConfirmMFAEnrollment(userID int, code string) (codes []string, err error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var secret string
+	var enabled bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, enabled FROM user_mfa WHERE user_id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_mfa SET enabled = TRUE, confirmed_at = NOW(), last_used_step = $1
+		WHERE user_id = $2
+	`, step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return replaceRecoveryCodes(tx, userID)
+}
+
+// VerifyMFACode checks a TOTP code for a user with MFA enabled. Each code is
+// accepted only once, so an observed code cannot be replayed.
+func VerifyMFACode(userID int, code string) error {
+
+	var secret string
+	var lastStep int64
+	err := db.QueryRow(`
+		SELECT totp_secret, last_used_step FROM user_mfa
+		WHERE user_id = $1 AND enabled = TRUE
+	`, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrInvalidMFACode
+	} else if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok || step <= lastStep {
+		return ErrInvalidMFACode
+	}
+
+	// The condition on last_used_step makes two concurrent uses of one code
+	// race for a single row update.
+	result, err := db.Exec(`
+		UPDATE user_mfa SET last_used_step = $1
+		WHERE user_id = $2 AND last_used_step < $1
+	`, step, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// UseRecoveryCode burns one of the user's recovery codes.
+func UseRecoveryCode(userID int, code string) error {
+
+	result, err := db.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashToken(normaliseRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates every existing recovery code and
+// returns a new set.
+func RegenerateRecoveryCodes(userID int) (codes []string, err error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	return replaceRecoveryCodes(tx, userID)
+}
+
+// DisableMFA removes the user's second factor. Sessions that passed MFA lose
+// that status and issued access tokens stop working, so nothing keeps the
+// mfa claim once the factor behind it is gone.
+func DisableMFA(userID int) error {
+
+	err := func() (err error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			} else if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+
+		if _, err = tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE refresh_tokens SET mfa_verified = FALSE
+			WHERE user_id = $1 AND mfa_verified AND revoked_at IS NULL
+		`, userID)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	return BumpTokenVersion(userID)
+}
+
+// MarkSessionMFAVerified upgrades the session of refreshToken after the user
+// completed MFA enrollment, so refreshed access tokens keep the mfa claim.
+func MarkSessionMFAVerified(refreshToken string) error {
+
+	_, err := db.Exec(`
+		UPDATE refresh_tokens SET mfa_verified = TRUE
+		WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+		AND revoked_at IS NULL
+	`, hashToken(refreshToken))
+	return err
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+
+	_, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hashToken(normaliseRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns a code like "k3fq7-pz2ma", easy to copy by hand.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
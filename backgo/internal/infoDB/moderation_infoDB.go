@@ -0,0 +1,458 @@
+package infoDB
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"backgo/internal/moderation"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ModerationVisible  = "visible"
+	ModerationFlagged  = "flagged"
+	ModerationHeld     = "held"
+	ModerationRejected = "rejected"
+)
+
+var (
+	ErrModerationWordExists  = errors.New("word is already on the list")
+	ErrInvalidModerationWord = errors.New("word must contain at least one letter")
+	ErrDiscussionNotQueued   = errors.New("discussion is not waiting for review")
+)
+
+type ModerationWord struct {
+	ID        int       `json:"id"`
+	Word      string    `json:"word"`
+	Language  string    `json:"language"`
+	Action    string    `json:"action"`
+	CreatedBy *int      `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateModerationWordRequest struct {
+	Word     string `json:"word" binding:"required,max=100"`
+	Language string `json:"language" binding:"required,oneof=en th"`
+	Action   string `json:"action" binding:"required,oneof=mask flag hold reject"`
+}
+
+// ModeratedDiscussion is a discussion as moderators see it: whatever its
+// status, with what the content filter found and the discussions its
+// findings refer to.
+type ModeratedDiscussion struct {
+	ID               int                  `json:"id"`
+	BreedID          int                  `json:"breed_id"`
+	BreedName        string               `json:"breed_name"`
+	UserID           int                  `json:"user_id"`
+	Username         string               `json:"username"`
+	ParentID         *int                 `json:"parent_id,omitempty"`
+	Message          string               `json:"message"`
+	ModerationStatus string               `json:"moderation_status"`
+	IsDeleted        bool                 `json:"is_deleted"`
+	Findings         []moderation.Finding `json:"findings"`
+	Matches          []DiscussionMatch    `json:"matches"`
+	CreatedAt        time.Time            `json:"created_at"`
+	UpdatedAt        time.Time            `json:"updated_at"`
+}
+
+// DiscussionMatch is a discussion a finding refers to. Link is the
+// moderator view of it.
+type DiscussionMatch struct {
+	DiscussionID     int       `json:"discussion_id"`
+	Similarity       float64   `json:"similarity"`
+	BreedID          int       `json:"breed_id"`
+	BreedName        string    `json:"breed_name"`
+	UserID           int       `json:"user_id"`
+	Username         string    `json:"username"`
+	Message          string    `json:"message"`
+	ModerationStatus string    `json:"moderation_status"`
+	IsDeleted        bool      `json:"is_deleted"`
+	CreatedAt        time.Time `json:"created_at"`
+	Link             string    `json:"link"`
+}
+
+var moderationPolicy = moderation.Default()
+
+func SetModerationPolicy(policy moderation.Policy) {
+	moderationPolicy = policy
+}
+
+// The word list is read on every post, so it is cached. Changes made
+// through this process take effect at once; other instances catch up within
+// moderationWordsTTL.
+var (
+	moderationWordsTTL     = 30 * time.Second
+	moderationWordsMu      sync.Mutex
+	moderationWords        *moderation.WordList
+	moderationWordsExpires time.Time
+)
+
+func moderationWordList() (*moderation.WordList, error) {
+	moderationWordsMu.Lock()
+	defer moderationWordsMu.Unlock()
+	if moderationWords != nil && time.Now().Before(moderationWordsExpires) {
+		return moderationWords, nil
+	}
+
+	rows, err := db.Query(`SELECT word, action FROM moderation_words`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var words []moderation.Word
+	for rows.Next() {
+		var word, action string
+		if err := rows.Scan(&word, &action); err != nil {
+			return nil, err
+		}
+		parsed, err := moderation.ParseAction(action)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, moderation.Word{Text: word, Action: parsed})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	moderationWords = moderation.NewWordList(words)
+	moderationWordsExpires = time.Now().Add(moderationWordsTTL)
+	return moderationWords, nil
+}
+
+func invalidateModerationWords() {
+	moderationWordsMu.Lock()
+	moderationWords = nil
+	moderationWordsMu.Unlock()
+}
+
+// duplicateCheck objects to a user posting the same message again, on any
+// breed, within the window.
+type duplicateCheck struct {
+	window time.Duration
+	action moderation.Action
+}
+
+func (c duplicateCheck) Check(in moderation.Input) ([]moderation.Finding, error) {
+	fingerprint := moderation.Fingerprint(in.Message)
+	if c.action == moderation.Allow || fingerprint == "" {
+		return nil, nil
+	}
+
+	var otherID, otherBreedID int
+	err := db.QueryRow(`
+		SELECT id, breed_id FROM discussions
+		WHERE user_id = $1 AND message_fingerprint = $2 AND id <> $3
+		AND is_deleted = FALSE AND created_at > NOW() - $4 * INTERVAL '1 second'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, in.UserID, fingerprint, in.DiscussionID, int64(c.window/time.Second)).Scan(&otherID, &otherBreedID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	detail := fmt.Sprintf("same message as your discussion %d", otherID)
+	if otherBreedID != in.BreedID {
+		detail = fmt.Sprintf("same message as your discussion %d on another breed", otherID)
+	}
+	return []moderation.Finding{{
+		Check:   moderation.CheckDuplicate,
+		Detail:  detail,
+		Related: []moderation.Related{{DiscussionID: otherID, Similarity: 1}},
+		Action:  c.action,
+	}}, nil
+}
+
+// moderateMessage runs a message through the content filter. A rejected
+// message comes back as a *moderation.Error.
+func moderateMessage(in moderation.Input) (moderation.Result, error) {
+	words, err := moderationWordList()
+	if err != nil {
+		return moderation.Result{}, err
+	}
+
+	pipeline := moderation.Pipeline{
+		words,
+		moderation.LinkCheck{Max: moderationPolicy.MaxLinks, Action: moderationPolicy.LinkAction},
+		moderation.RepeatCheck{Action: moderationPolicy.RepeatAction},
+		duplicateCheck{window: moderationPolicy.DuplicateWindow, action: moderationPolicy.DuplicateAction},
+		similarityCheck{policy: moderationPolicy},
+	}
+	result, err := pipeline.Run(in)
+	if err != nil {
+		return moderation.Result{}, err
+	}
+	if result.Action == moderation.Reject {
+		return result, &moderation.Error{Findings: result.Findings}
+	}
+	return result, nil
+}
+
+// moderationColumns returns the status and findings to store for a filter
+// result.
+func moderationColumns(result moderation.Result) (string, interface{}) {
+	status := ModerationVisible
+	switch result.Action {
+	case moderation.Flag:
+		status = ModerationFlagged
+	case moderation.Hold:
+		status = ModerationHeld
+	}
+	if len(result.Findings) == 0 {
+		return status, nil
+	}
+	findings, _ := json.Marshal(result.Findings)
+	return status, string(findings)
+}
+
+// discussionVisibleTo hides discussions, aliased d, that are held, rejected
+// or by a shadow-banned user from everyone except their author. viewer is
+// the placeholder of the viewing user's ID, 0 for anonymous viewers.
+func discussionVisibleTo(viewer string) string {
+	return `(d.user_id = ` + viewer + ` OR (d.moderation_status IN ('` + ModerationVisible + `', '` + ModerationFlagged + `') AND NOT ` + shadowBanned + `))`
+}
+
+func ListModerationWords(language string) ([]ModerationWord, error) {
+	rows, err := db.Query(`
+		SELECT id, word, language, action, created_by, created_at
+		FROM moderation_words
+		WHERE ($1 = '' OR language = $1)
+		ORDER BY language, word
+	`, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := []ModerationWord{}
+	for rows.Next() {
+		var w ModerationWord
+		if err := rows.Scan(&w.ID, &w.Word, &w.Language, &w.Action, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+	return words, rows.Err()
+}
+
+func AddModerationWord(createdBy int, req CreateModerationWordRequest) (ModerationWord, error) {
+	if !moderation.ValidWord(req.Word) {
+		return ModerationWord{}, ErrInvalidModerationWord
+	}
+
+	w := ModerationWord{}
+	err := db.QueryRow(`
+		INSERT INTO moderation_words (word, language, action, created_by)
+		VALUES (LOWER($1), $2, $3, $4)
+		ON CONFLICT (word) DO NOTHING
+		RETURNING id, word, language, action, created_by, created_at
+	`, req.Word, req.Language, req.Action, createdBy).Scan(&w.ID, &w.Word, &w.Language, &w.Action, &w.CreatedBy, &w.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ModerationWord{}, ErrModerationWordExists
+	} else if err != nil {
+		return ModerationWord{}, err
+	}
+
+	invalidateModerationWords()
+	return w, nil
+}
+
+func DeleteModerationWord(wordID int) (ModerationWord, error) {
+	w := ModerationWord{}
+	err := db.QueryRow(`
+		DELETE FROM moderation_words WHERE id = $1
+		RETURNING id, word, language, action, created_by, created_at
+	`, wordID).Scan(&w.ID, &w.Word, &w.Language, &w.Action, &w.CreatedBy, &w.CreatedAt)
+	if err != nil {
+		return ModerationWord{}, err
+	}
+
+	invalidateModerationWords()
+	return w, nil
+}
+
+const moderatedDiscussionColumns = `d.id, d.breed_id, cb.name, d.user_id, u.username, d.parent_id, d.message,
+	d.moderation_status, d.is_deleted, d.moderation_findings, d.created_at, d.updated_at`
+
+func scanModeratedDiscussion(scanner interface{ Scan(...interface{}) error }) (ModeratedDiscussion, error) {
+	var m ModeratedDiscussion
+	var findings []byte
+	err := scanner.Scan(&m.ID, &m.BreedID, &m.BreedName, &m.UserID, &m.Username, &m.ParentID, &m.Message,
+		&m.ModerationStatus, &m.IsDeleted, &findings, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return ModeratedDiscussion{}, err
+	}
+	m.Findings = []moderation.Finding{}
+	if len(findings) > 0 {
+		_ = json.Unmarshal(findings, &m.Findings)
+	}
+	m.Matches = []DiscussionMatch{}
+	return m, nil
+}
+
+// attachMatches looks up the discussions the findings refer to, in one
+// query for the whole page.
+func attachMatches(discussions []ModeratedDiscussion) error {
+	var ids []int64
+	for _, d := range discussions {
+		for _, f := range d.Findings {
+			for _, r := range f.Related {
+				ids = append(ids, int64(r.DiscussionID))
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT d.id, d.breed_id, cb.name, d.user_id, u.username, d.message,
+			d.moderation_status, d.is_deleted, d.created_at
+		FROM discussions d
+		JOIN users u ON u.id = d.user_id
+		JOIN cat_breeds cb ON cb.id = d.breed_id
+		WHERE d.id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := map[int]DiscussionMatch{}
+	for rows.Next() {
+		var m DiscussionMatch
+		err := rows.Scan(&m.DiscussionID, &m.BreedID, &m.BreedName, &m.UserID, &m.Username, &m.Message,
+			&m.ModerationStatus, &m.IsDeleted, &m.CreatedAt)
+		if err != nil {
+			return err
+		}
+		m.Link = fmt.Sprintf("/api/admin/moderation/discussions/%d", m.DiscussionID)
+		found[m.DiscussionID] = m
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Discussions deleted for good since the finding are left out.
+	for i := range discussions {
+		seen := map[int]bool{}
+		for _, f := range discussions[i].Findings {
+			for _, r := range f.Related {
+				m, ok := found[r.DiscussionID]
+				if !ok || seen[r.DiscussionID] {
+					continue
+				}
+				seen[r.DiscussionID] = true
+				m.Similarity = r.Similarity
+				discussions[i].Matches = append(discussions[i].Matches, m)
+			}
+		}
+	}
+	return nil
+}
+
+// GetModerationQueue returns held and flagged discussions, oldest first, and
+// how many are waiting. status narrows the queue to one of the two.
+func GetModerationQueue(status string, limit, offset int) ([]ModeratedDiscussion, int, error) {
+	where := `
+		WHERE d.is_deleted = FALSE
+		AND (($1 = '' AND d.moderation_status IN ('held', 'flagged')) OR d.moderation_status = $1)
+	`
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM discussions d `+where, status).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT `+moderatedDiscussionColumns+`
+		FROM discussions d
+		JOIN users u ON u.id = d.user_id
+		JOIN cat_breeds cb ON cb.id = d.breed_id
+		`+where+`
+		ORDER BY d.created_at ASC, d.id ASC
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	queue := []ModeratedDiscussion{}
+	for rows.Next() {
+		m, err := scanModeratedDiscussion(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		queue = append(queue, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return queue, total, attachMatches(queue)
+}
+
+func GetModeratedDiscussion(discussionID int) (ModeratedDiscussion, error) {
+	m, err := scanModeratedDiscussion(db.QueryRow(`
+		SELECT `+moderatedDiscussionColumns+`
+		FROM discussions d
+		JOIN users u ON u.id = d.user_id
+		JOIN cat_breeds cb ON cb.id = d.breed_id
+		WHERE d.id = $1
+	`, discussionID))
+	if err != nil {
+		return ModeratedDiscussion{}, err
+	}
+
+	page := []ModeratedDiscussion{m}
+	if err := attachMatches(page); err != nil {
+		return ModeratedDiscussion{}, err
+	}
+	return page[0], nil
+}
+
+// ReviewQueuedDiscussion publishes a held or flagged discussion, or rejects
+// it. A rejected discussion stays visible to its author only.
+func ReviewQueuedDiscussion(discussionID int, approve bool) error {
+	status := ModerationRejected
+	if approve {
+		status = ModerationVisible
+	}
+
+	var breedID int
+	var parentID sql.NullInt64
+	err := db.QueryRow(`
+		UPDATE discussions SET moderation_status = $2
+		WHERE id = $1 AND moderation_status IN ('held', 'flagged') AND is_deleted = FALSE
+		RETURNING breed_id, parent_id
+	`, discussionID, status).Scan(&breedID, &parentID)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM discussions WHERE id = $1)`, discussionID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrDiscussionNotQueued
+		}
+		return sql.ErrNoRows
+	} else if err != nil {
+		return err
+	}
+
+	// Held reviews start counting towards the ratings when approved and
+	// flagged ones stop when rejected.
+	if !parentID.Valid {
+		return CalculateAndSetAverageRatings(breedID)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package infoDB
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+	// ErrOIDCEmailConflict means a local account already uses the email but
+	// either the provider did not vouch for it or the account never proved it
+	// owns it, so linking could hand the account to someone else.
+	ErrOIDCEmailConflict = errors.New("an account with this email already exists")
+	ErrOIDCNoEmail       = errors.New("identity provider did not return an email address")
+)
+
+// OIDCIdentity is what the login flow learned from a verified ID token.
+type OIDCIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+func CreateOIDCState(provider, state, nonce, codeVerifier string, ttl time.Duration) error {
+
+	_, err := db.Exec(`
+		INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, hashToken(state), provider, nonce, codeVerifier, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	_, _ = db.Exec(`DELETE FROM oidc_login_states WHERE expires_at < NOW()`)
+	return nil
+}
+
+// ConsumeOIDCState returns the nonce and PKCE verifier stored for state. Each
+// state can be used once.
+func ConsumeOIDCState(provider, state string) (nonce, codeVerifier string, err error) {
+
+	err = db.QueryRow(`
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING nonce, code_verifier
+	`, hashToken(state), provider).Scan(&nonce, &codeVerifier)
+	if err == sql.ErrNoRows {
+		return "", "", ErrInvalidOIDCState
+	}
+	return nonce, codeVerifier, err
+}
+
+// ResolveOIDCUser finds the local account for an external identity. Known
+// identities map straight to their user; otherwise an account with the same
+// email is linked if both the provider and the account have verified it, or a
+// new account is created with the default role. created reports whether a new
+// account was made.
+func ResolveOIDCUser(identity OIDCIdentity) (user User, created bool, err error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return User{}, false, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	selectUser := `SELECT id, username, email, password_hash, is_active, email_verified, password_reset_required, created_at FROM users `
+	scanUser := func(row *sql.Row) error {
+		return row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash,
+			&user.IsActive, &user.EmailVerified, &user.PasswordResetRequired, &user.CreatedAt)
+	}
+
+	err = scanUser(tx.QueryRow(selectUser+`
+		WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)
+	`, identity.Provider, identity.Subject))
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE user_identities SET last_login_at = NOW(), email = $3
+			WHERE provider = $1 AND subject = $2
+		`, identity.Provider, identity.Subject, identity.Email)
+		return user, false, err
+	} else if err != sql.ErrNoRows {
+		return User{}, false, err
+	}
+
+	if identity.Email == "" {
+		return User{}, false, ErrOIDCNoEmail
+	}
+
+	err = scanUser(tx.QueryRow(selectUser+`WHERE LOWER(email) = LOWER($1)`, identity.Email))
+	switch {
+	case err == nil:
+		// Anyone can register a local account with someone else's address
+		// and a password of their choosing. Linking it to the address's real
+		// owner would hand them an account the registrant can still log in to.
+		if !identity.EmailVerified || !user.EmailVerified {
+			return User{}, false, ErrOIDCEmailConflict
+		}
+	case err == sql.ErrNoRows:
+		user, err = createOIDCUser(tx, identity)
+		if err != nil {
+			return User{}, false, err
+		}
+		created = true
+	default:
+		return User{}, false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`, user.ID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return User{}, false, err
+	}
+
+	return user, created, nil
+}
+
+func createOIDCUser(tx *sql.Tx, identity OIDCIdentity) (User, error) {
+
+	// The account has no usable password until the user sets one through the
+	// reset flow.
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return User{}, err
+	}
+	hashedPassword, err := HashPassword(secret[:32])
+	if err != nil {
+		return User{}, err
+	}
+
+	base := usernameBase(identity)
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			n, _ := rand.Int(rand.Reader, big.NewInt(10000))
+			username = fmt.Sprintf("%s_%04d", base, n.Int64())
+		}
+
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, username).Scan(&taken)
+		if err != nil {
+			return User{}, err
+		}
+		if taken {
+			continue
+		}
+
+		var newUser User
+		err = tx.QueryRow(`
+			INSERT INTO users (username, email, password_hash, is_active, email_verified, email_verified_at)
+			VALUES ($1, $2, $3, TRUE, $4, CASE WHEN $4 THEN NOW() END)
+			RETURNING id, username, email, is_active, email_verified, created_at
+		`, username, identity.Email, hashedPassword, identity.EmailVerified).Scan(
+			&newUser.ID, &newUser.Username, &newUser.Email, &newUser.IsActive, &newUser.EmailVerified, &newUser.CreatedAt,
+		)
+		if err != nil {
+			return User{}, err
+		}
+
+		if err := assignDefaultRole(tx, newUser.ID); err != nil {
+			return User{}, err
+		}
+		return newUser, nil
+	}
+
+	return User{}, fmt.Errorf("could not find a free username for %q", base)
+}
+
+// usernameBase turns the provider's preferred username or the email local part
+// into something that satisfies the users table constraints.
+func usernameBase(identity OIDCIdentity) string {
+	candidate := identity.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(candidate) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+
+	name := b.String()
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	for len(name) < 3 {
+		name += "_"
+	}
+	return name
+}
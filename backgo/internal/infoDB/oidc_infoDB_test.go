@@ -0,0 +1,129 @@
+package infoDB
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOIDCStateSingleUse(t *testing.T) {
+	useTestDB(t)
+	state := uniqueName("state-")
+
+	if err := CreateOIDCState("mock", state, "nonce", "verifier", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ConsumeOIDCState("other", state); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("consume with another provider: err = %v, want ErrInvalidOIDCState", err)
+	}
+	nonce, verifier, err := ConsumeOIDCState("mock", state)
+	if err != nil || nonce != "nonce" || verifier != "verifier" {
+		t.Fatalf("ConsumeOIDCState() = %q, %q, %v", nonce, verifier, err)
+	}
+	if _, _, err := ConsumeOIDCState("mock", state); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("second consume: err = %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestResolveOIDCUser(t *testing.T) {
+	useTestDB(t)
+
+	tests := []struct {
+		name string
+		// localVerified is whether a local account with the email exists
+		// and has verified it; nil means there is no such account.
+		localVerified    *bool
+		providerVerified bool
+		wantErr          error
+		wantCreated      bool
+	}{
+		{name: "links account with verified email", localVerified: ptr(true), providerVerified: true},
+		{name: "refuses account with unverified email", localVerified: ptr(false), providerVerified: true, wantErr: ErrOIDCEmailConflict},
+		{name: "refuses email the provider did not verify", localVerified: ptr(true), providerVerified: false, wantErr: ErrOIDCEmailConflict},
+		{name: "creates new user", providerVerified: true, wantCreated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := uniqueName("oidc") + "@example.com"
+			var local User
+			if tt.localVerified != nil {
+				local = createTestUser(t, email, *tt.localVerified)
+			} else {
+				t.Cleanup(func() { deleteTestUsersByEmail(t, email) })
+			}
+
+			identity := OIDCIdentity{
+				Provider:          "mock",
+				Subject:           uniqueName("sub-"),
+				Email:             email,
+				EmailVerified:     tt.providerVerified,
+				PreferredUsername: "Whiskers!",
+			}
+			user, created, err := ResolveOIDCUser(identity)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				var linked int
+				db.QueryRow(`SELECT COUNT(*) FROM user_identities WHERE subject = $1`, identity.Subject).Scan(&linked)
+				if linked != 0 {
+					t.Fatal("identity was linked despite the refusal")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created != tt.wantCreated {
+				t.Fatalf("created = %v, want %v", created, tt.wantCreated)
+			}
+			if !created && user.ID != local.ID {
+				t.Fatalf("linked user %d, want %d", user.ID, local.ID)
+			}
+
+			var roles []string
+			rows, err := db.Query(`SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = $1`, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var role string
+				rows.Scan(&role)
+				roles = append(roles, role)
+			}
+			rows.Close()
+			if created && (len(roles) != 1 || roles[0] != defaultRole) {
+				t.Fatalf("new user roles = %v, want [%s]", roles, defaultRole)
+			}
+
+			// The identity now resolves straight to the same user.
+			again, createdAgain, err := ResolveOIDCUser(identity)
+			if err != nil || createdAgain || again.ID != user.ID {
+				t.Fatalf("second login = %d, %v, %v; want %d", again.ID, createdAgain, err, user.ID)
+			}
+		})
+	}
+}
+
+func TestUsernameBase(t *testing.T) {
+	tests := []struct {
+		identity OIDCIdentity
+		want     string
+	}{
+		{OIDCIdentity{PreferredUsername: "Whiskers!"}, "whiskers"},
+		{OIDCIdentity{Email: "Tom.Cat@example.com"}, "tom.cat"},
+		{OIDCIdentity{PreferredUsername: "猫", Email: "x@example.com"}, "___"},
+		{OIDCIdentity{Email: "ab@example.com"}, "ab_"},
+		{OIDCIdentity{PreferredUsername: "a123456789012345678901234567890123456789012345"}, "a123456789012345678901234567890123456789"},
+	}
+	for _, tt := range tests {
+		if got := usernameBase(tt.identity); got != tt.want {
+			t.Errorf("usernameBase(%+v) = %q, want %q", tt.identity, got, tt.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,469 @@
+package infoDB
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permissions checked by the API. Routes ask for a permission rather than a
+// role, so new roles can be put together without touching the code.
+const (
+	PermBreedsWrite         = "breeds:write"
+	PermDiscussionsWrite    = "discussions:write"
+	PermDiscussionsModerate = "discussions:moderate"
+	PermReactionsWrite      = "reactions:write"
+	PermProfileWrite        = "profile:write"
+	PermUsersManage         = "users:manage"
+	PermRolesManage         = "roles:manage"
+	PermAuditRead           = "audit:read"
+	PermUsersSanction       = "users:sanction"
+)
+
+var (
+	ErrRoleExists        = errors.New("role already exists")
+	ErrUnknownRole       = errors.New("role not found")
+	ErrUnknownPermission = errors.New("permission not found")
+	ErrInvalidRoleName   = errors.New("role names may only contain lowercase letters, digits, '-' and '_'")
+	ErrLastAdmin         = errors.New("cannot remove the admin role from the last admin")
+	ErrAdminPermission   = errors.New("the admin role must keep the roles:manage and users:manage permissions")
+)
+
+// adminPermissions are the permissions the admin role cannot lose, since
+// without them nobody could hand out roles or permissions again.
+var adminPermissions = map[string]bool{
+	PermRolesManage: true,
+	PermUsersManage: true,
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9_-]{2,50}$`)
+
+type Permission struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Permissions []string  `json:"permissions"`
+	UserCount   int       `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Permissions []string `json:"permissions"`
+}
+
+type RolePermissionsRequest struct {
+	Permissions []string `json:"permissions" binding:"required,min=1"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type cachedPermissions struct {
+	permissions map[string]bool
+	expires     time.Time
+}
+
+func (c cachedPermissions) expiry() time.Time { return c.expires }
+
+// Permission lookups happen on almost every authenticated request, so they
+// are cached like the auth state. Changes made through this process
+// invalidate the cache at once; other instances catch up within
+// permissionTTL.
+var (
+	permissionTTL   = 10 * time.Second
+	permissionMu    sync.RWMutex
+	permissionCache = map[int]cachedPermissions{}
+)
+
+func SetPermissionCacheTTL(ttl time.Duration) {
+	permissionTTL = ttl
+}
+
+// GetUserPermissions returns the set of permissions granted to the user
+// through any of their roles.
+func GetUserPermissions(userID int) (map[string]bool, error) {
+
+	permissionMu.RLock()
+	cached, ok := permissionCache[userID]
+	permissionMu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.permissions, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT p.name
+		FROM permissions p
+		JOIN role_permissions rp ON p.id = rp.permission_id
+		JOIN user_roles ur ON rp.role_id = ur.role_id
+		WHERE ur.user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	permissionMu.Lock()
+	evictCached(permissionCache, now)
+	permissionCache[userID] = cachedPermissions{permissions: permissions, expires: now.Add(permissionTTL)}
+	permissionMu.Unlock()
+
+	return permissions, nil
+}
+
+func InvalidatePermissions(userID int) {
+	permissionMu.Lock()
+	delete(permissionCache, userID)
+	permissionMu.Unlock()
+}
+
+// InvalidateAllPermissions is used when a role's permissions change, since
+// that affects every holder of the role.
+func InvalidateAllPermissions() {
+	permissionMu.Lock()
+	permissionCache = map[int]cachedPermissions{}
+	permissionMu.Unlock()
+}
+
+func ListPermissions() ([]Permission, error) {
+
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(description, '') FROM permissions ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+func ListRoles() ([]Role, error) {
+
+	rows, err := db.Query(`
+		SELECT r.id, r.name, r.created_at,
+			COALESCE(ARRAY(
+				SELECT p.name FROM permissions p
+				JOIN role_permissions rp ON p.id = rp.permission_id
+				WHERE rp.role_id = r.id
+				ORDER BY p.name
+			), '{}'),
+			(SELECT COUNT(*) FROM user_roles ur WHERE ur.role_id = r.id)
+		FROM roles r
+		ORDER BY r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var permissions pq.StringArray
+		if err := rows.Scan(&role.ID, &role.Name, &role.CreatedAt, &permissions, &role.UserCount); err != nil {
+			return nil, err
+		}
+		role.Permissions = []string(permissions)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func getRole(roleID int) (Role, error) {
+	roles, err := ListRoles()
+	if err != nil {
+		return Role{}, err
+	}
+	for _, role := range roles {
+		if role.ID == roleID {
+			return role, nil
+		}
+	}
+	return Role{}, ErrUnknownRole
+}
+
+// permissionIDs resolves permission names, failing on the first unknown one.
+func permissionIDs(tx *sql.Tx, names []string) ([]int, error) {
+	ids := make([]int, 0, len(names))
+	for _, name := range names {
+		var id int
+		err := tx.QueryRow(`SELECT id FROM permissions WHERE name = $1`, strings.TrimSpace(name)).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownPermission
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func CreateRole(req CreateRoleRequest) (role Role, err error) {
+
+	name := strings.TrimSpace(req.Name)
+	if !roleNamePattern.MatchString(name) {
+		return Role{}, ErrInvalidRoleName
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Role{}, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var roleID int
+	err = tx.QueryRow(`
+		INSERT INTO roles (name) VALUES ($1)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id
+	`, name).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return Role{}, ErrRoleExists
+	} else if err != nil {
+		return Role{}, err
+	}
+
+	ids, err := permissionIDs(tx, req.Permissions)
+	if err != nil {
+		return Role{}, err
+	}
+	for _, id := range ids {
+		_, err = tx.Exec(`
+			INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, roleID, id)
+		if err != nil {
+			return Role{}, err
+		}
+	}
+
+	permissions := req.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+	return Role{ID: roleID, Name: name, Permissions: permissions, CreatedAt: time.Now()}, nil
+}
+
+// GrantRolePermissions adds permissions to a role and returns the role as it
+// is afterwards.
+func GrantRolePermissions(roleID int, names []string) (Role, error) {
+
+	err := func() (err error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			} else if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM roles WHERE id = $1)`, roleID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownRole
+		}
+
+		ids, err := permissionIDs(tx, names)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			_, err = tx.Exec(`
+				INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`, roleID, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return Role{}, err
+	}
+
+	InvalidateAllPermissions()
+	return getRole(roleID)
+}
+
+func RevokeRolePermission(roleID int, name string) error {
+
+	if adminPermissions[name] {
+		var roleName string
+		err := db.QueryRow(`SELECT name FROM roles WHERE id = $1`, roleID).Scan(&roleName)
+		if err != nil {
+			return err
+		}
+		if roleName == "admin" {
+			return ErrAdminPermission
+		}
+	}
+
+	result, err := db.Exec(`
+		DELETE FROM role_permissions
+		WHERE role_id = $1 AND permission_id = (SELECT id FROM permissions WHERE name = $2)
+	`, roleID, name)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	InvalidateAllPermissions()
+	return nil
+}
+
+// AssignRole gives the user a role. Roles are embedded in access tokens, so
+// the token version is bumped and the user has to refresh.
+func AssignRole(userID int, roleName string) error {
+
+	var roleID int
+	err := db.QueryRow(`SELECT id FROM roles WHERE name = $1`, roleName).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return ErrUnknownRole
+	} else if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return roleAssignmentChanged(userID)
+}
+
+func RemoveRole(userID int, roleName string) error {
+
+	err := func() (err error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			} else if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+
+		if roleName == "admin" {
+			wasAdmin, err := isActiveAdmin(tx, userID)
+			if err != nil {
+				return err
+			}
+			if wasAdmin {
+				admins, err := lockAdminCount(tx)
+				if err != nil {
+					return err
+				}
+				if admins <= 1 {
+					return ErrLastAdmin
+				}
+			}
+		}
+
+		result, err := tx.Exec(`
+			DELETE FROM user_roles
+			WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)
+		`, userID, roleName)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	return roleAssignmentChanged(userID)
+}
+
+func roleAssignmentChanged(userID int) error {
+	InvalidatePermissions(userID)
+	return BumpTokenVersion(userID)
+}
+
+// CheckUserPermission reports whether the user holds permission through any
+// of their roles.
+func CheckUserPermission(userID int, permission string) bool {
+
+	permissions, err := GetUserPermissions(userID)
+	if err != nil {
+		log.Printf("Error checking permission: %v", err)
+		return false
+	}
+	return permissions[permission]
+}
@@ -0,0 +1,77 @@
+package infoDB
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRemoveAdminRoleGuard(t *testing.T) {
+	useTestDB(t)
+
+	t.Run("user without the role", func(t *testing.T) {
+		user := createTestUser(t, uniqueName("plain")+"@example.com", true)
+		if err := RemoveRole(user.ID, "admin"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("RemoveRole() error = %v, want sql.ErrNoRows", err)
+		}
+	})
+
+	// A deactivated admin administers nothing, so the last-admin guard must
+	// not stop any of the ways to finish retiring one.
+	retire := []struct {
+		name   string
+		retire func(userID int) error
+	}{
+		{"RemoveRole", func(userID int) error { return RemoveRole(userID, "admin") }},
+		{"SetUserActive", func(userID int) error { return SetUserActive(userID, false) }},
+		{"SetUserRoles", func(userID int) error { return SetUserRoles(userID, []string{defaultRole}) }},
+	}
+	for _, tt := range retire {
+		t.Run("deactivated admin/"+tt.name, func(t *testing.T) {
+			user := createTestUser(t, uniqueName("former")+"@example.com", true)
+			if err := AssignRole(user.ID, "admin"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(`UPDATE users SET is_active = FALSE WHERE id = $1`, user.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.retire(user.ID); err != nil {
+				t.Fatalf("%s() error = %v", tt.name, err)
+			}
+		})
+	}
+}
+
+func TestRevokeAdminPermissionGuard(t *testing.T) {
+	useTestDB(t)
+
+	var adminRoleID int
+	if err := db.QueryRow(`SELECT id FROM roles WHERE name = 'admin'`).Scan(&adminRoleID); err != nil {
+		t.Fatal(err)
+	}
+	role, err := CreateRole(CreateRoleRequest{Name: uniqueName("r"), Permissions: []string{PermRolesManage, PermUsersManage}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM roles WHERE id = $1`, role.ID) })
+
+	tests := []struct {
+		name       string
+		roleID     int
+		permission string
+		want       error
+	}{
+		{"roles:manage from admin", adminRoleID, PermRolesManage, ErrAdminPermission},
+		{"users:manage from admin", adminRoleID, PermUsersManage, ErrAdminPermission},
+		{"roles:manage from another role", role.ID, PermRolesManage, nil},
+		{"users:manage from another role", role.ID, PermUsersManage, nil},
+		{"unknown role", -1, PermRolesManage, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RevokeRolePermission(tt.roleID, tt.permission); !errors.Is(err, tt.want) {
+				t.Fatalf("RevokeRolePermission() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
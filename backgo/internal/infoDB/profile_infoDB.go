@@ -0,0 +1,160 @@
+package infoDB
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownBreed = errors.New("breed does not exist")
+
+type OwnedCat struct {
+	ID        int    `json:"id"`
+	BreedID   int    `json:"breed_id"`
+	BreedName string `json:"breed_name"`
+	Name      string `json:"name"`
+}
+
+type OwnedCatRequest struct {
+	BreedID int    `json:"breed_id" binding:"required"`
+	Name    string `json:"name" binding:"required,min=1,max=100"`
+}
+
+type Profile struct {
+	DisplayName string     `json:"display_name"`
+	Bio         string     `json:"bio"`
+	Location    string     `json:"location"`
+	AvatarURL   string     `json:"avatar_url"`
+	Cats        []OwnedCat `json:"cats"`
+}
+
+// UpdateProfileRequest only touches the fields that are present in the body.
+type UpdateProfileRequest struct {
+	DisplayName *string            `json:"display_name" binding:"omitempty,max=100"`
+	Bio         *string            `json:"bio" binding:"omitempty,max=1000"`
+	Location    *string            `json:"location" binding:"omitempty,max=100"`
+	Cats        *[]OwnedCatRequest `json:"cats" binding:"omitempty,max=20,dive"`
+}
+
+func GetProfile(userID int) (Profile, error) {
+
+	var profile Profile
+	err := db.QueryRow(`
+		SELECT COALESCE(display_name, ''), COALESCE(bio, ''), COALESCE(location, ''), COALESCE(avatar_url, '')
+		FROM users WHERE id = $1
+	`, userID).Scan(&profile.DisplayName, &profile.Bio, &profile.Location, &profile.AvatarURL)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	cats, err := GetOwnedCats(userID)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.Cats = cats
+
+	return profile, nil
+}
+
+func GetOwnedCats(userID int) ([]OwnedCat, error) {
+
+	rows, err := db.Query(`
+		SELECT uc.id, uc.breed_id, cb.name, uc.name
+		FROM user_cats uc
+		JOIN cat_breeds cb ON cb.id = uc.breed_id
+		WHERE uc.user_id = $1
+		ORDER BY uc.id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cats := []OwnedCat{}
+	for rows.Next() {
+		var cat OwnedCat
+		if err := rows.Scan(&cat.ID, &cat.BreedID, &cat.BreedName, &cat.Name); err != nil {
+			return nil, err
+		}
+		cats = append(cats, cat)
+	}
+	return cats, nil
+}
+
+func UpdateProfile(userID int, req UpdateProfileRequest) (err error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET display_name = CASE WHEN $1 THEN NULLIF($2, '') ELSE display_name END,
+			bio = CASE WHEN $3 THEN NULLIF($4, '') ELSE bio END,
+			location = CASE WHEN $5 THEN NULLIF($6, '') ELSE location END
+		WHERE id = $7
+	`,
+		req.DisplayName != nil, trimmedOrEmpty(req.DisplayName),
+		req.Bio != nil, trimmedOrEmpty(req.Bio),
+		req.Location != nil, trimmedOrEmpty(req.Location),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if req.Cats != nil {
+		_, err = tx.Exec(`DELETE FROM user_cats WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		for _, cat := range *req.Cats {
+			_, err = tx.Exec(`
+				INSERT INTO user_cats (user_id, breed_id, name)
+				VALUES ($1, $2, $3)
+			`, userID, cat.BreedID, strings.TrimSpace(cat.Name))
+			if err != nil {
+				if strings.Contains(err.Error(), "foreign key") {
+					err = fmt.Errorf("%w: %d", ErrUnknownBreed, cat.BreedID)
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetAvatar records the new avatar blob and returns the key of the one it
+// replaced so the caller can remove it from the blob store.
+func SetAvatar(userID int, key, avatarURL string) (string, error) {
+
+	var previous sql.NullString
+	err := db.QueryRow(`
+		UPDATE users u SET avatar_key = $1, avatar_url = $2
+		FROM (SELECT avatar_key FROM users WHERE id = $3) old
+		WHERE u.id = $3
+		RETURNING old.avatar_key
+	`, key, avatarURL, userID).Scan(&previous)
+	return previous.String, err
+}
+
+func trimmedOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return strings.TrimSpace(*s)
+}
@@ -0,0 +1,308 @@
+package infoDB
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sanction types. A mute blocks posting and reacting, a shadow-ban hides the
+// user's discussions from everyone but the user, and zeroing the reaction
+// weight leaves the user's reactions in place without counting them.
+const (
+	SanctionMute               = "mute"
+	SanctionShadowBan          = "shadow_ban"
+	SanctionZeroReactionWeight = "zero_reaction_weight"
+)
+
+var (
+	ErrUserMuted           = errors.New("you are muted and cannot post or react")
+	ErrSanctionNotActive   = errors.New("sanction has already ended")
+	ErrSanctionUnknownType = errors.New("unknown sanction type")
+)
+
+// MutedError is returned when a muted user tries to post or react. Until is
+// nil for a mute without an end.
+type MutedError struct {
+	Until *time.Time
+}
+
+func (e *MutedError) Error() string {
+	if e.Until == nil {
+		return ErrUserMuted.Error()
+	}
+	return fmt.Sprintf("you are muted until %s and cannot post or react", e.Until.UTC().Format(time.RFC3339))
+}
+
+func (e *MutedError) Is(target error) bool {
+	return target == ErrUserMuted
+}
+
+type Sanction struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Type      string     `json:"type"`
+	Reason    string     `json:"reason"`
+	CreatedBy *int       `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	LiftedBy  *int       `json:"lifted_by,omitempty"`
+	Active    bool       `json:"active"`
+}
+
+// CreateSanctionRequest leaves DurationMinutes out for a sanction that lasts
+// until it is lifted.
+type CreateSanctionRequest struct {
+	Type            string `json:"type" binding:"required,oneof=mute shadow_ban zero_reaction_weight"`
+	Reason          string `json:"reason" binding:"required,max=500"`
+	DurationMinutes *int   `json:"duration_minutes" binding:"omitempty,min=1,max=525600"`
+}
+
+// activeSanction is the condition for a user_sanctions row aliased s being in
+// force. Expired rows count as ended even before ExpireSanctions gets to them.
+const activeSanction = `s.ended_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > NOW())`
+
+// shadowBanned holds for discussions, aliased d, whose author is
+// shadow-banned.
+const shadowBanned = `EXISTS (
+	SELECT 1 FROM user_sanctions s
+	WHERE s.user_id = d.user_id AND s.type = '` + SanctionShadowBan + `' AND ` + activeSanction + `
+)`
+
+// checkNotMuted returns a *MutedError while the user has a mute in force.
+func checkNotMuted(userID int) error {
+	var muted bool
+	var until sql.NullTime
+	err := db.QueryRow(`
+		SELECT COUNT(*) > 0,
+			CASE WHEN BOOL_OR(s.expires_at IS NULL) THEN NULL ELSE MAX(s.expires_at) END
+		FROM user_sanctions s
+		WHERE s.user_id = $1 AND s.type = $2 AND `+activeSanction,
+		userID, SanctionMute).Scan(&muted, &until)
+	if err != nil {
+		return err
+	}
+	if !muted {
+		return nil
+	}
+	if until.Valid {
+		return &MutedError{Until: &until.Time}
+	}
+	return &MutedError{}
+}
+
+// reactionWeight is the weight a new reaction by the user counts for.
+func reactionWeight(userID int) (int, error) {
+	var zeroed bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM user_sanctions s
+			WHERE s.user_id = $1 AND s.type = $2 AND `+activeSanction+`
+		)
+	`, userID, SanctionZeroReactionWeight).Scan(&zeroed)
+	if err != nil {
+		return 0, err
+	}
+	if zeroed {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// syncReactionWeights brings the weight of the user's existing reactions in
+// line with their sanctions. The reaction count triggers move the like and
+// dislike totals along with it.
+func syncReactionWeights(userID int) error {
+	weight, err := reactionWeight(userID)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"breed_reactions", "discussion_reactions"} {
+		_, err := db.Exec(`UPDATE `+table+` SET weight = $2 WHERE user_id = $1 AND weight <> $2`, userID, weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recalculateUserBreedRatings refreshes the average ratings of every breed
+// the user reviewed, since shadow-banned reviews do not count towards them.
+func recalculateUserBreedRatings(userID int) error {
+	rows, err := db.Query(`
+		SELECT DISTINCT breed_id FROM discussions
+		WHERE user_id = $1 AND parent_id IS NULL AND ratings IS NOT NULL AND is_deleted = FALSE
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var breedIDs []int
+	for rows.Next() {
+		var breedID int
+		if err := rows.Scan(&breedID); err != nil {
+			rows.Close()
+			return err
+		}
+		breedIDs = append(breedIDs, breedID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, breedID := range breedIDs {
+		if err := CalculateAndSetAverageRatings(breedID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sanctionChanged applies the side effects of a sanction starting or ending.
+func sanctionChanged(userID int, sanctionType string) error {
+	switch sanctionType {
+	case SanctionZeroReactionWeight:
+		return syncReactionWeights(userID)
+	case SanctionShadowBan:
+		return recalculateUserBreedRatings(userID)
+	}
+	return nil
+}
+
+const sanctionColumns = `s.id, s.user_id, s.type, s.reason, s.created_by, s.created_at,
+	s.expires_at, s.ended_at, s.lifted_by, (` + activeSanction + `)`
+
+func scanSanction(scanner interface{ Scan(...interface{}) error }) (Sanction, error) {
+	var s Sanction
+	err := scanner.Scan(&s.ID, &s.UserID, &s.Type, &s.Reason, &s.CreatedBy, &s.CreatedAt,
+		&s.ExpiresAt, &s.EndedAt, &s.LiftedBy, &s.Active)
+	return s, err
+}
+
+func CreateSanction(userID, createdBy int, req CreateSanctionRequest) (Sanction, error) {
+	switch req.Type {
+	case SanctionMute, SanctionShadowBan, SanctionZeroReactionWeight:
+	default:
+		return Sanction{}, ErrSanctionUnknownType
+	}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		return Sanction{}, err
+	}
+	if !exists {
+		return Sanction{}, sql.ErrNoRows
+	}
+
+	var expiresAt *time.Time
+	if req.DurationMinutes != nil {
+		t := time.Now().Add(time.Duration(*req.DurationMinutes) * time.Minute)
+		expiresAt = &t
+	}
+
+	sanction, err := scanSanction(db.QueryRow(`
+		INSERT INTO user_sanctions AS s (user_id, type, reason, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+sanctionColumns,
+		userID, req.Type, req.Reason, createdBy, expiresAt))
+	if err != nil {
+		return Sanction{}, err
+	}
+
+	return sanction, sanctionChanged(userID, sanction.Type)
+}
+
+func GetSanction(sanctionID int) (Sanction, error) {
+	return scanSanction(db.QueryRow(`
+		SELECT `+sanctionColumns+`
+		FROM user_sanctions s
+		WHERE s.id = $1
+	`, sanctionID))
+}
+
+// LiftSanction ends a sanction early.
+func LiftSanction(sanctionID, liftedBy int) (Sanction, error) {
+	sanction, err := scanSanction(db.QueryRow(`
+		UPDATE user_sanctions s SET ended_at = NOW(), lifted_by = $2
+		WHERE s.id = $1 AND `+activeSanction+`
+		RETURNING `+sanctionColumns,
+		sanctionID, liftedBy))
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_sanctions WHERE id = $1)`, sanctionID).Scan(&exists); err != nil {
+			return Sanction{}, err
+		}
+		if exists {
+			return Sanction{}, ErrSanctionNotActive
+		}
+		return Sanction{}, sql.ErrNoRows
+	} else if err != nil {
+		return Sanction{}, err
+	}
+
+	return sanction, sanctionChanged(sanction.UserID, sanction.Type)
+}
+
+// GetUserSanctions returns the user's sanction history, newest first.
+func GetUserSanctions(userID int) ([]Sanction, error) {
+	rows, err := db.Query(`
+		SELECT `+sanctionColumns+`
+		FROM user_sanctions s
+		WHERE s.user_id = $1
+		ORDER BY s.created_at DESC, s.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sanctions := []Sanction{}
+	for rows.Next() {
+		sanction, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, sanction)
+	}
+	return sanctions, rows.Err()
+}
+
+// ExpireSanctions closes sanctions whose time has run out and undoes their
+// effects. Expired sanctions stop applying on their own; this only settles
+// the state kept outside the sanctions table.
+func ExpireSanctions() (int, error) {
+	rows, err := db.Query(`
+		UPDATE user_sanctions SET ended_at = expires_at
+		WHERE ended_at IS NULL AND expires_at <= NOW()
+		RETURNING user_id, type
+	`)
+	if err != nil {
+		return 0, err
+	}
+	type expired struct {
+		userID       int
+		sanctionType string
+	}
+	var ended []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.userID, &e.sanctionType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ended = append(ended, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range ended {
+		if err := sanctionChanged(e.userID, e.sanctionType); err != nil {
+			return len(ended), err
+		}
+	}
+	return len(ended), nil
+}
@@ -0,0 +1,87 @@
+package infoDB
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session is one logged-in device, i.e. the live token of a refresh token
+// family.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func GetActiveSessions(userID int) ([]Session, error) {
+
+	rows, err := db.Query(`
+		SELECT family_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+			created_at, last_used_at, expires_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// GetSessionID returns the session a refresh token belongs to.
+func GetSessionID(refreshToken string) (string, error) {
+
+	var familyID string
+	err := db.QueryRow(`
+		SELECT family_id FROM refresh_tokens WHERE token_hash = $1
+	`, hashToken(refreshToken)).Scan(&familyID)
+	return familyID, err
+}
+
+func RevokeSession(userID int, sessionID string) error {
+
+	result, err := db.Exec(`
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SweepRefreshTokens deletes expired tokens and tokens revoked longer than
+// retention ago, returning how many rows were removed.
+func SweepRefreshTokens(retention time.Duration) (int64, error) {
+
+	result, err := db.Exec(`
+		DELETE FROM refresh_tokens
+		WHERE expires_at < NOW()
+		OR revoked_at < NOW() - make_interval(secs => $1)
+	`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,171 @@
+package infoDB
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"backgo/internal/moderation"
+
+	"github.com/lib/pq"
+)
+
+// maxSimilarCandidates bounds how many band matches are compared in full.
+// Reviews pasted hundreds of times still turn up well within it.
+const maxSimilarCandidates = 200
+
+// maxRelatedPerFinding bounds how many matching reviews a finding lists.
+const maxRelatedPerFinding = 10
+
+type similarReview struct {
+	id         int
+	userID     int
+	similarity float64
+}
+
+// similarReviews returns recent reviews whose text is at least minSimilarity
+// similar to sig, most similar first.
+func similarReviews(sig moderation.Signature, excludeID int, window time.Duration, minSimilarity float64) ([]similarReview, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.user_id, d.minhash
+		FROM discussions d
+		WHERE d.id IN (
+			SELECT b.discussion_id
+			FROM discussion_minhash_bands b
+			JOIN unnest($1::bigint[]) WITH ORDINALITY AS q(hash, band)
+				ON b.band = q.band AND b.hash = q.hash
+		)
+		AND d.id <> $2 AND d.parent_id IS NULL AND d.is_deleted = FALSE
+		AND d.moderation_status <> 'rejected'
+		AND d.created_at > NOW() - $3 * INTERVAL '1 second'
+		ORDER BY d.created_at DESC
+		LIMIT $4
+	`, pq.Array(sig.Bands()), excludeID, int64(window/time.Second), maxSimilarCandidates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []similarReview
+	for rows.Next() {
+		var r similarReview
+		var values pq.Int64Array
+		if err := rows.Scan(&r.id, &r.userID, &values); err != nil {
+			return nil, err
+		}
+		other, ok := moderation.SignatureFromInt64s(values)
+		if !ok {
+			continue
+		}
+		if r.similarity = sig.Similarity(other); r.similarity >= minSimilarity {
+			similar = append(similar, r)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].similarity > similar[j].similarity })
+	return similar, nil
+}
+
+// similarityCheck objects to a review that is nearly the same as a recent
+// review by the same user or by someone else, with separate thresholds and
+// actions for the two.
+type similarityCheck struct {
+	policy moderation.Policy
+}
+
+func (c similarityCheck) Check(in moderation.Input) ([]moderation.Finding, error) {
+	p := c.policy
+	if in.ParentID != nil || (p.SameUserAction == moderation.Allow && p.CrossUserAction == moderation.Allow) {
+		return nil, nil
+	}
+	sig, ok := moderation.MinHash(in.Message)
+	if !ok {
+		return nil, nil
+	}
+
+	threshold := p.SameUserSimilarity
+	if p.CrossUserAction != moderation.Allow && p.CrossUserSimilarity < threshold {
+		threshold = p.CrossUserSimilarity
+	}
+	similar, err := similarReviews(sig, in.DiscussionID, p.SimilarityWindow, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	var own, others []moderation.Related
+	for _, r := range similar {
+		related := moderation.Related{DiscussionID: r.id, Similarity: r.similarity}
+		switch {
+		case r.userID == in.UserID:
+			if p.SameUserAction != moderation.Allow && r.similarity >= p.SameUserSimilarity && len(own) < maxRelatedPerFinding {
+				own = append(own, related)
+			}
+		case p.CrossUserAction != moderation.Allow && r.similarity >= p.CrossUserSimilarity && len(others) < maxRelatedPerFinding:
+			others = append(others, related)
+		}
+	}
+
+	var findings []moderation.Finding
+	if len(own) > 0 {
+		findings = append(findings, moderation.Finding{
+			Check:   moderation.CheckSimilar,
+			Detail:  fmt.Sprintf("nearly the same as %d of your recent reviews", len(own)),
+			Related: own,
+			Action:  p.SameUserAction,
+		})
+	}
+	if len(others) > 0 {
+		findings = append(findings, moderation.Finding{
+			Check:   moderation.CheckSimilar,
+			Detail:  fmt.Sprintf("nearly the same as %d recent reviews by other users", len(others)),
+			Related: others,
+			Action:  p.CrossUserAction,
+		})
+	}
+	return findings, nil
+}
+
+// indexReviewText stores the signature of a review's text and its band
+// hashes, so later reviews can be compared against it.
+func indexReviewText(discussionID int, message string) (err error) {
+	var minhash interface{}
+	var bands []int64
+	if sig, ok := moderation.MinHash(message); ok {
+		minhash = pq.Array(sig.Int64s())
+		bands = sig.Bands()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		} else if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if _, err = tx.Exec(`UPDATE discussions SET minhash = $2 WHERE id = $1`, discussionID, minhash); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM discussion_minhash_bands WHERE discussion_id = $1`, discussionID); err != nil {
+		return err
+	}
+	if len(bands) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(`
+		INSERT INTO discussion_minhash_bands (discussion_id, band, hash)
+		SELECT $1, q.band, q.hash
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS q(hash, band)
+	`, discussionID, pq.Array(bands))
+	return err
+}
@@ -0,0 +1,99 @@
+package infoDB
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthState is what AuthMiddleware needs to know about a user on every
+// request to decide whether an access token is still honoured.
+type AuthState struct {
+	TokenVersion int
+	IsActive     bool
+}
+
+type cachedAuthState struct {
+	state   AuthState
+	expires time.Time
+}
+
+func (c cachedAuthState) expiry() time.Time { return c.expires }
+
+// maxCachedUsers bounds the per-user caches, which would otherwise keep an
+// entry for every user seen since the process started.
+const maxCachedUsers = 10000
+
+// evictCached makes room in a full per-user cache. Expired entries go first;
+// if that is not enough, arbitrary fresh ones go as well until the cache is
+// down to three quarters of maxCachedUsers, so that the sweep does not run
+// again on the next insert. Callers hold the cache's write lock.
+func evictCached[V interface{ expiry() time.Time }](cache map[int]V, now time.Time) {
+	if len(cache) < maxCachedUsers {
+		return
+	}
+	for userID, entry := range cache {
+		if !now.Before(entry.expiry()) {
+			delete(cache, userID)
+		}
+	}
+	for userID := range cache {
+		if len(cache) <= maxCachedUsers*3/4 {
+			break
+		}
+		delete(cache, userID)
+	}
+}
+
+// The cache keeps AuthMiddleware off the database for hot users. Changes made
+// through this process invalidate it immediately; other instances pick them up
+// within authStateTTL.
+var (
+	authStateTTL   = 10 * time.Second
+	authStateMu    sync.RWMutex
+	authStateCache = map[int]cachedAuthState{}
+)
+
+func SetAuthStateTTL(ttl time.Duration) {
+	authStateTTL = ttl
+}
+
+func GetAuthState(userID int) (AuthState, error) {
+
+	authStateMu.RLock()
+	cached, ok := authStateCache[userID]
+	authStateMu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.state, nil
+	}
+
+	var state AuthState
+	err := db.QueryRow(`
+		SELECT token_version, is_active FROM users WHERE id = $1
+	`, userID).Scan(&state.TokenVersion, &state.IsActive)
+	if err != nil {
+		return AuthState{}, err
+	}
+
+	now := time.Now()
+	authStateMu.Lock()
+	evictCached(authStateCache, now)
+	authStateCache[userID] = cachedAuthState{state: state, expires: now.Add(authStateTTL)}
+	authStateMu.Unlock()
+
+	return state, nil
+}
+
+func InvalidateAuthState(userID int) {
+	authStateMu.Lock()
+	delete(authStateCache, userID)
+	authStateMu.Unlock()
+}
+
+// BumpTokenVersion invalidates every access token issued to the user so far.
+// Call it whenever roles, activation status or the password change.
+func BumpTokenVersion(userID int) error {
+
+	_, err := db.Exec(`UPDATE users SET token_version = token_version + 1 WHERE id = $1`, userID)
+	InvalidateAuthState(userID)
+	return err
+}
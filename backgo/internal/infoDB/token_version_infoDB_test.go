@@ -0,0 +1,47 @@
+package infoDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictCached(t *testing.T) {
+	now := time.Now()
+	fill := func(fresh, expired int) map[int]cachedAuthState {
+		cache := map[int]cachedAuthState{}
+		for i := 0; i < fresh; i++ {
+			cache[i] = cachedAuthState{expires: now.Add(time.Minute)}
+		}
+		for i := 0; i < expired; i++ {
+			cache[fresh+i] = cachedAuthState{expires: now.Add(-time.Second)}
+		}
+		return cache
+	}
+
+	tests := []struct {
+		name           string
+		fresh, expired int
+		wantLen        int
+	}{
+		{"below the limit", 10, 10, 20},
+		{"full of expired entries", 10, maxCachedUsers - 10, 10},
+		{"full of fresh entries", maxCachedUsers, 0, maxCachedUsers * 3 / 4},
+		{"mostly fresh", maxCachedUsers - 100, 100, maxCachedUsers * 3 / 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := fill(tt.fresh, tt.expired)
+			evictCached(cache, now)
+			if len(cache) != tt.wantLen {
+				t.Fatalf("len = %d, want %d", len(cache), tt.wantLen)
+			}
+			if tt.expired > 0 && len(cache) < tt.fresh+tt.expired {
+				for userID, entry := range cache {
+					if !now.Before(entry.expires) {
+						t.Fatalf("expired entry %d survived", userID)
+					}
+				}
+			}
+		})
+	}
+}
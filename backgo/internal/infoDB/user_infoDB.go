@@ -0,0 +1,162 @@
+package infoDB
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type FavouriteBreed struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ImageURL string `json:"image_url"`
+}
+
+type PublicProfile struct {
+	ID              int              `json:"id"`
+	Username        string           `json:"username"`
+	JoinedAt        time.Time        `json:"joined_at"`
+	ReviewCount     int              `json:"review_count"`
+	LikesReceived   int              `json:"likes_received"`
+	FavouriteBreeds []FavouriteBreed `json:"favourite_breeds"`
+	Profile
+}
+
+// GetPublicProfile counts only the discussions currentUserID, nil for an
+// anonymous viewer, can see.
+func GetPublicProfile(username string, currentUserID *int) (PublicProfile, error) {
+	var viewerID int
+	if currentUserID != nil {
+		viewerID = *currentUserID
+	}
+
+	var profile PublicProfile
+	err := db.QueryRow(`
+		SELECT
+			u.id, u.username, u.created_at,
+			COUNT(d.id) FILTER (WHERE d.parent_id IS NULL),
+			COALESCE(SUM(d.like_count), 0)
+		FROM users u
+		LEFT JOIN discussions d ON d.user_id = u.id AND d.is_deleted = FALSE
+			AND `+discussionVisibleTo("$2")+`
+		WHERE u.username = $1 AND u.is_active = TRUE
+		GROUP BY u.id
+	`, username, viewerID).Scan(
+		&profile.ID, &profile.Username, &profile.JoinedAt,
+		&profile.ReviewCount, &profile.LikesReceived,
+	)
+	if err != nil {
+		return PublicProfile{}, err
+	}
+
+	profile.Profile, err = GetProfile(profile.ID)
+	if err != nil {
+		return PublicProfile{}, err
+	}
+
+	favourites, err := GetFavouriteBreeds(profile.ID)
+	if err != nil {
+		return PublicProfile{}, err
+	}
+	profile.FavouriteBreeds = favourites
+
+	return profile, nil
+}
+
+func GetFavouriteBreeds(userID int) ([]FavouriteBreed, error) {
+
+	rows, err := db.Query(`
+		SELECT cb.id, cb.name, COALESCE(cb.image_url, '')
+		FROM breed_reactions br
+		JOIN cat_breeds cb ON cb.id = br.breed_id
+		WHERE br.user_id = $1 AND br.reaction_type = 'like'
+		ORDER BY br.updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	favourites := []FavouriteBreed{}
+	for rows.Next() {
+		var breed FavouriteBreed
+		if err := rows.Scan(&breed.ID, &breed.Name, &breed.ImageURL); err != nil {
+			return nil, err
+		}
+		favourites = append(favourites, breed)
+	}
+	return favourites, nil
+}
+
+func GetUserIDByUsername(username string) (int, error) {
+
+	var userID int
+	err := db.QueryRow(`SELECT id FROM users WHERE username = $1 AND is_active = TRUE`, username).Scan(&userID)
+	return userID, err
+}
+
+// CountDiscussionsByUserID is the total for GetDiscussionsByUserID with the
+// same viewer.
+func CountDiscussionsByUserID(userID int, currentUserID *int) (int, error) {
+	var viewerID int
+	if currentUserID != nil {
+		viewerID = *currentUserID
+	}
+
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM discussions d
+		WHERE d.user_id = $1 AND d.is_deleted = FALSE AND d.parent_id IS NULL
+		AND `+discussionVisibleTo("$2")+`
+	`, userID, viewerID).Scan(&total)
+	return total, err
+}
+
+func scanUserDiscussions(rows *sql.Rows) ([]Discussion, error) {
+
+	var discussions []Discussion
+	for rows.Next() {
+		var discussion Discussion
+		var parentID sql.NullInt64
+		var userReaction sql.NullString
+		var ratingsJSON []byte
+		var tagsJSON []byte
+
+		err := rows.Scan(
+			&discussion.ID, &discussion.BreedID, &discussion.BreedName, &discussion.UserID, &discussion.Username,
+			&discussion.DisplayName, &discussion.AvatarURL,
+			&parentID, &discussion.Message, &discussion.LikeCount, &discussion.DislikeCount,
+			&discussion.ReplyCount,
+			&ratingsJSON, &tagsJSON,
+			&discussion.IsDeleted, &discussion.CreatedAt, &discussion.UpdatedAt, &discussion.ModerationStatus,
+			&userReaction,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ratingsJSON) > 0 {
+			discussion.Ratings = make(map[string]int)
+			_ = json.Unmarshal(ratingsJSON, &discussion.Ratings)
+		}
+		if len(tagsJSON) > 0 {
+			var t []string
+			if err := json.Unmarshal(tagsJSON, &t); err == nil {
+				discussion.Tags = t
+			}
+		}
+
+		if parentID.Valid {
+			pid := int(parentID.Int64)
+			discussion.ParentID = &pid
+		}
+
+		if userReaction.Valid {
+			discussion.UserReaction = &userReaction.String
+		}
+
+		discussions = append(discussions, discussion)
+	}
+	return discussions, nil
+}
@@ -0,0 +1,208 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one entry of the keyring. Private is nil for keys that are only kept
+// to verify tokens signed before a rotation.
+type Key struct {
+	ID      string
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+func (k *Key) Method() jwt.SigningMethod {
+	if _, ok := k.Public.(ed25519.PublicKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Keyring holds the key new tokens are signed with plus every key whose
+// tokens are still accepted.
+type Keyring struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+func (r *Keyring) Active() *Key {
+	return r.active
+}
+
+func (r *Keyring) Lookup(kid string) (*Key, bool) {
+	k, ok := r.keys[kid]
+	return k, ok
+}
+
+// LoadDir reads every *.pem file in dir. The file name without extension
+// becomes the kid, e.g. "2024-06.pem" or "2024-01.pub.pem". Private keys may
+// be PKCS#8 (RSA or Ed25519) or PKCS#1 RSA; public keys must be PKIX. The key
+// named activeKID signs new tokens; when it is empty the last private key in
+// lexical order is used, so date-prefixed names rotate naturally.
+func LoadDir(dir, activeKID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ring := &Keyring{keys: map[string]*Key{}}
+	var lastPrivate *Key
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		kid = strings.TrimSuffix(strings.TrimSuffix(kid, ".pub"), ".key")
+
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if existing, ok := ring.keys[kid]; ok && existing.Private != nil {
+			// A private key already provides the public half.
+			continue
+		}
+		ring.keys[kid] = key
+		if key.Private != nil {
+			lastPrivate = key
+		}
+	}
+
+	if activeKID != "" {
+		key, ok := ring.keys[activeKID]
+		if !ok || key.Private == nil {
+			return nil, fmt.Errorf("no private key with kid %q in %s", activeKID, dir)
+		}
+		ring.active = key
+	} else {
+		ring.active = lastPrivate
+	}
+	if ring.active == nil {
+		return nil, fmt.Errorf("no private signing key found in %s", dir)
+	}
+	return ring, nil
+}
+
+// NewEphemeral creates a keyring with a single random Ed25519 key. Tokens do
+// not survive a restart, so it is only meant for local development.
+func NewEphemeral() (*Keyring, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := "ephemeral-" + base64.RawURLEncoding.EncodeToString(pub[:6])
+	key := &Key{ID: kid, Private: priv, Public: pub}
+	return &Keyring{active: key, keys: map[string]*Key{kid: key}}, nil
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch priv := parsed.(type) {
+		case *rsa.PrivateKey:
+			return &Key{ID: kid, Private: priv, Public: &priv.PublicKey}, nil
+		case ed25519.PrivateKey:
+			return &Key{ID: kid, Private: priv, Public: priv.Public()}, nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", parsed)
+		}
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{ID: kid, Private: priv, Public: &priv.PublicKey}, nil
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch pub := parsed.(type) {
+		case *rsa.PublicKey, ed25519.PublicKey:
+			return &Key{ID: kid, Public: pub}, nil
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", parsed)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public half of every key so other services can verify
+// tokens without sharing a secret.
+func (r *Keyring) JWKS() JWKSet {
+	kids := make([]string, 0, len(r.keys))
+	for kid := range r.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, kid := range kids {
+		key := r.keys[kid]
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: "EdDSA",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
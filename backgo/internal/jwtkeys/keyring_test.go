@@ -0,0 +1,235 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	testRSAKey, _                = rsa.GenerateKey(rand.Reader, 2048)
+	testEdPublic, testEdKey, _   = ed25519.GenerateKey(rand.Reader)
+	testEdPublic2, testEdKey2, _ = ed25519.GenerateKey(rand.Reader)
+)
+
+func pkcs8PEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func writeKeys(t *testing.T, files map[string][]byte) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadDir(t *testing.T) {
+	rsaPKCS1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testRSAKey)})
+
+	tests := []struct {
+		name      string
+		files     map[string][]byte
+		activeKID string
+		// wantActive is the kid that signs, empty when loading must fail.
+		wantActive string
+		// wantVerifyOnly are kids kept only to verify older tokens.
+		wantVerifyOnly []string
+	}{
+		{
+			name: "newest private key signs",
+			files: map[string][]byte{
+				"2024-01.pem": pkcs8PEM(t, testEdKey),
+				"2024-06.pem": pkcs8PEM(t, testEdKey2),
+			},
+			wantActive: "2024-06",
+		},
+		{
+			name: "configured kid signs",
+			files: map[string][]byte{
+				"2024-01.pem": pkcs8PEM(t, testEdKey),
+				"2024-06.pem": pkcs8PEM(t, testEdKey2),
+			},
+			activeKID:  "2024-01",
+			wantActive: "2024-01",
+		},
+		{
+			name: "retired key kept as public key",
+			files: map[string][]byte{
+				"2023-12.pub.pem": publicPEM(t, testEdPublic),
+				"2024-06.key.pem": pkcs8PEM(t, testRSAKey),
+			},
+			wantActive:     "2024-06",
+			wantVerifyOnly: []string{"2023-12"},
+		},
+		{
+			name: "public file does not replace private key",
+			files: map[string][]byte{
+				"2024-06.pem":     pkcs8PEM(t, testEdKey2),
+				"2024-06.pub.pem": publicPEM(t, testEdPublic2),
+			},
+			wantActive: "2024-06",
+		},
+		{
+			name:       "PKCS#1 RSA key",
+			files:      map[string][]byte{"rsa.pem": rsaPKCS1},
+			wantActive: "rsa",
+		},
+		{
+			name:      "configured kid is only a public key",
+			files:     map[string][]byte{"2023-12.pub.pem": publicPEM(t, testEdPublic), "2024-06.pem": pkcs8PEM(t, testEdKey2)},
+			activeKID: "2023-12",
+		},
+		{
+			name:      "configured kid is missing",
+			files:     map[string][]byte{"2024-06.pem": pkcs8PEM(t, testEdKey2)},
+			activeKID: "2025-01",
+		},
+		{
+			name:  "no private key",
+			files: map[string][]byte{"2023-12.pub.pem": publicPEM(t, testEdPublic)},
+		},
+		{
+			name:  "not a PEM file",
+			files: map[string][]byte{"broken.pem": []byte("not a key")},
+		},
+		{
+			name:  "empty directory",
+			files: map[string][]byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := LoadDir(writeKeys(t, tt.files), tt.activeKID)
+			if tt.wantActive == "" {
+				if err == nil {
+					t.Fatalf("LoadDir() succeeded with active key %q", ring.Active().ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDir() error = %v", err)
+			}
+			if ring.Active().ID != tt.wantActive || ring.Active().Private == nil {
+				t.Fatalf("active key = %q, want %q with a private key", ring.Active().ID, tt.wantActive)
+			}
+			for _, kid := range tt.wantVerifyOnly {
+				key, ok := ring.Lookup(kid)
+				if !ok || key.Private != nil {
+					t.Fatalf("Lookup(%q) = %v, %v; want a public-only key", kid, key, ok)
+				}
+			}
+		})
+	}
+}
+
+// Tokens signed before a rotation must still verify afterwards, as long as
+// the old key is kept.
+func TestRotation(t *testing.T) {
+	before, err := LoadDir(writeKeys(t, map[string][]byte{"2024-01.pem": pkcs8PEM(t, testEdKey)}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := sign(t, before.Active())
+
+	after, err := LoadDir(writeKeys(t, map[string][]byte{
+		"2024-01.pub.pem": publicPEM(t, testEdPublic),
+		"2024-06.pem":     pkcs8PEM(t, testRSAKey),
+	}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Active().Method() != jwt.SigningMethodRS256 {
+		t.Fatalf("active method = %v, want RS256", after.Active().Method().Alg())
+	}
+
+	for name, raw := range map[string]string{"old token": old, "new token": sign(t, after.Active())} {
+		_, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+			key, ok := after.Lookup(token.Header["kid"].(string))
+			if !ok {
+				return nil, jwt.ErrTokenUnverifiable
+			}
+			return key.Public, nil
+		}, jwt.WithValidMethods([]string{"RS256", "EdDSA"}))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	retired, err := LoadDir(writeKeys(t, map[string][]byte{"2024-06.pem": pkcs8PEM(t, testRSAKey)}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := retired.Lookup("2024-01"); ok {
+		t.Fatal("dropped key is still accepted")
+	}
+}
+
+func sign(t *testing.T, key *Key) string {
+	token := jwt.NewWithClaims(key.Method(), jwt.MapClaims{"sub": "1"})
+	token.Header["kid"] = key.ID
+	raw, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestJWKS(t *testing.T) {
+	ring, err := LoadDir(writeKeys(t, map[string][]byte{
+		"b.pem":     pkcs8PEM(t, testEdKey),
+		"a.pub.pem": publicPEM(t, &testRSAKey.PublicKey),
+	}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2", len(set.Keys))
+	}
+	rsaJWK, edJWK := set.Keys[0], set.Keys[1]
+	if rsaJWK.Kid != "a" || rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.N == "" || rsaJWK.E != "AQAB" {
+		t.Errorf("RSA JWK = %+v", rsaJWK)
+	}
+	if edJWK.Kid != "b" || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" || edJWK.X == "" {
+		t.Errorf("Ed25519 JWK = %+v", edJWK)
+	}
+	for _, k := range set.Keys {
+		if k.Use != "sig" {
+			t.Errorf("%s: use = %q, want sig", k.Kid, k.Use)
+		}
+	}
+}
+
+func TestNewEphemeral(t *testing.T) {
+	ring, err := NewEphemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := ring.Lookup(ring.Active().ID); !ok || key.Method() != jwt.SigningMethodEdDSA {
+		t.Fatalf("ephemeral key = %+v", key)
+	}
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional mail such as password reset and email
+// verification links.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+func format(from string, msg Message, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func validHeader(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("mail header contains a line break")
+		}
+	}
+	return nil
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{Host: host, Port: port, Username: username, Password: password, From: from}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := m.Host + ":" + m.Port
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, format(m.From, msg, time.Now()))
+}
+
+// OutboxMailer stands in for a mail server in development. It logs the
+// recipient and subject of every message; the body, which carries live
+// reset and verification links, is only written out as an .eml file when
+// Dir is set.
+type OutboxMailer struct {
+	Dir  string
+	From string
+	seq  atomic.Uint64
+}
+
+func NewOutboxMailer(dir, from string) (*OutboxMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+		}
+	}
+	return &OutboxMailer{Dir: dir, From: from}, nil
+}
+
+func (m *OutboxMailer) Send(msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+
+	log.Printf("[outbox] to=%s subject=%q", msg.To, msg.Subject)
+	if m.Dir == "" {
+		return nil
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%s-%04d.eml", now.UTC().Format("20060102T150405.000000000"), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg, now), 0o600)
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutboxMailer(t *testing.T) {
+	msg := Message{To: "cat@example.com", Subject: "Reset your password", Body: "https://app.example/reset?token=secret"}
+
+	tests := []struct {
+		name string
+		dir  string
+	}{
+		{"log only", ""},
+		{"outbox directory", filepath.Join(t.TempDir(), "outbox")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			m, err := NewOutboxMailer(tt.dir, "no-reply@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Send(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(logged.String(), "secret") {
+				t.Fatalf("log carries the message body: %s", logged.String())
+			}
+			if !strings.Contains(logged.String(), msg.To) {
+				t.Fatalf("log = %q, want the recipient", logged.String())
+			}
+			if tt.dir == "" {
+				return
+			}
+			files, _ := filepath.Glob(filepath.Join(tt.dir, "*.eml"))
+			if len(files) != 1 {
+				t.Fatalf("outbox holds %d files, want 1", len(files))
+			}
+			data, err := os.ReadFile(files[0])
+			if err != nil || !bytes.Contains(data, []byte("token=secret")) {
+				t.Fatalf("outbox file = %q, %v", data, err)
+			}
+		})
+	}
+
+	if err := (&OutboxMailer{}).Send(Message{To: "a@example.com\r\nBcc: b@example.com"}); err == nil {
+		t.Fatal("Send() accepted a header with a line break")
+	}
+}
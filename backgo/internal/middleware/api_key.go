@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"backgo/internal/infoDB"
+	"backgo/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests per minute allowed for a key without its own limit.
+var defaultAPIKeyRateLimit = 120
+
+func SetAPIKeyRateLimit(perMinute int) {
+	defaultAPIKeyRateLimit = perMinute
+}
+
+func authenticateAPIKey(c *gin.Context, key string) {
+	principal, err := infoDB.AuthenticateAPIKey(key, c.ClientIP())
+	if err == infoDB.ErrInvalidAPIKey {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		c.Abort()
+		return
+	}
+
+	limit := principal.RateLimitPerMinute
+	if limit <= 0 {
+		limit = defaultAPIKeyRateLimit
+	}
+	policy := ratelimit.Policy{Name: "api_key", Limit: limit, Period: time.Minute}
+	if !takeToken(c, policy, fmt.Sprintf("api_key:%d", principal.KeyID)) {
+		return
+	}
+
+	c.Set("user_id", principal.UserID)
+	c.Set("username", principal.Username)
+	c.Set("roles", principal.Roles)
+	// A key carries the MFA status of the session that created it, so a key
+	// minted before its owner needed MFA cannot reach RequireMFA routes.
+	c.Set("mfa", principal.MFAVerified)
+	c.Set("api_key_id", principal.KeyID)
+	c.Set("api_key_scopes", principal.Scopes)
+
+	c.Next()
+}
+
+// HasPermission reports whether the authenticated caller holds permission.
+// Requests made with an API key are further limited to the key's scopes.
+func HasPermission(c *gin.Context, permission string) bool {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return false
+	}
+
+	if scopes, ok := c.Get("api_key_scopes"); ok {
+		inScope := false
+		for _, scope := range scopes.([]string) {
+			if scope == permission {
+				inScope = true
+				break
+			}
+		}
+		if !inScope {
+			return false
+		}
+	}
+
+	return infoDB.CheckUserPermission(userID.(int), permission)
+}
+
+// RequireSession refuses API key requests. It guards routes that manage the
+// account itself, so a leaked key cannot mint more keys or change passwords.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("api_key_id"); ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
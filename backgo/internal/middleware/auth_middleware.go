@@ -12,8 +12,20 @@ import (
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		tokenString, err := c.Cookie("access_token")
-		if err != nil {
+		if err == nil {
+			// Browsers attach cookies to cross-site requests; Bearer
+			// tokens have to be added by the caller, so only cookies
+			// need the CSRF check.
+			if !checkCSRF(c) {
+				return
+			}
+		} else {
 
 			authHeader := c.GetHeader("Authorization")
 			if authHeader == "" {
@@ -40,26 +52,51 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Role changes, deactivation and password changes bump the version,
+		// which retires every access token issued before.
+		state, err := infoDB.GetAuthState(claims.UserID)
+		if err != nil || !state.IsActive || state.TokenVersion != claims.TokenVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has been revoked"})
+			c.Abort()
+			return
+		}
+
 
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("roles", claims.Roles)
+		c.Set("mfa", claims.MFA)
 
 		c.Next()
 	}
 }
 
+// OptionalAuth identifies the caller on public routes that show signed-in
+// users more, such as their own held discussions and their reactions.
+// Requests without credentials go through anonymously; credentials that are
+// sent must be valid.
+func OptionalAuth() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if _, err := c.Cookie("access_token"); err != nil &&
+			c.GetHeader("X-API-Key") == "" && c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		auth(c)
+	}
+}
+
 
 func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID, exists := c.Get("user_id")
-		if !exists {
+		if _, exists := c.Get("user_id"); !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			c.Abort()
 			return
 		}
 
-		if !infoDB.CheckUserPermission(userID.(int), permission) {
+		if !HasPermission(c, permission) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
 			c.Abort()
 			return
@@ -70,6 +107,27 @@ func RequirePermission(permission string) gin.HandlerFunc {
 }
 
 
+// RequireMFA refuses requests from users whose roles demand two-factor
+// authentication unless their session was started with a second factor.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles, _ := c.Get("roles")
+		userRoles, _ := roles.([]string)
+
+		if infoDB.MFARequiredForRoles(userRoles) && !c.GetBool("mfa") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":        "two-factor authentication is required for this account",
+				"mfa_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+
 func RequireRole(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		roles, exists := c.Get("roles")
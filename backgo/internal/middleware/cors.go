@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSPolicy says which browser origins may call a set of routes.
+// AllowedOrigins entries are either exact origins ("https://app.example.com")
+// or wildcard subdomains ("https://*.example.com", which matches
+// "https://a.example.com" and "https://a.b.example.com" but not
+// "https://example.com"). A lone "*" allows every origin and cannot be
+// combined with credentials.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSRule applies a policy to every path under PathPrefix.
+type CORSRule struct {
+	PathPrefix string
+	Policy     CORSPolicy
+}
+
+type originPattern struct {
+	scheme string
+	// host is the full host for exact patterns and the parent domain,
+	// with a leading dot, for wildcard ones.
+	host     string
+	port     string
+	wildcard bool
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// parseOrigin splits an Origin header value. Anything but a bare
+// scheme://host[:port] is rejected, so paths, credentials and the "null"
+// origin never match.
+func parseOrigin(origin string) (scheme, host, port string, ok bool) {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.User != nil || u.RawQuery != "" || u.Fragment != "" ||
+		(u.Path != "" && u.Path != "/") || u.Opaque != "" {
+		return "", "", "", false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", "", false
+	}
+
+	host = u.Hostname()
+	if host == "" {
+		return "", "", "", false
+	}
+	port = u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	return u.Scheme, host, port, true
+}
+
+func parseOriginPattern(pattern string) (originPattern, error) {
+	raw := strings.TrimSpace(pattern)
+	wildcard := false
+	if i := strings.Index(raw, "://*."); i >= 0 {
+		wildcard = true
+		raw = raw[:i+3] + raw[i+5:]
+	}
+
+	scheme, host, port, ok := parseOrigin(raw)
+	if !ok || strings.Contains(host, "*") {
+		return originPattern{}, fmt.Errorf("invalid CORS origin %q", pattern)
+	}
+	if wildcard {
+		if !strings.Contains(host, ".") {
+			// "*.com" and the like would allow half the internet.
+			return originPattern{}, fmt.Errorf("CORS wildcard %q is too broad", pattern)
+		}
+		host = "." + host
+	}
+	return originPattern{scheme: scheme, host: host, port: port, wildcard: wildcard}, nil
+}
+
+func (p originPattern) matches(scheme, host, port string) bool {
+	if p.scheme != scheme || p.port != port {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(host, p.host) && len(host) > len(p.host)
+	}
+	return p.host == host
+}
+
+type compiledCORSPolicy struct {
+	allowAll         bool
+	patterns         []originPattern
+	allowCredentials bool
+	methods          string
+	headers          string
+	exposed          string
+	maxAge           string
+}
+
+func compileCORSPolicy(policy CORSPolicy) (*compiledCORSPolicy, error) {
+	compiled := &compiledCORSPolicy{
+		allowCredentials: policy.AllowCredentials,
+		methods:          strings.Join(policy.AllowedMethods, ", "),
+		headers:          strings.Join(policy.AllowedHeaders, ", "),
+		exposed:          strings.Join(policy.ExposedHeaders, ", "),
+	}
+	if policy.MaxAge > 0 {
+		compiled.maxAge = strconv.Itoa(int(policy.MaxAge.Seconds()))
+	}
+
+	for _, origin := range policy.AllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			continue
+		}
+		if strings.TrimSpace(origin) == "*" {
+			if policy.AllowCredentials {
+				return nil, fmt.Errorf("CORS origin \"*\" cannot be used with credentials")
+			}
+			compiled.allowAll = true
+			continue
+		}
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return nil, err
+		}
+		compiled.patterns = append(compiled.patterns, pattern)
+	}
+	return compiled, nil
+}
+
+func (p *compiledCORSPolicy) allows(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	scheme, host, port, ok := parseOrigin(origin)
+	if !ok {
+		return false
+	}
+	for _, pattern := range p.patterns {
+		if pattern.matches(scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS picks the rule with the longest matching path prefix for each
+// request. It has to run on the engine rather than on route groups so that
+// it also sees preflight requests, which have no route of their own.
+// Invalid policies are a configuration error and panic at startup.
+func CORS(rules ...CORSRule) gin.HandlerFunc {
+	type compiledRule struct {
+		prefix string
+		policy *compiledCORSPolicy
+	}
+
+	compiledRules := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		policy, err := compileCORSPolicy(rule.Policy)
+		if err != nil {
+			panic(err)
+		}
+		compiledRules = append(compiledRules, compiledRule{prefix: rule.PathPrefix, policy: policy})
+	}
+	sort.SliceStable(compiledRules, func(i, j int) bool {
+		return len(compiledRules[i].prefix) > len(compiledRules[j].prefix)
+	})
+
+	return func(c *gin.Context) {
+		var policy *compiledCORSPolicy
+		for _, rule := range compiledRules {
+			if strings.HasPrefix(c.Request.URL.Path, rule.prefix) {
+				policy = rule.policy
+				break
+			}
+		}
+
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && origin != "" &&
+			c.GetHeader("Access-Control-Request-Method") != ""
+
+		if policy == nil {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// Responses differ by origin, so caches must key on it even when the
+		// origin was refused.
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		allowed := origin != "" && policy.allows(origin)
+		if allowed {
+			if policy.allowAll && !policy.allowCredentials {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.allowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if policy.exposed != "" && !preflight {
+				header.Set("Access-Control-Expose-Headers", policy.exposed)
+			}
+		}
+
+		if preflight {
+			if !allowed {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			header.Set("Access-Control-Allow-Methods", policy.methods)
+			header.Set("Access-Control-Allow-Headers", policy.headers)
+			if policy.maxAge != "" {
+				header.Set("Access-Control-Max-Age", policy.maxAge)
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
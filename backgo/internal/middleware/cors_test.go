@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func corsTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORS(
+		CORSRule{PathPrefix: "/api/", Policy: CORSPolicy{
+			AllowedOrigins:   []string{"http://localhost:3000", "https://*.example.com"},
+			AllowedMethods:   []string{"GET", "POST"},
+			AllowedHeaders:   []string{"Content-Type", "X-CSRF-Token"},
+			ExposedHeaders:   []string{"X-Total-Count"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}},
+		CORSRule{PathPrefix: "/api/admin/", Policy: CORSPolicy{
+			AllowedOrigins:   []string{"https://admin.example.com"},
+			AllowedMethods:   []string{"GET"},
+			AllowCredentials: true,
+		}},
+		CORSRule{PathPrefix: "/media/", Policy: CORSPolicy{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET"},
+		}},
+	))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/api/cats", ok)
+	r.GET("/api/admin/users", ok)
+	r.GET("/media/a.jpg", ok)
+	r.GET("/health", ok)
+	return r
+}
+
+func TestCORSOrigins(t *testing.T) {
+	r := corsTestRouter()
+
+	tests := []struct {
+		name   string
+		path   string
+		origin string
+		// want is the expected Access-Control-Allow-Origin, empty when the
+		// origin must be refused.
+		want string
+	}{
+		{"exact origin", "/api/cats", "http://localhost:3000", "http://localhost:3000"},
+		{"exact origin in upper case", "/api/cats", "HTTP://LOCALHOST:3000", "HTTP://LOCALHOST:3000"},
+		{"subdomain of wildcard", "/api/cats", "https://app.example.com", "https://app.example.com"},
+		{"nested subdomain of wildcard", "/api/cats", "https://a.b.example.com", "https://a.b.example.com"},
+		{"explicit default port", "/api/cats", "https://app.example.com:443", "https://app.example.com:443"},
+
+		{"exact origin as subdomain prefix", "/api/cats", "http://localhost:3000.evil.com", ""},
+		{"lookalike of wildcard domain", "/api/cats", "https://evil-example.com", ""},
+		{"wildcard domain as subdomain prefix", "/api/cats", "https://app.example.com.evil.com", ""},
+		{"bare apex against wildcard", "/api/cats", "https://example.com", ""},
+		{"scheme mismatch on wildcard", "/api/cats", "http://app.example.com", ""},
+		{"scheme mismatch on exact", "/api/cats", "https://localhost:3000", ""},
+		{"port mismatch on wildcard", "/api/cats", "https://app.example.com:8443", ""},
+		{"port mismatch on exact", "/api/cats", "http://localhost:3001", ""},
+		{"default port instead of configured", "/api/cats", "http://localhost", ""},
+		{"null origin", "/api/cats", "null", ""},
+		{"origin with path", "/api/cats", "https://app.example.com/x", ""},
+		{"origin with credentials", "/api/cats", "https://user@app.example.com", ""},
+		{"non-http scheme", "/api/cats", "file://app.example.com", ""},
+
+		{"admin rule is stricter", "/api/admin/users", "https://app.example.com", ""},
+		{"admin origin on admin rule", "/api/admin/users", "https://admin.example.com", "https://admin.example.com"},
+		{"media allows any origin", "/media/a.jpg", "https://anywhere.test", "*"},
+		{"media allows null origin", "/media/a.jpg", "null", "*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.want)
+			}
+			if !slices.Contains(w.Header().Values("Vary"), "Origin") {
+				t.Fatalf("Vary = %q, want it to include Origin", w.Header().Values("Vary"))
+			}
+
+			credentials := w.Header().Get("Access-Control-Allow-Credentials")
+			exposed := w.Header().Get("Access-Control-Expose-Headers")
+			switch {
+			case tt.want == "":
+				if credentials != "" || exposed != "" {
+					t.Fatalf("refused origin got credentials %q, exposed headers %q", credentials, exposed)
+				}
+			case tt.want == "*":
+				if credentials != "" {
+					t.Fatal("wildcard origin must not allow credentials")
+				}
+			case credentials != "true":
+				t.Fatalf("Access-Control-Allow-Credentials = %q, want true", credentials)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	r := corsTestRouter()
+
+	tests := []struct {
+		name       string
+		path       string
+		origin     string
+		wantStatus int
+		wantMaxAge string
+	}{
+		{"allowed origin", "/api/cats", "https://app.example.com", http.StatusNoContent, "600"},
+		{"refused origin", "/api/cats", "https://evil-example.com", http.StatusForbidden, ""},
+		{"null origin", "/api/cats", "null", http.StatusForbidden, ""},
+		{"policy without max age", "/api/admin/users", "https://admin.example.com", http.StatusNoContent, ""},
+		{"path without a policy", "/health", "https://app.example.com", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "POST")
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Max-Age"); got != tt.wantMaxAge {
+				t.Fatalf("Access-Control-Max-Age = %q, want %q", got, tt.wantMaxAge)
+			}
+
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.wantStatus != http.StatusNoContent {
+				if allowOrigin != "" || w.Header().Get("Access-Control-Allow-Methods") != "" {
+					t.Fatalf("refused preflight got Allow-Origin %q", allowOrigin)
+				}
+			} else {
+				if allowOrigin != tt.origin {
+					t.Fatalf("Access-Control-Allow-Origin = %q, want %q", allowOrigin, tt.origin)
+				}
+				if w.Header().Get("Access-Control-Allow-Methods") == "" {
+					t.Fatal("Access-Control-Allow-Methods is missing")
+				}
+			}
+
+			if tt.path == "/health" {
+				return
+			}
+			vary := w.Header().Values("Vary")
+			for _, h := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+				if !slices.Contains(vary, h) {
+					t.Fatalf("Vary = %q, want it to include %s", vary, h)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy CORSPolicy
+	}{
+		{"star with credentials", CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}},
+		{"wildcard on a top-level domain", CORSPolicy{AllowedOrigins: []string{"https://*.com"}}},
+		{"wildcard inside a label", CORSPolicy{AllowedOrigins: []string{"https://app*.example.com"}}},
+		{"origin with path", CORSPolicy{AllowedOrigins: []string{"https://example.com/app"}}},
+		{"origin without scheme", CORSPolicy{AllowedOrigins: []string{"example.com"}}},
+		{"null origin", CORSPolicy{AllowedOrigins: []string{"null"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileCORSPolicy(tt.policy); err == nil {
+				t.Fatal("compileCORSPolicy() accepted an invalid policy")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"backgo/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// SetRateLimitStore replaces the in-memory store, e.g. with a shared one when
+// running several instances.
+func SetRateLimitStore(store ratelimit.Store) {
+	rateLimitStore = store
+}
+
+// rateLimitSubject identifies who is being limited: the API key or user when
+// the request is authenticated, the client IP otherwise. The client IP is
+// only as trustworthy as the engine's trusted proxies make it.
+func rateLimitSubject(c *gin.Context) string {
+	if keyID, ok := c.Get("api_key_id"); ok {
+		return fmt.Sprintf("key:%d", keyID)
+	}
+	if userID, ok := c.Get("user_id"); ok {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return "ip:" + clientNetwork(c.ClientIP())
+}
+
+// clientNetwork is the address an anonymous caller is limited by. IPv6
+// clients are usually handed a whole /64, so limiting single addresses would
+// let one client pick a fresh one for every request.
+func clientNetwork(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.WithZone("").Prefix(64)
+	return prefix.String()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// takeToken counts the request against key and writes the RateLimit-*
+// headers. It aborts with 429 and returns false when the bucket is empty.
+// Store failures let the request through rather than take the API down.
+func takeToken(c *gin.Context, policy ratelimit.Policy, key string) bool {
+	result, err := rateLimitStore.Take(c.Request.Context(), key, policy)
+	if err != nil {
+		log.Printf("Rate limit store error for %s: %v", key, err)
+		return true
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	c.Header("RateLimit-Policy", policy.Header())
+
+	if !result.Allowed {
+		retryAfter := ceilSeconds(result.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "too many requests, please slow down",
+			"retry_after": retryAfter,
+		})
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// RateLimit applies policy per caller. Put it after AuthMiddleware on
+// authenticated routes so callers are limited by user or API key rather
+// than by a possibly shared IP.
+func RateLimit(policy ratelimit.Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !takeToken(c, policy, policy.Name+":"+rateLimitSubject(c)) {
+			return
+		}
+		c.Next()
+	}
+}
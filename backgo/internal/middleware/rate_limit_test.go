@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backgo/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientNetwork(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{"::ffff:203.0.113.7", "203.0.113.7"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::1", "2001:db8:1:2::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"not an ip", "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := clientNetwork(tt.ip); got != tt.want {
+			t.Errorf("clientNetwork(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	policy, err := ratelimit.ParsePolicy("test", "1/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		remoteAddr   string
+		forwardedFor string
+		wantLimited  bool
+	}
+	tests := []struct {
+		name     string
+		proxies  []string
+		requests []request
+	}{
+		{
+			name: "forwarded header from an untrusted peer is ignored",
+			requests: []request{
+				{remoteAddr: "203.0.113.7:1000", forwardedFor: "198.51.100.1"},
+				{remoteAddr: "203.0.113.7:1001", forwardedFor: "198.51.100.2", wantLimited: true},
+				{remoteAddr: "203.0.113.8:1000", forwardedFor: "198.51.100.1"},
+			},
+		},
+		{
+			name:    "forwarded header from a trusted proxy is used",
+			proxies: []string{"10.0.0.0/8"},
+			requests: []request{
+				{remoteAddr: "10.0.0.1:1000", forwardedFor: "198.51.100.1"},
+				{remoteAddr: "10.0.0.2:1000", forwardedFor: "198.51.100.2"},
+				{remoteAddr: "10.0.0.1:1001", forwardedFor: "198.51.100.1", wantLimited: true},
+			},
+		},
+		{
+			name: "addresses in one IPv6 /64 share a bucket",
+			requests: []request{
+				{remoteAddr: "[2001:db8:1:2::1]:1000"},
+				{remoteAddr: "[2001:db8:1:2::2]:1000", wantLimited: true},
+				{remoteAddr: "[2001:db8:1:3::1]:1000"},
+			},
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetRateLimitStore(ratelimit.NewMemoryStore())
+			r := gin.New()
+			if err := r.SetTrustedProxies(tt.proxies); err != nil {
+				t.Fatal(err)
+			}
+			r.GET("/", RateLimit(policy), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, req := range tt.requests {
+				httpReq := httptest.NewRequest(http.MethodGet, "/", nil)
+				httpReq.RemoteAddr = req.remoteAddr
+				if req.forwardedFor != "" {
+					httpReq.Header.Set("X-Forwarded-For", req.forwardedFor)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httpReq)
+
+				if limited := w.Code == http.StatusTooManyRequests; limited != req.wantLimited {
+					t.Fatalf("request %d: status = %d, want limited = %v", i, w.Code, req.wantLimited)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Action is what happens to a message a check objects to. Actions are
+// ordered by severity and a message gets the most severe one of its
+// findings. Flag publishes the message but puts it in front of moderators.
+type Action int
+
+const (
+	Allow Action = iota
+	Mask
+	Flag
+	Hold
+	Reject
+)
+
+var actionNames = []string{"allow", "mask", "flag", "hold", "reject"}
+
+func (a Action) String() string {
+	if a < Allow || a > Reject {
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+	return actionNames[a]
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Action) UnmarshalText(text []byte) error {
+	parsed, err := ParseAction(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// ParseAction reads an action name. "off" and the empty string mean Allow,
+// which turns a check off.
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "allow":
+		return Allow, nil
+	case "mask":
+		return Mask, nil
+	case "flag":
+		return Flag, nil
+	case "hold":
+		return Hold, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Allow, fmt.Errorf("unknown moderation action %q, expected off, mask, flag, hold or reject", s)
+}
+
+// Check names used in findings.
+const (
+	CheckProfanity = "profanity"
+	CheckLinks     = "links"
+	CheckRepeat    = "repeated_text"
+	CheckDuplicate = "duplicate"
+	CheckSimilar   = "similar_review"
+)
+
+// Related is an earlier discussion a finding refers to.
+type Related struct {
+	DiscussionID int     `json:"discussion_id"`
+	Similarity   float64 `json:"similarity"`
+}
+
+type Finding struct {
+	Check   string    `json:"check"`
+	Detail  string    `json:"detail"`
+	Match   string    `json:"match,omitempty"`
+	Related []Related `json:"related,omitempty"`
+	Action  Action    `json:"action" swaggertype:"string"`
+}
+
+// Input is a message about to be posted. DiscussionID is 0 for a new
+// discussion and ParentID is nil for a review.
+type Input struct {
+	UserID       int
+	BreedID      int
+	DiscussionID int
+	ParentID     *int
+	Message      string
+}
+
+// Check inspects a message and reports what it objects to.
+type Check interface {
+	Check(in Input) ([]Finding, error)
+}
+
+// Masker is implemented by checks that can hide what they found instead of
+// holding or rejecting the whole message. A Mask finding from a check that
+// cannot mask holds the message instead.
+type Masker interface {
+	Mask(message string) string
+}
+
+type Result struct {
+	Action   Action
+	Message  string
+	Findings []Finding
+}
+
+// Error is returned when a message is rejected.
+type Error struct {
+	Findings []Finding
+}
+
+func (e *Error) Error() string {
+	details := make([]string, 0, len(e.Findings))
+	for _, f := range e.Findings {
+		if f.Action == Reject {
+			details = append(details, f.Detail)
+		}
+	}
+	return "message rejected by the content filter: " + strings.Join(details, "; ")
+}
+
+// Pipeline runs its checks in order. Masks are applied as they are found,
+// so later checks see the masked message.
+type Pipeline []Check
+
+func (p Pipeline) Run(in Input) (Result, error) {
+	result := Result{Action: Allow, Findings: []Finding{}}
+	for _, check := range p {
+		findings, err := check.Check(in)
+		if err != nil {
+			return Result{}, err
+		}
+
+		masked := false
+		for i, f := range findings {
+			if f.Action == Mask {
+				if _, ok := check.(Masker); ok {
+					masked = true
+				} else {
+					findings[i].Action = Hold
+				}
+			}
+			if findings[i].Action > result.Action {
+				result.Action = findings[i].Action
+			}
+		}
+		if masked {
+			in.Message = check.(Masker).Mask(in.Message)
+		}
+		result.Findings = append(result.Findings, findings...)
+	}
+	result.Message = in.Message
+	return result, nil
+}
+
+// Policy configures the built-in spam checks. Word list actions are set per
+// word. Similarity thresholds are estimated Jaccard similarities of the
+// reviews' text, from 0 to 1.
+type Policy struct {
+	MaxLinks        int
+	LinkAction      Action
+	RepeatAction    Action
+	DuplicateAction Action
+	DuplicateWindow time.Duration
+
+	SimilarityWindow    time.Duration
+	SameUserSimilarity  float64
+	SameUserAction      Action
+	CrossUserSimilarity float64
+	CrossUserAction     Action
+}
+
+func Default() Policy {
+	return Policy{
+		MaxLinks:        1,
+		LinkAction:      Hold,
+		RepeatAction:    Hold,
+		DuplicateAction: Reject,
+		DuplicateWindow: 24 * time.Hour,
+
+		SimilarityWindow:    7 * 24 * time.Hour,
+		SameUserSimilarity:  0.7,
+		SameUserAction:      Reject,
+		CrossUserSimilarity: 0.85,
+		CrossUserAction:     Flag,
+	}
+}
@@ -0,0 +1,175 @@
+package moderation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// run is a stretch of one repeated character in normalised text. Separators
+// all become a single space run. start and end are the rune indexes the run
+// covers in the original text, inclusive, so matches can be masked there.
+type run struct {
+	r          rune
+	n          int
+	start, end int
+}
+
+const space = ' '
+
+// Look-alikes people use to get words past a filter. Digits are only read as
+// letters inside words that have letters, so "555" stays a Thai laugh.
+var (
+	leetDigits = map[rune]rune{
+		'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	}
+	leetSymbols = map[rune]rune{
+		'@': 'a', '$': 's', '!': 'i', '|': 'i', '€': 'e', '£': 'l',
+	}
+	accentFolds = map[rune]rune{
+		'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a',
+		'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+		'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+		'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o',
+		'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+		'ý': 'y', 'ÿ': 'y', 'ñ': 'n', 'ç': 'c',
+	}
+)
+
+func isThai(r rune) bool {
+	return r >= 0x0E00 && r <= 0x0E7F
+}
+
+// isIgnorable reports characters dropped before matching: invisible
+// characters, Thai tone marks and thanthakhat, which can be added or left
+// out without changing how a word reads, and combining accents.
+func isIgnorable(r rune) bool {
+	switch {
+	case r == '\u00AD', r >= '\u200B' && r <= '\u200D', r == '\u2060', r == '\uFEFF':
+		return true
+	case r >= '\u0E47' && r <= '\u0E4C':
+		return true
+	case unicode.Is(unicode.Mn, r) && !isThai(r):
+		return true
+	}
+	return false
+}
+
+// isWordRune reports letters, digits and the Thai vowel signs written above
+// and below consonants.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || (isThai(r) && unicode.Is(unicode.Mn, r))
+}
+
+type char struct {
+	r rune
+	i int
+}
+
+// normalize folds text into runs: lower case, accents and look-alikes
+// folded, invisible characters and tone marks dropped, separators merged
+// and letters spelled out one at a time ("f.u.c.k") joined back up.
+func normalize(text string) []run {
+	orig := []rune(text)
+	chars := make([]char, 0, len(orig))
+	for i, r := range orig {
+		r = unicode.ToLower(r)
+		if folded, ok := accentFolds[r]; ok {
+			r = folded
+		}
+		switch {
+		case isIgnorable(r):
+			continue
+		case isWordRune(r):
+			chars = append(chars, char{r, i})
+		case leetSymbols[r] != 0 && i+1 < len(orig) && isWordRune(unicode.ToLower(orig[i+1])):
+			chars = append(chars, char{leetSymbols[r], i})
+		default:
+			chars = append(chars, char{space, i})
+		}
+	}
+
+	for start := 0; start < len(chars); {
+		end := start
+		hasLetter := false
+		for end < len(chars) && chars[end].r != space {
+			hasLetter = hasLetter || unicode.IsLetter(chars[end].r)
+			end++
+		}
+		if hasLetter {
+			for k := start; k < end; k++ {
+				if letter, ok := leetDigits[chars[k].r]; ok {
+					chars[k].r = letter
+				}
+			}
+		}
+		start = end + 1
+	}
+
+	var runs []run
+	for _, c := range chars {
+		if last := len(runs) - 1; last >= 0 && runs[last].r == c.r {
+			if c.r != space {
+				runs[last].n++
+			}
+			runs[last].end = c.i
+			continue
+		}
+		runs = append(runs, run{r: c.r, n: 1, start: c.i, end: c.i})
+	}
+	return joinSpelledOut(runs)
+}
+
+// minSpelledOut is how many single letters in a row are read as one word.
+const minSpelledOut = 3
+
+// joinSpelledOut removes the separators between runs of at least
+// minSpelledOut single letters.
+func joinSpelledOut(runs []run) []run {
+	single := func(i int) bool {
+		return i < len(runs) && runs[i].r != space && unicode.IsLetter(runs[i].r) &&
+			(i == 0 || runs[i-1].r == space) && (i+1 == len(runs) || runs[i+1].r == space)
+	}
+
+	out := make([]run, 0, len(runs))
+	for i := 0; i < len(runs); {
+		count := 0
+		for j := i; single(j); j += 2 {
+			count++
+		}
+		if count < minSpelledOut {
+			out = append(out, runs[i])
+			i++
+			continue
+		}
+		for k := 0; k < count; k++ {
+			r := runs[i+2*k]
+			if last := len(out) - 1; last >= 0 && out[last].r == r.r {
+				out[last].n += r.n
+				out[last].end = r.end
+			} else {
+				out = append(out, r)
+			}
+		}
+		i += 2*count - 1
+	}
+	return out
+}
+
+// Fingerprint identifies a message up to case, spacing, punctuation,
+// repeated letters and look-alikes, for spotting the same text posted
+// again. It is empty for a message with no letters or digits.
+func Fingerprint(message string) string {
+	var b strings.Builder
+	for _, r := range normalize(message) {
+		if r.r != space {
+			b.WriteRune(r.r)
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,132 @@
+package moderation
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Reviews are compared by MinHash over character shingles of their
+// normalised text. Character shingles work the same for Thai, which has no
+// spaces between words, as for English.
+const (
+	SignatureSize  = 64
+	SignatureBands = 16
+	bandRows       = SignatureSize / SignatureBands
+	shingleSize    = 5
+
+	// Short texts such as "so cute!" are posted independently all the time,
+	// so only texts with at least this many distinct shingles are compared.
+	minShingles = 20
+)
+
+// Signature is a MinHash signature. The fraction of positions where two
+// signatures agree estimates the Jaccard similarity of their texts.
+type Signature [SignatureSize]uint64
+
+var minHashSeeds = func() [SignatureSize]uint64 {
+	var seeds [SignatureSize]uint64
+	for i := range seeds {
+		seeds[i] = mix(uint64(i+1) * 0x9e3779b97f4a7c15)
+	}
+	return seeds
+}()
+
+// mix is the splitmix64 finaliser.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func shingles(message string) map[uint64]struct{} {
+	var text []rune
+	for _, r := range normalize(message) {
+		if r.r == space && (len(text) == 0 || text[len(text)-1] == space) {
+			continue
+		}
+		text = append(text, r.r)
+	}
+	for len(text) > 0 && text[len(text)-1] == space {
+		text = text[:len(text)-1]
+	}
+
+	set := map[uint64]struct{}{}
+	for i := 0; i+shingleSize <= len(text); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(string(text[i : i+shingleSize])))
+		set[h.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// MinHash computes the signature of message. It reports false for text too
+// short to compare.
+func MinHash(message string) (Signature, bool) {
+	set := shingles(message)
+	if len(set) < minShingles {
+		return Signature{}, false
+	}
+
+	var sig Signature
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	for h := range set {
+		for i, seed := range minHashSeeds {
+			if v := mix(h ^ seed); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig, true
+}
+
+func (s Signature) Similarity(other Signature) float64 {
+	same := 0
+	for i := range s {
+		if s[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / SignatureSize
+}
+
+// Bands hashes the signature in SignatureBands groups of rows. Texts that
+// share any band are candidates for a full comparison; with 16 bands of 4
+// rows, pairs above about 0.5 similarity almost always share one.
+func (s Signature) Bands() []int64 {
+	bands := make([]int64, SignatureBands)
+	for b := range bands {
+		h := uint64(b + 1)
+		for _, v := range s[b*bandRows : (b+1)*bandRows] {
+			h = mix(h ^ v)
+		}
+		bands[b] = int64(h)
+	}
+	return bands
+}
+
+// Int64s converts the signature for storage in a BIGINT[] column.
+func (s Signature) Int64s() []int64 {
+	values := make([]int64, SignatureSize)
+	for i, v := range s {
+		values[i] = int64(v)
+	}
+	return values
+}
+
+// SignatureFromInt64s reverses Int64s. It reports false if values is not a
+// whole signature.
+func SignatureFromInt64s(values []int64) (Signature, bool) {
+	var sig Signature
+	if len(values) != SignatureSize {
+		return sig, false
+	}
+	for i, v := range values {
+		sig[i] = uint64(v)
+	}
+	return sig, true
+}
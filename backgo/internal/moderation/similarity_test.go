@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"math"
+	"testing"
+)
+
+const review = "My Maine Coon is the gentlest giant, patient with the kids and " +
+	"always following me from room to room. Grooming takes ten minutes a day."
+
+// jaccard is the exact similarity MinHash estimates.
+func jaccard(a, b string) float64 {
+	sa, sb := shingles(a), shingles(b)
+	both := 0
+	for h := range sa {
+		if _, ok := sb[h]; ok {
+			both++
+		}
+	}
+	return float64(both) / float64(len(sa)+len(sb)-both)
+}
+
+func TestMinHashSimilarity(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		min, max float64
+	}{
+		{"identical", review, review, 1, 1},
+		{
+			"case, spacing and punctuation",
+			review,
+			"MY MAINE COON is the gentlest giant... patient with the kids and always " +
+				"following me from room to room!!! Grooming takes ten minutes a day",
+			1, 1,
+		},
+		{"look-alikes and stretched letters", review, "My Ma1ne Coon is the gentleeest giant, patient with the k1ds and " +
+			"always following me from room to room. Grooming takes ten minutes a day.", 0.75, 1},
+		{"one word changed", review, "My Maine Coon is the gentlest giant, patient with the dogs and " +
+			"always following me from room to room. Grooming takes ten minutes a day.", 0.6, 0.95},
+		{"sentence appended", review, review + " Highly recommend the breed to any family.", 0.5, 0.9},
+		{"unrelated", review, "Sphynx cats need weekly baths because their skin gets oily, " +
+			"and they feel the cold so a heated bed helps in winter.", 0, 0.15},
+		{"Thai near-duplicate", "แมวเปอร์เซียขนยาวต้องแปรงขนทุกวันไม่อย่างนั้นขนจะพันกันเป็นก้อน",
+			"แมวเปอร์เซียขนยาวต้องแปรงขนทุกวันนะไม่อย่างนั้นขนจะพันกันเป็นก้อน", 0.6, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa, okA := MinHash(tt.a)
+			sb, okB := MinHash(tt.b)
+			if !okA || !okB {
+				t.Fatal("MinHash() refused a long text")
+			}
+			got := sa.Similarity(sb)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("Similarity() = %.2f, want %.2f..%.2f", got, tt.min, tt.max)
+			}
+			// 64 positions give an estimate within about 0.2 of the exact
+			// value nearly always.
+			if exact := jaccard(tt.a, tt.b); math.Abs(got-exact) > 0.2 {
+				t.Fatalf("Similarity() = %.2f, exact Jaccard %.2f", got, exact)
+			}
+		})
+	}
+}
+
+func TestMinHashShortText(t *testing.T) {
+	for _, text := range []string{"", "so cute!", "Love this breed so much", "!!!!!!!!!!!!!!!!!!!!!!!!!!!!"} {
+		if _, ok := MinHash(text); ok {
+			t.Errorf("MinHash(%q) compared a text that is too short", text)
+		}
+	}
+}
+
+func TestBands(t *testing.T) {
+	shareBand := func(a, b string) bool {
+		sa, _ := MinHash(a)
+		sb, _ := MinHash(b)
+		bands := map[int64]bool{}
+		for _, band := range sa.Bands() {
+			bands[band] = true
+		}
+		for _, band := range sb.Bands() {
+			if bands[band] {
+				return true
+			}
+		}
+		return false
+	}
+
+	nearDuplicate := "My Maine Coon is the gentlest giant, patient with the kids and " +
+		"always following me from room to room. Grooming takes ten minutes daily."
+	if !shareBand(review, nearDuplicate) {
+		t.Error("near-duplicates share no band")
+	}
+	unrelated := "Sphynx cats need weekly baths because their skin gets oily, " +
+		"and they feel the cold so a heated bed helps in winter."
+	if shareBand(review, unrelated) {
+		t.Error("unrelated texts share a band")
+	}
+
+	sig, _ := MinHash(review)
+	if len(sig.Bands()) != SignatureBands {
+		t.Fatalf("len(Bands()) = %d, want %d", len(sig.Bands()), SignatureBands)
+	}
+}
+
+func TestSignatureInt64s(t *testing.T) {
+	sig, _ := MinHash(review)
+	back, ok := SignatureFromInt64s(sig.Int64s())
+	if !ok || back != sig {
+		t.Fatal("signature did not survive the BIGINT[] round trip")
+	}
+	if _, ok := SignatureFromInt64s(sig.Int64s()[:10]); ok {
+		t.Fatal("SignatureFromInt64s() accepted a partial signature")
+	}
+}
@@ -0,0 +1,105 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// linkPattern matches URLs with a scheme or www. prefix and bare domains on
+// common top-level domains, which is how most spam links are written.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+|\b[a-z0-9](?:[a-z0-9-]*[a-z0-9])?(?:\.[a-z0-9-]+)*\.(?:com|net|org|info|biz|io|co|me|ly|gg|xyz|top|site|online|shop|link|click|th)\b(?:/[^\s<>"]*)?`)
+
+// LinkCheck objects to messages with more than Max links.
+type LinkCheck struct {
+	Max    int
+	Action Action
+}
+
+func (c LinkCheck) Check(in Input) ([]Finding, error) {
+	if c.Action == Allow {
+		return nil, nil
+	}
+	links := linkPattern.FindAllString(in.Message, -1)
+	if len(links) <= c.Max {
+		return nil, nil
+	}
+	return []Finding{{
+		Check:  CheckLinks,
+		Detail: fmt.Sprintf("contains %d links, at most %d allowed", len(links), c.Max),
+		Match:  links[0],
+		Action: c.Action,
+	}}, nil
+}
+
+func (c LinkCheck) Mask(message string) string {
+	return linkPattern.ReplaceAllString(message, "[link removed]")
+}
+
+const (
+	// A unit of up to maxRepeatUnit characters said minRepeats times in a
+	// row over at least minRepeatRunes characters is flooding.
+	maxRepeatUnit  = 30
+	minRepeats     = 5
+	minRepeatRunes = 20
+
+	// Messages of at least minRatioWords words where fewer than
+	// minDistinctRatio of them are different words are flooding too.
+	minRatioWords    = 12
+	minDistinctRatio = 0.3
+)
+
+// RepeatCheck objects to text repeated over and over.
+type RepeatCheck struct {
+	Action Action
+}
+
+func (c RepeatCheck) Check(in Input) ([]Finding, error) {
+	if c.Action == Allow {
+		return nil, nil
+	}
+
+	words := strings.Fields(strings.ToLower(in.Message))
+	if unit, ok := repeatedUnit([]rune(strings.Join(words, " "))); ok {
+		return []Finding{{
+			Check:  CheckRepeat,
+			Detail: "repeats the same text over and over",
+			Match:  unit,
+			Action: c.Action,
+		}}, nil
+	}
+
+	if len(words) >= minRatioWords {
+		distinct := map[string]bool{}
+		for _, w := range words {
+			distinct[w] = true
+		}
+		if float64(len(distinct))/float64(len(words)) < minDistinctRatio {
+			return []Finding{{
+				Check:  CheckRepeat,
+				Detail: "repeats the same few words",
+				Action: c.Action,
+			}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// repeatedUnit looks for a short piece of text repeated back to back.
+func repeatedUnit(text []rune) (string, bool) {
+	for period := 1; period <= maxRepeatUnit && period < len(text); period++ {
+		same := 0
+		for i := period; i < len(text); i++ {
+			if text[i] != text[i-period] {
+				same = 0
+				continue
+			}
+			same++
+			if length := same + period; length >= minRepeatRunes && length >= period*minRepeats {
+				start := i - length + 1
+				return string(text[start : start+period]), true
+			}
+		}
+	}
+	return "", false
+}
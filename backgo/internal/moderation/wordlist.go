@@ -0,0 +1,155 @@
+package moderation
+
+import (
+	"sort"
+	"unicode"
+)
+
+type Word struct {
+	Text   string
+	Action Action
+}
+
+type pattern struct {
+	word Word
+	runs []run
+	// Thai is written without spaces between words, so Thai words match
+	// anywhere. Other words only match whole words.
+	anywhere bool
+}
+
+// WordList finds listed words in text after normalising both, so
+// "F U C K", "fuuuck" and "f*ck" style variants match the listed word.
+// A repeated letter in a listed word must be repeated in the text too,
+// so listing "ass" does not block "as".
+type WordList struct {
+	patterns map[rune][]pattern
+}
+
+// wordRuns normalises a listed word, trimming separators from its ends.
+func wordRuns(text string) []run {
+	runs := normalize(text)
+	for len(runs) > 0 && runs[0].r == space {
+		runs = runs[1:]
+	}
+	for len(runs) > 0 && runs[len(runs)-1].r == space {
+		runs = runs[:len(runs)-1]
+	}
+	return runs
+}
+
+// ValidWord reports whether text has something left to match once
+// normalised.
+func ValidWord(text string) bool {
+	for _, r := range wordRuns(text) {
+		if unicode.IsLetter(r.r) {
+			return true
+		}
+	}
+	return false
+}
+
+func NewWordList(words []Word) *WordList {
+	list := &WordList{patterns: map[rune][]pattern{}}
+	for _, w := range words {
+		runs := wordRuns(w.Text)
+		if len(runs) == 0 || w.Action == Allow {
+			continue
+		}
+		anywhere := false
+		for _, r := range runs {
+			anywhere = anywhere || isThai(r.r)
+		}
+		first := runs[0].r
+		list.patterns[first] = append(list.patterns[first], pattern{word: w, runs: runs, anywhere: anywhere})
+	}
+	return list
+}
+
+type wordMatch struct {
+	word       Word
+	start, end int
+}
+
+func (l *WordList) find(text []run) []wordMatch {
+	var matches []wordMatch
+	for i, t := range text {
+		for _, p := range l.patterns[t.r] {
+			end := i + len(p.runs) - 1
+			if end >= len(text) || !matchAt(text[i:], p.runs) {
+				continue
+			}
+			if !p.anywhere {
+				if (i > 0 && text[i-1].r != space) || (end+1 < len(text) && text[end+1].r != space) {
+					continue
+				}
+			}
+			matches = append(matches, wordMatch{word: p.word, start: text[i].start, end: text[end].end})
+		}
+	}
+	return matches
+}
+
+func matchAt(text, word []run) bool {
+	for k, w := range word {
+		if text[k].r != w.r || text[k].n < w.n {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *WordList) Check(in Input) ([]Finding, error) {
+	orig := []rune(in.Message)
+	seen := map[string]bool{}
+	var findings []Finding
+	for _, m := range l.find(normalize(in.Message)) {
+		if seen[m.word.Text] {
+			continue
+		}
+		seen[m.word.Text] = true
+		findings = append(findings, Finding{
+			Check:  CheckProfanity,
+			Detail: "contains a blocked word",
+			Match:  string(orig[m.start : m.end+1]),
+			Action: m.word.Action,
+		})
+	}
+	return findings, nil
+}
+
+// Mask replaces the letters of every listed word with asterisks. Thai vowel
+// signs above and below a masked letter are removed with it.
+func (l *WordList) Mask(message string) string {
+	matches := l.find(normalize(message))
+	if len(matches) == 0 {
+		return message
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	orig := []rune(message)
+	out := make([]rune, 0, len(orig))
+	m := 0
+	for i, r := range orig {
+		for m < len(matches) && matches[m].end < i {
+			m++
+		}
+		masked := false
+		for k := m; k < len(matches) && matches[k].start <= i; k++ {
+			if i <= matches[k].end {
+				masked = true
+				break
+			}
+		}
+		switch {
+		case !masked:
+			out = append(out, r)
+		case unicode.Is(unicode.Mn, r):
+		case isWordRune(r) || leetSymbols[r] != 0:
+			out = append(out, '*')
+		default:
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,111 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+type rawKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type rawKeySet struct {
+	Keys []rawKey `json:"keys"`
+}
+
+type keySet struct {
+	byKID map[string]interface{}
+	// Providers with a single key sometimes omit kid from tokens.
+	only interface{}
+}
+
+func (s *keySet) lookup(kid string) (interface{}, bool) {
+	if kid == "" && s.only != nil {
+		return s.only, true
+	}
+	key, ok := s.byKID[kid]
+	return key, ok
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (raw rawKeySet) parse() (*keySet, error) {
+	set := &keySet{byKID: map[string]interface{}{}}
+
+	for _, k := range raw.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key %q: %w", k.Kid, err)
+			}
+			e, err := decodeInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key %q: %w", k.Kid, err)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, err := decodeInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EC key %q: %w", k.Kid, err)
+			}
+			y, err := decodeInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EC key %q: %w", k.Kid, err)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			if k.Crv != "Ed25519" {
+				continue
+			}
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid Ed25519 key %q", k.Kid)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+
+		set.byKID[k.Kid] = key
+	}
+
+	if len(set.byKID) == 1 {
+		for _, key := range set.byKID {
+			set.only = key
+		}
+	}
+	return set, nil
+}
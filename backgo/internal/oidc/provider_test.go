@@ -0,0 +1,270 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an identity provider serving discovery, JWKS and a token
+// endpoint that enforces PKCE the way a real provider does.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu sync.Mutex
+	// codes maps an issued authorization code to its PKCE challenge and
+	// the claims of the ID token it is exchanged for.
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+const testClientID = "catbase-test"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, kid: "key-1", codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	m.mu.Unlock()
+
+	if !ok || CodeChallenge(r.Form.Get("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(grant.claims)})
+}
+
+func (m *mockProvider) sign(claims jwt.MapClaims) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed
+}
+
+// rotateKey replaces the signing key, as a provider does on key rotation.
+func (m *mockProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.key, m.kid = key, kid
+	m.mu.Unlock()
+}
+
+// authorize does what the provider's login page does: it records the PKCE
+// challenge from the authorization URL and issues a code for an ID token
+// with the given claims.
+func (m *mockProvider) authorize(authURL string, claims jwt.MapClaims) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	if claims["nonce"] == nil {
+		claims["nonce"] = q.Get("nonce")
+	}
+
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: claims}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockProvider) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "cat@example.com",
+		"email_verified": true,
+	}
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider("mock", m.server.URL+"/", testClientID, "secret", "https://app.example/callback", nil)
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+
+	tests := []struct {
+		name string
+		// modify changes the claims of the issued ID token.
+		modify func(jwt.MapClaims)
+		// verifier and nonce override what the client sends back, when set.
+		verifier string
+		nonce    string
+		wantErr  string
+	}{
+		{name: "valid login"},
+		{name: "PKCE verifier mismatch", verifier: "not-the-verifier", wantErr: "invalid_grant"},
+		{name: "nonce mismatch", nonce: "another-nonce", wantErr: "nonce mismatch"},
+		{name: "nonce replaced by provider", modify: func(c jwt.MapClaims) { c["nonce"] = "forged" }, wantErr: "nonce mismatch"},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: "invalid id_token"},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, wantErr: "invalid id_token"},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "invalid id_token"},
+		{name: "missing subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: "missing subject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := m.provider()
+			const state, nonce, verifier = "state-value", "nonce-value", "verifier-value-verifier-value-verifier-value"
+
+			authURL, err := p.AuthCodeURL(state, nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims := m.claims()
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			code := m.authorize(authURL, claims)
+
+			sentVerifier, sentNonce := verifier, nonce
+			if tt.verifier != "" {
+				sentVerifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				sentNonce = tt.nonce
+			}
+
+			got, err := p.Exchange(code, sentVerifier, sentNonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if got.Subject != "subject-1" || got.Email != "cat@example.com" || !got.EmailVerified {
+				t.Fatalf("Exchange() claims = %+v", got)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	authURL, err := m.provider().AuthCodeURL("s", "n", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          "https://app.example/callback",
+		"scope":                 "openid email profile",
+		"state":                 "s",
+		"nonce":                 "n",
+		"code_challenge":        CodeChallenge("v"),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+	if u.Path != "/authorize" {
+		t.Errorf("path = %q, want /authorize", u.Path)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	claims := m.claims()
+	claims["nonce"] = "n"
+	if _, err := p.VerifyIDToken(m.sign(claims), "n"); err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+
+	m.rotateKey("key-2")
+	if _, err := p.VerifyIDToken(m.sign(claims), "n"); err != nil {
+		t.Fatalf("VerifyIDToken() after rotation error = %v", err)
+	}
+}
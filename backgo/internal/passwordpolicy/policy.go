@@ -0,0 +1,286 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+// bcrypt ignores everything after 72 bytes, so longer passwords would give a
+// false sense of security.
+const maxBytes = 72
+
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleCharClasses  = "character_classes"
+	RuleStrength     = "strength"
+	RulePersonalInfo = "personal_info"
+	RuleCommon       = "common_password"
+)
+
+type Policy struct {
+	MinLength          int
+	MinClasses         int
+	MinScore           int
+	RejectPersonalInfo bool
+	RejectCommon       bool
+}
+
+func Default() Policy {
+	return Policy{
+		MinLength:          10,
+		MinClasses:         0,
+		MinScore:           2,
+		RejectPersonalInfo: true,
+		RejectCommon:       true,
+	}
+}
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is returned when a password breaks one or more rules of a Policy.
+type Error struct {
+	Score      int
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+	return "password does not meet policy: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks password against every rule and reports all violations at
+// once. personal holds values such as the username and email that must not
+// appear inside the password.
+func (p Policy) Validate(password string, personal ...string) error {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{RuleMinLength,
+			fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+	if len(password) > maxBytes {
+		violations = append(violations, Violation{RuleMaxLength,
+			fmt.Sprintf("must be at most %d bytes long", maxBytes)})
+	}
+
+	if p.MinClasses > 0 && CharClasses(password) < p.MinClasses {
+		violations = append(violations, Violation{RuleCharClasses,
+			fmt.Sprintf("must mix at least %d of: lower case, upper case, digits, symbols", p.MinClasses)})
+	}
+
+	lower := strings.ToLower(password)
+	if p.RejectCommon && IsCommon(lower) {
+		violations = append(violations, Violation{RuleCommon,
+			"is on a list of common or breached passwords"})
+	}
+
+	if p.RejectPersonalInfo {
+		for _, value := range personalTokens(personal) {
+			if strings.Contains(lower, value) {
+				violations = append(violations, Violation{RulePersonalInfo,
+					"must not contain your username or email"})
+				break
+			}
+		}
+	}
+
+	score := Score(password, personal...)
+	if score < p.MinScore {
+		violations = append(violations, Violation{RuleStrength,
+			fmt.Sprintf("is too easy to guess (strength %d of 4, need %d)", score, p.MinScore)})
+	}
+
+	if len(violations) > 0 {
+		return &Error{Score: score, Violations: violations}
+	}
+	return nil
+}
+
+func IsCommon(password string) bool {
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// CharClasses counts how many of lower case, upper case, digits and symbols
+// appear in password.
+func CharClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Score estimates how hard password is to guess on the 0-4 scale used by
+// zxcvbn. It starts from the brute-force entropy of the character pool and
+// discounts runs, keyboard or alphabet sequences, common passwords and
+// personal information.
+func Score(password string, personal ...string) int {
+	if password == "" {
+		return 0
+	}
+
+	lower := strings.ToLower(password)
+	if IsCommon(lower) || IsCommon(strings.TrimRightFunc(lower, unicode.IsDigit)) {
+		return 0
+	}
+
+	for _, value := range personalTokens(personal) {
+		lower = strings.ReplaceAll(lower, value, "\x00")
+	}
+
+	runes := []rune(lower)
+	effective := 0.0
+	for i, r := range runes {
+		switch {
+		case r == 0:
+			// A personal token counts as a single cheap guess.
+			effective += 0.5
+		case i > 0 && r == runes[i-1]:
+			effective += 0.25
+		case i > 0 && isSequential(runes[i-1], r):
+			effective += 0.35
+		default:
+			effective += 1
+		}
+	}
+
+	bits := effective * math.Log2(float64(poolSize(password)))
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 50:
+		return 2
+	case bits < 65:
+		return 3
+	default:
+		return 4
+	}
+}
+
+const keyboardRows = "qwertyuiop asdfghjkl zxcvbnm 1234567890"
+
+func isSequential(prev, cur rune) bool {
+	if cur-prev == 1 || prev-cur == 1 {
+		return true
+	}
+	i := strings.IndexRune(keyboardRows, prev)
+	return i >= 0 && i+1 < len(keyboardRows) && rune(keyboardRows[i+1]) == cur
+}
+
+func poolSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		// Thai and other scripts.
+		size += 80
+	}
+	if size < 2 {
+		size = 2
+	}
+	return size
+}
+
+// personalTokens splits usernames and emails into lower-case pieces that are
+// long enough to be meaningful, e.g. "jane.smith@example.com" yields
+// "jane.smith", "jane" and "smith".
+func personalTokens(values []string) []string {
+	var tokens []string
+	add := func(s string) {
+		if utf8.RuneCountInString(s) >= 3 {
+			tokens = append(tokens, s)
+		}
+	}
+
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+		}
+		add(value)
+		for _, part := range strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if part != value {
+				add(part)
+			}
+		}
+	}
+	return tokens
+}
+
+func loadCommonPasswords(data string) map[string]struct{} {
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,124 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		password  string
+		personal  []string
+		wantRules []string
+	}{
+		{name: "strong passphrase", policy: Default(), password: "correct horse battery staple"},
+		{name: "strong mixed password", policy: Default(), password: "Tq8#vLm2!pRz"},
+		{name: "too short", policy: Default(), password: "K9#tb!", wantRules: []string{RuleMinLength}},
+		{name: "common password", policy: Default(), password: "password123", wantRules: []string{RuleCommon, RuleStrength}},
+		{name: "common password in other case", policy: Default(), password: "PASSWORD123", wantRules: []string{RuleCommon, RuleStrength}},
+		{name: "keyboard run", policy: Default(), password: "qwertyuiop", wantRules: []string{RuleCommon, RuleStrength}},
+		{name: "repeated character", policy: Default(), password: "aaaaaaaaaaaa", wantRules: []string{RuleStrength}},
+		{
+			name: "contains username", policy: Default(), password: "whiskers-Tq8#vLm2",
+			personal: []string{"Whiskers", "tom@example.com"}, wantRules: []string{RulePersonalInfo},
+		},
+		{
+			name: "contains part of email", policy: Default(), password: "Tq8#smith#vLm2",
+			personal: []string{"cat", "jane.smith@example.com"}, wantRules: []string{RulePersonalInfo},
+		},
+		{
+			name: "short personal values are ignored", policy: Default(), password: "ab-Tq8#vLm2!pRz",
+			personal: []string{"ab"},
+		},
+		{
+			name: "personal info allowed by policy", policy: Policy{MinLength: 10, RejectPersonalInfo: false}, password: "whiskers-Tq8#vLm2",
+			personal: []string{"whiskers"},
+		},
+		{name: "over bcrypt limit", policy: Default(), password: strings.Repeat("Tq8#vLm2!", 9), wantRules: []string{RuleMaxLength}},
+		{
+			name: "too few classes", policy: Policy{MinLength: 8, MinClasses: 3}, password: "correcthorsebattery",
+			wantRules: []string{RuleCharClasses},
+		},
+		{name: "enough classes", policy: Policy{MinLength: 8, MinClasses: 3}, password: "Correct9horse"},
+		{name: "length counts runes not bytes", policy: Policy{MinLength: 10}, password: "ฟ้าฝนดินทรายลม"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password, tt.personal...)
+			if len(tt.wantRules) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			var policyErr *Error
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Validate() error = %v, want *Error", err)
+			}
+			var rules []string
+			for _, v := range policyErr.Violations {
+				rules = append(rules, v.Rule)
+			}
+			slices.Sort(rules)
+			want := slices.Clone(tt.wantRules)
+			slices.Sort(want)
+			if !slices.Equal(rules, want) {
+				t.Fatalf("violated rules = %v, want %v", rules, want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		password string
+		personal []string
+		min, max int
+	}{
+		{"", nil, 0, 0},
+		{"123456", nil, 0, 0},
+		{"Password2024", nil, 0, 0},
+		{"abcdefghij", nil, 0, 1},
+		{"Tq8#vLm2!pRz", nil, 4, 4},
+		{"correct horse battery staple", nil, 4, 4},
+		{"whiskers2024", []string{"whiskers"}, 0, 1},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.password, tt.personal...); got < tt.min || got > tt.max {
+			t.Errorf("Score(%q) = %d, want %d..%d", tt.password, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestCharClasses(t *testing.T) {
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcDEF", 2},
+		{"abc123", 2},
+		{"aB1!", 4},
+		{"ÉCOLE école", 3},
+	}
+	for _, tt := range tests {
+		if got := CharClasses(tt.password); got != tt.want {
+			t.Errorf("CharClasses(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestPersonalTokens(t *testing.T) {
+	got := personalTokens([]string{"  Jane.Smith@Example.com ", "al", "tom_cat"})
+	want := []string{"jane.smith", "jane", "smith", "tom_cat", "tom", "cat"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("personalTokens() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a token bucket: up to Burst requests at once, refilled at Limit
+// requests per Period.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// RefillRate is the number of tokens added per second.
+func (p Policy) RefillRate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+func (p Policy) Capacity() int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// Header renders the policy for the RateLimit-Policy response header,
+// e.g. `10;w=60`.
+func (p Policy) Header() string {
+	return fmt.Sprintf("%d;w=%d", p.Limit, int(p.Period.Seconds()))
+}
+
+// ParsePolicy reads specs like "10/1m" or "100/1h,burst=20".
+func ParsePolicy(name, spec string) (Policy, error) {
+	policy := Policy{Name: name}
+
+	parts := strings.Split(spec, ",")
+	rate := strings.SplitN(strings.TrimSpace(parts[0]), "/", 2)
+	if len(rate) != 2 {
+		return Policy{}, fmt.Errorf("rate limit %s: expected <limit>/<period>, got %q", name, spec)
+	}
+	limit, err := strconv.Atoi(rate[0])
+	if err != nil || limit <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %s: invalid limit %q", name, rate[0])
+	}
+	period, err := time.ParseDuration(rate[1])
+	if err != nil || period <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %s: invalid period %q", name, rate[1])
+	}
+	policy.Limit = limit
+	policy.Period = period
+
+	for _, option := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "burst":
+			burst, err := strconv.Atoi(value)
+			if err != nil || burst <= 0 {
+				return Policy{}, fmt.Errorf("rate limit %s: invalid burst %q", name, value)
+			}
+			policy.Burst = burst
+		default:
+			return Policy{}, fmt.Errorf("rate limit %s: unknown option %q", name, key)
+		}
+	}
+	return policy, nil
+}
+
+// Result describes the bucket after a request was counted.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the next request can succeed;
+	// zero when the request was allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// Store holds bucket state. Take must check and update a bucket atomically,
+// so that a shared implementation (e.g. a Redis script doing the same
+// arithmetic as MemoryStore) gives the same answers across instances.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
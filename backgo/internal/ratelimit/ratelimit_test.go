@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Policy
+		wantErr bool
+	}{
+		{spec: "10/1m", want: Policy{Name: "p", Limit: 10, Period: time.Minute}},
+		{spec: " 5/1h , burst=3", want: Policy{Name: "p", Limit: 5, Period: time.Hour, Burst: 3}},
+		{spec: "100/30s,burst=20", want: Policy{Name: "p", Limit: 100, Period: 30 * time.Second, Burst: 20}},
+		{spec: "10", wantErr: true},
+		{spec: "", wantErr: true},
+		{spec: "0/1m", wantErr: true},
+		{spec: "-1/1m", wantErr: true},
+		{spec: "ten/1m", wantErr: true},
+		{spec: "10/minute", wantErr: true},
+		{spec: "10/0s", wantErr: true},
+		{spec: "10/1m,burst=0", wantErr: true},
+		{spec: "10/1m,burst=x", wantErr: true},
+		{spec: "10/1m,window=5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePolicy("p", tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePolicy() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ParsePolicy() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{Limit: 10, Period: time.Minute}
+	if p.Capacity() != 10 || p.Header() != "10;w=60" {
+		t.Fatalf("Capacity() = %d, Header() = %q", p.Capacity(), p.Header())
+	}
+	p.Burst = 3
+	if p.Capacity() != 3 {
+		t.Fatalf("Capacity() with burst = %d, want 3", p.Capacity())
+	}
+	if got := p.RefillRate(); got < 0.1666 || got > 0.1667 {
+		t.Fatalf("RefillRate() = %v, want 1/6", got)
+	}
+}
+
+// takeStep is a request made after advancing the clock by advance.
+type takeStep struct {
+	advance       time.Duration
+	wantAllowed   bool
+	wantRemaining int
+	wantRetry     time.Duration
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	// 2 requests at once, refilled at one every 30 seconds.
+	policy := Policy{Name: "test", Limit: 2, Period: time.Minute}
+
+	tests := []struct {
+		name  string
+		steps []takeStep
+	}{
+		{
+			name: "burst then refused",
+			steps: []takeStep{
+				{0, true, 1, 0},
+				{0, true, 0, 0},
+				{0, false, 0, 30 * time.Second},
+				{10 * time.Second, false, 0, 20 * time.Second},
+			},
+		},
+		{
+			name: "refills one token per period share",
+			steps: []takeStep{
+				{0, true, 1, 0},
+				{0, true, 0, 0},
+				{30 * time.Second, true, 0, 0},
+				{0, false, 0, 30 * time.Second},
+			},
+		},
+		{
+			name: "refill never exceeds capacity",
+			steps: []takeStep{
+				{0, true, 1, 0},
+				{time.Hour, true, 1, 0},
+				{0, true, 0, 0},
+				{0, false, 0, 30 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1_700_000_000, 0)
+			store := NewMemoryStore()
+			store.now = func() time.Time { return now }
+
+			for i, step := range tt.steps {
+				now = now.Add(step.advance)
+				got, err := store.Take(context.Background(), "k", policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Allowed != step.wantAllowed || got.Remaining != step.wantRemaining || got.RetryAfter != step.wantRetry {
+					t.Fatalf("step %d: Take() = %+v, want allowed %v, remaining %d, retry after %v",
+						i, got, step.wantAllowed, step.wantRemaining, step.wantRetry)
+				}
+				if got.Limit != 2 {
+					t.Fatalf("step %d: Limit = %d, want 2", i, got.Limit)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreKeysAndSweep(t *testing.T) {
+	policy := Policy{Name: "test", Limit: 1, Period: time.Minute}
+	now := time.Unix(1_700_000_000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	store.lastSweep = now
+
+	for _, key := range []string{"a", "b"} {
+		if got, _ := store.Take(context.Background(), key, policy); !got.Allowed {
+			t.Fatalf("first request for %s refused", key)
+		}
+	}
+	if got, _ := store.Take(context.Background(), "a", policy); got.Allowed {
+		t.Fatal("second request for a allowed")
+	}
+	if got, _ := store.Take(context.Background(), "a", policy); got.ResetAfter != time.Minute {
+		t.Fatalf("ResetAfter = %v, want 1m", got.ResetAfter)
+	}
+
+	now = now.Add(2 * time.Minute)
+	store.Take(context.Background(), "c", policy)
+	if _, ok := store.buckets["a"]; ok {
+		t.Fatal("refilled bucket was not swept")
+	}
+	if len(store.buckets) != 1 {
+		t.Fatalf("%d buckets after sweep, want 1", len(store.buckets))
+	}
+}
@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 secret from RFC 6238 appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to the last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	lower, err := Code(strings.ToLower(rfcSecret), time.Unix(59, 0))
+	if err != nil || lower != "287082" {
+		t.Errorf("lower-case secret: Code() = %q, %v", lower, err)
+	}
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("Code() accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := func(offset time.Duration) string {
+		c, _ := Code(rfcSecret, now.Add(offset))
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		skew     int
+		wantOK   bool
+		wantStep int64
+	}{
+		{"current code", rfcSecret, code(0), 1, true, Step(now)},
+		{"code with spaces", rfcSecret, " " + code(0)[:3] + " " + code(0)[3:] + " ", 1, true, Step(now)},
+		{"previous step within skew", rfcSecret, code(-Period * time.Second), 1, true, Step(now) - 1},
+		{"next step within skew", rfcSecret, code(Period * time.Second), 1, true, Step(now) + 1},
+		{"two steps old beyond skew", rfcSecret, code(-2 * Period * time.Second), 1, false, 0},
+		{"previous step without skew", rfcSecret, code(-Period * time.Second), 0, false, 0},
+		{"wrong code", rfcSecret, "000000", 1, false, 0},
+		{"too short", rfcSecret, code(0)[:5], 1, false, 0},
+		{"too long", rfcSecret, code(0) + "1", 1, false, 0},
+		{"invalid secret", "not base32!", code(0), 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("Validate() = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if len(a) != 32 || a == b {
+		t.Fatalf("GenerateSecret() = %q, %q; want distinct 32-character secrets", a, b)
+	}
+	if _, err := Code(a, time.Now()); err != nil {
+		t.Fatalf("generated secret does not decode: %v", err)
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	got := KeyURI("Cat Breeds", "jane@example.com", rfcSecret)
+	if !strings.HasPrefix(got, "otpauth://totp/Cat%20Breeds:jane@example.com?") {
+		t.Fatalf("KeyURI() = %q", got)
+	}
+	if strings.Contains(got, "+") {
+		t.Fatalf("KeyURI() encodes spaces as +: %q", got)
+	}
+
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	for k, v := range map[string]string{"secret": rfcSecret, "issuer": "Cat Breeds", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
@@ -3,7 +3,17 @@ CREATE TABLE users (
     username VARCHAR(50) UNIQUE NOT NULL,
     email VARCHAR(100) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
+    display_name VARCHAR(100),
+    bio TEXT,
+    location VARCHAR(100),
+    avatar_url TEXT,
+    avatar_key TEXT,
     is_active BOOLEAN DEFAULT TRUE,
+    -- Set by an admin; password logins are refused until the user resets.
+    password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verified BOOLEAN DEFAULT FALSE,
+    email_verified_at TIMESTAMP WITH TIME ZONE,
+    token_version INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     last_login TIMESTAMP WITH TIME ZONE,
     CONSTRAINT chk_username_length CHECK (char_length(username) >= 3),
@@ -15,6 +25,9 @@ CREATE INDEX idx_users_email ON users(email);
 
 
 
+CREATE INDEX idx_users_created_at ON users(created_at);
+CREATE INDEX idx_users_last_login ON users(last_login);
+
 CREATE TABLE roles (
     id SERIAL PRIMARY KEY,
     name VARCHAR(50) UNIQUE NOT NULL,
@@ -24,6 +37,7 @@ CREATE TABLE roles (
 CREATE TABLE permissions (
     id SERIAL PRIMARY KEY,
     name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -45,14 +59,118 @@ CREATE TABLE role_permissions (
 CREATE TABLE refresh_tokens (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    token VARCHAR(500) UNIQUE NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    replaced_by INTEGER REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+    user_agent TEXT,
+    ip_address VARCHAR(45),
     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    mfa_verified BOOLEAN NOT NULL DEFAULT FALSE,
     revoked_at TIMESTAMP WITH TIME ZONE
 );
 
-CREATE INDEX idx_refresh_tokens_token ON refresh_tokens(token);
 CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+
+
+
+CREATE TABLE user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(30) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT chk_user_token_purpose CHECK (purpose IN ('password_reset', 'email_verification'))
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id);
+
+
+
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP WITH TIME ZONE,
+
+    CONSTRAINT unique_provider_subject UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+CREATE TABLE oidc_login_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce VARCHAR(100) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+
+CREATE TABLE user_mfa (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    totp_secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    confirmed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) UNIQUE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) UNIQUE NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit_per_minute INTEGER,
+    -- Whether the key was created from an MFA-verified session. Only such
+    -- keys can reach routes that require MFA.
+    mfa_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    last_used_ip VARCHAR(45),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
+
+
+
+CREATE TABLE login_failures (
+    scope VARCHAR(10) NOT NULL,
+    key VARCHAR(100) NOT NULL,
+    failure_count INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    blocked_until TIMESTAMP WITH TIME ZONE,
+    locked BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (scope, key),
+
+    CONSTRAINT chk_login_failure_scope CHECK (scope IN ('username', 'ip'))
+);
 
 
 
@@ -65,12 +183,35 @@ CREATE TABLE audit_logs (
     details JSONB,
     ip_address VARCHAR(45),
     user_agent TEXT,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- Each entry hashes its content together with the previous entry's hash,
+    -- so editing or deleting a row breaks the chain from that point on.
+    prev_hash CHAR(64),
+    entry_hash CHAR(64)
 );
 
 CREATE INDEX idx_audit_logs_user_id ON audit_logs(user_id);
 CREATE INDEX idx_audit_logs_action ON audit_logs(action);
 CREATE INDEX idx_audit_logs_created_at ON audit_logs(created_at);
+CREATE INDEX idx_audit_logs_resource ON audit_logs(resource, resource_id);
+
+
+CREATE TABLE user_sanctions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(30) NOT NULL CHECK (type IN ('mute', 'shadow_ban', 'zero_reaction_weight')),
+    reason TEXT NOT NULL,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    -- NULL means the sanction lasts until it is lifted.
+    expires_at TIMESTAMP WITH TIME ZONE,
+    -- Set when the sanction is lifted or its expiry has been processed.
+    ended_at TIMESTAMP WITH TIME ZONE,
+    lifted_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_user_sanctions_user_id ON user_sanctions(user_id);
+CREATE INDEX idx_user_sanctions_open ON user_sanctions(user_id, type) WHERE ended_at IS NULL;
 
 
 
@@ -82,7 +223,10 @@ CREATE TABLE cat_breeds (
     appearance TEXT,
     temperament TEXT,
     care_instructions TEXT,
+    -- The primary breed_images row's URL and thumbnails, kept in step by
+    -- trigger_breed_primary_image for lists that show a single image.
     image_url TEXT,
+    image_thumbnails JSONB NOT NULL DEFAULT '{}',
     
 
     like_count INTEGER DEFAULT 0,
@@ -103,6 +247,50 @@ CREATE INDEX idx_cat_breeds_created_at ON cat_breeds(created_at);
 
 
 
+CREATE TABLE breed_images (
+    id SERIAL PRIMARY KEY,
+    breed_id INTEGER NOT NULL REFERENCES cat_breeds(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- Blob store keys of an uploaded image and its thumbnails, deleted from
+    -- the store with the row. Empty for images hosted elsewhere.
+    blob_keys TEXT[] NOT NULL DEFAULT '{}',
+    -- Thumbnail URLs by width, e.g. {"320": "/media/breeds/1/...-320.jpg"}.
+    thumbnails JSONB NOT NULL DEFAULT '{}',
+    width INTEGER,
+    height INTEGER,
+    caption VARCHAR(500) NOT NULL DEFAULT '',
+    alt_text VARCHAR(500) NOT NULL DEFAULT '',
+    credit VARCHAR(255) NOT NULL DEFAULT '',
+    license VARCHAR(100) NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT unique_breed_image_url UNIQUE (breed_id, url)
+);
+
+CREATE INDEX idx_breed_images_breed_id ON breed_images(breed_id, sort_order, id);
+-- At most one primary image per breed.
+CREATE UNIQUE INDEX idx_breed_images_primary ON breed_images(breed_id) WHERE is_primary;
+
+
+
+CREATE TABLE user_cats (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    breed_id INTEGER NOT NULL REFERENCES cat_breeds(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT chk_user_cat_name_not_empty CHECK (char_length(name) > 0)
+);
+
+CREATE INDEX idx_user_cats_user_id ON user_cats(user_id);
+
+
+
 CREATE TYPE reaction_type_enum AS ENUM ('like', 'dislike');
 
 CREATE TABLE breed_reactions (
@@ -110,6 +298,8 @@ CREATE TABLE breed_reactions (
     breed_id INTEGER NOT NULL REFERENCES cat_breeds(id) ON DELETE CASCADE,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     reaction_type reaction_type_enum NOT NULL,
+    -- 0 while the user's reaction weight is zeroed by a sanction.
+    weight SMALLINT NOT NULL DEFAULT 1,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     
@@ -139,6 +329,16 @@ CREATE TABLE discussions (
     is_deleted BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    -- Held and rejected discussions are only visible to their author.
+    -- Flagged ones are public but waiting for a moderator to look at them.
+    moderation_status VARCHAR(20) NOT NULL DEFAULT 'visible'
+        CHECK (moderation_status IN ('visible', 'flagged', 'held', 'rejected')),
+    moderation_findings JSONB,
+    -- Hash of the normalised message, for spotting the same text posted again.
+    message_fingerprint CHAR(64),
+    -- MinHash signature of a review's text, for spotting near-duplicates.
+    minhash BIGINT[],
     
     CONSTRAINT chk_message_not_empty CHECK (char_length(message) > 0)
 );
@@ -147,6 +347,52 @@ CREATE INDEX idx_discussions_breed_id ON discussions(breed_id);
 CREATE INDEX idx_discussions_user_id ON discussions(user_id);
 CREATE INDEX idx_discussions_parent_id ON discussions(parent_id);
 CREATE INDEX idx_discussions_created_at ON discussions(created_at);
+CREATE INDEX idx_discussions_fingerprint ON discussions(user_id, message_fingerprint);
+CREATE INDEX idx_discussions_queued ON discussions(created_at) WHERE moderation_status IN ('held', 'flagged');
+
+-- Locality-sensitive hashes of each review's MinHash signature. Reviews
+-- sharing a band hash are candidates for a near-duplicate comparison.
+CREATE TABLE discussion_minhash_bands (
+    discussion_id INTEGER NOT NULL REFERENCES discussions(id) ON DELETE CASCADE,
+    band SMALLINT NOT NULL,
+    hash BIGINT NOT NULL,
+    PRIMARY KEY (discussion_id, band)
+);
+
+CREATE INDEX idx_discussion_minhash_bands_hash ON discussion_minhash_bands(band, hash);
+
+
+-- Words the content filter looks for in discussions. Thai words match
+-- anywhere, other words only as whole words.
+CREATE TABLE moderation_words (
+    id SERIAL PRIMARY KEY,
+    word VARCHAR(100) NOT NULL UNIQUE,
+    language VARCHAR(2) NOT NULL CHECK (language IN ('en', 'th')),
+    action VARCHAR(10) NOT NULL DEFAULT 'mask' CHECK (action IN ('mask', 'flag', 'hold', 'reject')),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO moderation_words (word, language, action) VALUES
+('fuck', 'en', 'mask'),
+('motherfucker', 'en', 'mask'),
+('shit', 'en', 'mask'),
+('bitch', 'en', 'mask'),
+('bastard', 'en', 'mask'),
+('asshole', 'en', 'mask'),
+('dick', 'en', 'mask'),
+('cunt', 'en', 'hold'),
+('whore', 'en', 'hold'),
+('slut', 'en', 'hold'),
+('ควย', 'th', 'mask'),
+('เหี้ย', 'th', 'mask'),
+('สัส', 'th', 'mask'),
+('เย็ด', 'th', 'mask'),
+('ชิบหาย', 'th', 'mask'),
+('ระยำ', 'th', 'mask'),
+('ไอ้สัตว์', 'th', 'mask'),
+('อีดอก', 'th', 'hold'),
+('กะหรี่', 'th', 'hold');
 
 
 
@@ -155,6 +401,7 @@ CREATE TABLE discussion_reactions (
     discussion_id INTEGER NOT NULL REFERENCES discussions(id) ON DELETE CASCADE,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     reaction_type reaction_type_enum NOT NULL,
+    weight SMALLINT NOT NULL DEFAULT 1,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     
 
@@ -188,36 +435,64 @@ CREATE TRIGGER update_discussions_modtime
     BEFORE UPDATE ON discussions
     FOR EACH ROW EXECUTE FUNCTION update_modified_column();
 
+CREATE TRIGGER update_breed_images_modtime
+    BEFORE UPDATE ON breed_images
+    FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+
+
+CREATE OR REPLACE FUNCTION update_breed_primary_image()
+RETURNS TRIGGER AS $$
+DECLARE
+    target_breed INTEGER;
+    primary_image RECORD;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target_breed := OLD.breed_id;
+    ELSE
+        target_breed := NEW.breed_id;
+    END IF;
+
+    SELECT url, thumbnails INTO primary_image
+    FROM breed_images
+    WHERE breed_id = target_breed AND is_primary;
+
+    -- Reordering touches every row of a gallery; only write when the
+    -- primary image actually changed, so updated_at stays meaningful.
+    UPDATE cat_breeds
+    SET image_url = primary_image.url,
+        image_thumbnails = COALESCE(primary_image.thumbnails, '{}')
+    WHERE id = target_breed
+      AND (image_url IS DISTINCT FROM primary_image.url
+           OR image_thumbnails IS DISTINCT FROM COALESCE(primary_image.thumbnails, '{}'));
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_breed_primary_image
+AFTER INSERT OR UPDATE OR DELETE ON breed_images
+FOR EACH ROW EXECUTE FUNCTION update_breed_primary_image();
+
 
 CREATE OR REPLACE FUNCTION update_breed_reaction_count()
 RETURNS TRIGGER AS $$
 BEGIN
-    IF (TG_OP = 'INSERT') THEN
-        IF NEW.reaction_type = 'like' THEN
-            UPDATE cat_breeds SET like_count = like_count + 1 WHERE id = NEW.breed_id;
-        ELSE
-            UPDATE cat_breeds SET dislike_count = dislike_count + 1 WHERE id = NEW.breed_id;
-        END IF;
-        RETURN NEW;
-    ELSIF (TG_OP = 'UPDATE') THEN
-
-        IF OLD.reaction_type = 'like' AND NEW.reaction_type = 'dislike' THEN
-            UPDATE cat_breeds SET like_count = like_count - 1, dislike_count = dislike_count + 1 
-            WHERE id = NEW.breed_id;
-        ELSIF OLD.reaction_type = 'dislike' AND NEW.reaction_type = 'like' THEN
-            UPDATE cat_breeds SET dislike_count = dislike_count - 1, like_count = like_count + 1 
-            WHERE id = NEW.breed_id;
-        END IF;
+    -- Each reaction counts for its weight, so a change of type or weight is
+    -- applied by taking the old row out and putting the new one in.
+    IF (TG_OP = 'UPDATE' OR TG_OP = 'DELETE') THEN
+        UPDATE cat_breeds SET
+            like_count = like_count - CASE WHEN OLD.reaction_type = 'like' THEN OLD.weight ELSE 0 END,
+            dislike_count = dislike_count - CASE WHEN OLD.reaction_type = 'dislike' THEN OLD.weight ELSE 0 END
+        WHERE id = OLD.breed_id;
+    END IF;
+    IF (TG_OP = 'INSERT' OR TG_OP = 'UPDATE') THEN
+        UPDATE cat_breeds SET
+            like_count = like_count + CASE WHEN NEW.reaction_type = 'like' THEN NEW.weight ELSE 0 END,
+            dislike_count = dislike_count + CASE WHEN NEW.reaction_type = 'dislike' THEN NEW.weight ELSE 0 END
+        WHERE id = NEW.breed_id;
         RETURN NEW;
-    ELSIF (TG_OP = 'DELETE') THEN
-        IF OLD.reaction_type = 'like' THEN
-            UPDATE cat_breeds SET like_count = like_count - 1 WHERE id = OLD.breed_id;
-        ELSE
-            UPDATE cat_breeds SET dislike_count = dislike_count - 1 WHERE id = OLD.breed_id;
-        END IF;
-        RETURN OLD;
     END IF;
-    RETURN NULL;
+    RETURN OLD;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -229,31 +504,22 @@ FOR EACH ROW EXECUTE FUNCTION update_breed_reaction_count();
 CREATE OR REPLACE FUNCTION update_discussion_reaction_count()
 RETURNS TRIGGER AS $$
 BEGIN
-    IF (TG_OP = 'INSERT') THEN
-        IF NEW.reaction_type = 'like' THEN
-            UPDATE discussions SET like_count = like_count + 1 WHERE id = NEW.discussion_id;
-        ELSE
-            UPDATE discussions SET dislike_count = dislike_count + 1 WHERE id = NEW.discussion_id;
-        END IF;
-        RETURN NEW;
-    ELSIF (TG_OP = 'UPDATE') THEN
-        IF OLD.reaction_type = 'like' AND NEW.reaction_type = 'dislike' THEN
-            UPDATE discussions SET like_count = like_count - 1, dislike_count = dislike_count + 1 
-            WHERE id = NEW.discussion_id;
-        ELSIF OLD.reaction_type = 'dislike' AND NEW.reaction_type = 'like' THEN
-            UPDATE discussions SET dislike_count = dislike_count - 1, like_count = like_count + 1 
-            WHERE id = NEW.discussion_id;
-        END IF;
+    -- Each reaction counts for its weight, so a change of type or weight is
+    -- applied by taking the old row out and putting the new one in.
+    IF (TG_OP = 'UPDATE' OR TG_OP = 'DELETE') THEN
+        UPDATE discussions SET
+            like_count = like_count - CASE WHEN OLD.reaction_type = 'like' THEN OLD.weight ELSE 0 END,
+            dislike_count = dislike_count - CASE WHEN OLD.reaction_type = 'dislike' THEN OLD.weight ELSE 0 END
+        WHERE id = OLD.discussion_id;
+    END IF;
+    IF (TG_OP = 'INSERT' OR TG_OP = 'UPDATE') THEN
+        UPDATE discussions SET
+            like_count = like_count + CASE WHEN NEW.reaction_type = 'like' THEN NEW.weight ELSE 0 END,
+            dislike_count = dislike_count + CASE WHEN NEW.reaction_type = 'dislike' THEN NEW.weight ELSE 0 END
+        WHERE id = NEW.discussion_id;
         RETURN NEW;
-    ELSIF (TG_OP = 'DELETE') THEN
-        IF OLD.reaction_type = 'like' THEN
-            UPDATE discussions SET like_count = like_count - 1 WHERE id = OLD.discussion_id;
-        ELSE
-            UPDATE discussions SET dislike_count = dislike_count - 1 WHERE id = OLD.discussion_id;
-        END IF;
-        RETURN OLD;
     END IF;
-    RETURN NULL;
+    RETURN OLD;
 END;
 $$ LANGUAGE plpgsql;
 
@@ -291,36 +557,46 @@ FOR EACH ROW EXECUTE FUNCTION update_breed_discussion_count();
 
 INSERT INTO roles (name) VALUES
 ('admin'),
-('user');
+('user'),
+('moderator');
+
 
+INSERT INTO permissions (name, description) VALUES
 
-INSERT INTO permissions (name) VALUES
+('breeds:write', 'Create, update and delete cat breeds'),
 
-('breed.create'),
-('breed.update'),
-('breed.delete'),
-('breed.view'),
 
+('discussions:write', 'Post, edit and delete own discussions'),
+('discussions:moderate', 'Delete any discussion'),
 
-('discussion.create'),
-('discussion.update'),
-('discussion.delete'),
-('discussion.delete.any'),
 
+('reactions:write', 'React to breeds and discussions'),
+('profile:write', 'Edit own profile and avatar'),
 
-('reaction.create');
+
+('users:manage', 'View, activate and deactivate users, unlock accounts, force logouts and password resets'),
+('roles:manage', 'Manage roles, permissions and role assignments'),
+('audit:read', 'Search and export the audit log'),
+('users:sanction', 'Mute, shadow-ban and zero the reaction weight of users');
 
 
 INSERT INTO role_permissions (role_id, permission_id)
 SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin';
 
 
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'moderator' AND p.name IN (
+    'discussions:write', 'discussions:moderate', 'users:sanction',
+    'reactions:write', 'profile:write'
+);
+
+
 INSERT INTO role_permissions (role_id, permission_id)
 SELECT r.id, p.id FROM roles r, permissions p
 WHERE r.name = 'user' AND p.name IN (
-    'breed.view',
-    'discussion.create', 'discussion.update', 'discussion.delete',
-    'reaction.create'
+    'discussions:write',
+    'reactions:write', 'profile:write'
 );
 
 
@@ -435,6 +711,19 @@ Breeder มุ่งพัฒนาให้ Ragdoll มีร่างกาย
 );
 
 
+-- Each breed's image_url becomes the primary image of its gallery. Kept in
+-- step with upgrade/050_breed_images.sql, which does the same for existing
+-- databases.
+INSERT INTO breed_images (breed_id, url, alt_text, is_primary)
+SELECT cb.id, cb.image_url, cb.name, TRUE
+FROM cat_breeds cb
+WHERE COALESCE(cb.image_url, '') <> ''
+  AND NOT EXISTS (
+      SELECT 1 FROM breed_images bi
+      WHERE bi.breed_id = cb.id AND (bi.is_primary OR bi.url = cb.image_url)
+  );
+
+
 INSERT INTO users (username, email, password_hash, is_active) VALUES
 -- Admin user (password: admin123)
 ('admin', 'admin@catbreeds.com', '$2a$12$Jh17GEOUujYkjq/l/8JFsuSL.6xNamnMKVPWmyHskZZZUGU24Gbwq', true),
@@ -452,5 +741,7 @@ INSERT INTO user_roles (user_id, role_id) VALUES
 
 
 UPDATE users
-SET last_login = CURRENT_TIMESTAMP
+SET last_login = CURRENT_TIMESTAMP,
+    email_verified = TRUE,
+    email_verified_at = CURRENT_TIMESTAMP
 WHERE id IN (1, 2, 3);
\ No newline at end of file
@@ -0,0 +1,40 @@
+-- Upgrades a database created before email verification. It is safe to run
+-- more than once; new databases get all of this from init.sql.
+--
+--   psql -v ON_ERROR_STOP=1 -d catbase -f upgrade/028_email_verification.sql
+--
+-- Logins are refused for unverified addresses while REQUIRE_EMAIL_VERIFICATION
+-- is on, so accounts that predate verification are marked verified when the
+-- columns are added. Running the script again leaves accounts registered
+-- since then as they are.
+
+BEGIN;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'email_verified'
+    ) THEN
+        ALTER TABLE users ADD COLUMN email_verified BOOLEAN DEFAULT FALSE;
+        ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+        UPDATE users SET email_verified = TRUE, email_verified_at = CURRENT_TIMESTAMP;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(30) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT chk_user_token_purpose CHECK (purpose IN ('password_reset', 'email_verification'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id);
+
+COMMIT;
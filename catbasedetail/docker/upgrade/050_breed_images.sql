@@ -0,0 +1,93 @@
+-- Upgrades a database created before breed galleries. It is safe to run
+-- more than once; new databases get all of this from init.sql.
+--
+--   psql -v ON_ERROR_STOP=1 -d catbase -f upgrade/050_breed_images.sql
+
+BEGIN;
+
+ALTER TABLE cat_breeds ADD COLUMN IF NOT EXISTS image_thumbnails JSONB NOT NULL DEFAULT '{}';
+-- Databases from before galleries may have the keys of an uploaded image
+-- here; they move to its gallery row below and the column is dropped.
+ALTER TABLE cat_breeds ADD COLUMN IF NOT EXISTS image_keys TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS breed_images (
+    id SERIAL PRIMARY KEY,
+    breed_id INTEGER NOT NULL REFERENCES cat_breeds(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    -- Blob store keys of an uploaded image and its thumbnails, deleted from
+    -- the store with the row. Empty for images hosted elsewhere.
+    blob_keys TEXT[] NOT NULL DEFAULT '{}',
+    -- Thumbnail URLs by width, e.g. {"320": "/media/breeds/1/...-320.jpg"}.
+    thumbnails JSONB NOT NULL DEFAULT '{}',
+    width INTEGER,
+    height INTEGER,
+    caption VARCHAR(500) NOT NULL DEFAULT '',
+    alt_text VARCHAR(500) NOT NULL DEFAULT '',
+    credit VARCHAR(255) NOT NULL DEFAULT '',
+    license VARCHAR(100) NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT unique_breed_image_url UNIQUE (breed_id, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_breed_images_breed_id ON breed_images(breed_id, sort_order, id);
+-- At most one primary image per breed.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_breed_images_primary ON breed_images(breed_id) WHERE is_primary;
+
+DROP TRIGGER IF EXISTS update_breed_images_modtime ON breed_images;
+CREATE TRIGGER update_breed_images_modtime
+    BEFORE UPDATE ON breed_images
+    FOR EACH ROW EXECUTE FUNCTION update_modified_column();
+
+CREATE OR REPLACE FUNCTION update_breed_primary_image()
+RETURNS TRIGGER AS $$
+DECLARE
+    target_breed INTEGER;
+    primary_image RECORD;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target_breed := OLD.breed_id;
+    ELSE
+        target_breed := NEW.breed_id;
+    END IF;
+
+    SELECT url, thumbnails INTO primary_image
+    FROM breed_images
+    WHERE breed_id = target_breed AND is_primary;
+
+    -- Reordering touches every row of a gallery; only write when the
+    -- primary image actually changed, so updated_at stays meaningful.
+    UPDATE cat_breeds
+    SET image_url = primary_image.url,
+        image_thumbnails = COALESCE(primary_image.thumbnails, '{}')
+    WHERE id = target_breed
+      AND (image_url IS DISTINCT FROM primary_image.url
+           OR image_thumbnails IS DISTINCT FROM COALESCE(primary_image.thumbnails, '{}'));
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trigger_breed_primary_image ON breed_images;
+CREATE TRIGGER trigger_breed_primary_image
+AFTER INSERT OR UPDATE OR DELETE ON breed_images
+FOR EACH ROW EXECUTE FUNCTION update_breed_primary_image();
+
+-- Each breed's image_url becomes the primary image of its gallery, unless
+-- the gallery already has a primary image or that URL.
+INSERT INTO breed_images (breed_id, url, blob_keys, thumbnails, alt_text, is_primary)
+SELECT cb.id, cb.image_url, cb.image_keys, cb.image_thumbnails, cb.name, TRUE
+FROM cat_breeds cb
+WHERE COALESCE(cb.image_url, '') <> ''
+  AND NOT EXISTS (
+      SELECT 1 FROM breed_images bi
+      WHERE bi.breed_id = cb.id AND (bi.is_primary OR bi.url = cb.image_url)
+  );
+
+ALTER TABLE cat_breeds DROP COLUMN image_keys;
+
+COMMIT;